@@ -0,0 +1,70 @@
+// accrual-mock — имитация системы расчёта начислений для локальной разработки
+// и интеграционных тестов. Ответы задаются сценариями, см. пакет accrualmock.
+//
+//	accrual-mock -a :8081 -scenarios scenarios.json
+//
+// Сценарии можно загрузить и на ходу: POST /control/scenarios с JSON-массивом.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/accrual/accrualmock"
+	"github.com/g123udini/gofemart/internal/service"
+)
+
+func main() {
+	addr := flag.String("a", envOr("RUN_ADDRESS", ":8081"), "address and port to run the mock")
+	scenarios := flag.String("scenarios", os.Getenv("ACCRUAL_MOCK_SCENARIOS"), "JSON file with scenarios to load at start")
+	logLevel := flag.String("log-level", envOr("LOG_LEVEL", "info"), "log level")
+	flag.Parse()
+
+	logger, err := service.NewLoggerConfig(*logLevel, "console")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() { _ = logger.Sync() }()
+
+	mock := accrualmock.New(accrualmock.WithLogger(logger))
+	if *scenarios != "" {
+		if err := mock.LoadFile(*scenarios); err != nil {
+			logger.Fatalw("load scenarios", "error", err)
+		}
+	}
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           service.RequestLogger(logger)(mock),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(sctx)
+	}()
+
+	logger.Infow("accrual mock is running", "address", *addr, "scenarios", *scenarios)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Fatalw("accrual mock stopped", "error", err)
+	}
+}
+
+func envOr(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,100 @@
+// accrual — система расчёта начислений баллов лояльности: принимает чеки и
+// правила вознаграждения и считает начисления, которые забирает gophermart.
+//
+//	accrual -a :8081 -d postgres://... -rate-limit 600
+//
+// Без -d данные хранятся в памяти и теряются при перезапуске.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/accrualserver"
+	"github.com/g123udini/gofemart/internal/service"
+)
+
+func main() {
+	addr := flag.String("a", envOr("RUN_ADDRESS", ":8081"), "address and port to run the service")
+	dsn := flag.String("d", os.Getenv("DATABASE_URI"), "database connection string, empty keeps data in memory")
+	rateLimit := flag.Int("rate-limit", envInt("ACCRUAL_RATE_LIMIT", 600), "requests per minute per client, 0 means unlimited")
+	every := flag.Duration("calc-every", time.Second, "how often to check for orders to calculate")
+	logLevel := flag.String("log-level", envOr("LOG_LEVEL", "info"), "log level")
+	flag.Parse()
+
+	logger, err := service.NewLoggerConfig(*logLevel, "json")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() { _ = logger.Sync() }()
+
+	var store accrualserver.Store
+	if *dsn == "" {
+		logger.Warn("DATABASE_URI is not set, keeping data in memory")
+		store = accrualserver.NewMemory()
+	} else {
+		pg, err := accrualserver.OpenPostgres(*dsn)
+		if err != nil {
+			logger.Fatalw("open database", "error", err)
+		}
+		defer pg.DB.Close()
+		if err := pg.Migrate(); err != nil {
+			logger.Fatalw("apply migrations", "error", err)
+		}
+		store = pg
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	processor := accrualserver.NewProcessor(store, logger)
+	processor.Every = *every
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		processor.Run(ctx)
+	}()
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           accrualserver.NewRouter(store, processor, accrualserver.NewRateLimiter(*rateLimit), logger),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(sctx)
+	}()
+
+	logger.Infow("accrual service is running", "address", *addr, "rate_limit", *rateLimit)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Errorw("accrual service stopped", "error", err)
+	}
+	stop()
+	<-done
+}
+
+func envOr(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+func envInt(key string, def int) int {
+	if v, ok := os.LookupEnv(key); ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
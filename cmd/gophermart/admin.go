@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/config"
+	"github.com/g123udini/gofemart/internal/model"
+	"github.com/g123udini/gofemart/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// adminRepo — то, что нужно командам gophermart admin от repository.Repo.
+type adminRepo interface {
+	GetUserByLogin(ctx context.Context, login string) (*model.User, error)
+	CreateUser(ctx context.Context, login, passwordHash string, dryRun bool) (*model.User, error)
+	DisableUser(ctx context.Context, login string, dryRun bool) (*model.User, error)
+	RevokeSessions(ctx context.Context, login string, dryRun bool) (time.Time, error)
+	AdjustBalance(ctx context.Context, login string, amount int, reason string, dryRun bool) (model.Balance, error)
+	GetLedger(ctx context.Context, userID int, limit int) ([]model.LedgerEntry, error)
+	RequeueOrder(ctx context.Context, number int64, dryRun bool) (*model.Order, error)
+	ListStuckOrders(ctx context.Context, olderThan time.Duration, limit int) ([]model.StuckOrder, error)
+	ListQuarantine(ctx context.Context, limit int) ([]model.QuarantineEntry, error)
+	ResolveQuarantine(ctx context.Context, number int64, resolution string, dryRun bool) (*model.Order, error)
+}
+
+var errUsage = errors.New("usage")
+
+// adminOutput — результат команды: JSON-представление для --json и текст для человека.
+type adminOutput struct {
+	JSON any
+	Text func(w io.Writer)
+}
+
+type adminAction func(ctx context.Context, repo adminRepo, dryRun bool) (adminOutput, error)
+
+type adminCommand struct {
+	summary string
+	// flags регистрирует флаги команды и возвращает действие, читающее их после разбора.
+	flags func(fs *flag.FlagSet) adminAction
+}
+
+var adminCommands = map[string]adminCommand{
+	"user-create": {
+		summary: "create a user",
+		flags: func(fs *flag.FlagSet) adminAction {
+			login := fs.String("login", "", "user login")
+			password := fs.String("password", "", "user password")
+			return func(ctx context.Context, repo adminRepo, dryRun bool) (adminOutput, error) {
+				if *login == "" || *password == "" {
+					return adminOutput{}, fmt.Errorf("%w: -login and -password are required", errUsage)
+				}
+				hash, err := bcrypt.GenerateFromPassword([]byte(*password), bcrypt.DefaultCost)
+				if err != nil {
+					return adminOutput{}, err
+				}
+				u, err := repo.CreateUser(ctx, *login, string(hash), dryRun)
+				if err != nil {
+					return adminOutput{}, err
+				}
+				return adminOutput{
+					JSON: map[string]any{"id": u.ID, "login": u.Login},
+					Text: func(w io.Writer) { fmt.Fprintf(w, "created user %q (id %d)\n", u.Login, u.ID) },
+				}, nil
+			}
+		},
+	},
+	"user-disable": {
+		summary: "disable a user and revoke their sessions",
+		flags: func(fs *flag.FlagSet) adminAction {
+			login := fs.String("login", "", "user login")
+			return func(ctx context.Context, repo adminRepo, dryRun bool) (adminOutput, error) {
+				if *login == "" {
+					return adminOutput{}, fmt.Errorf("%w: -login is required", errUsage)
+				}
+				u, err := repo.DisableUser(ctx, *login, dryRun)
+				if err != nil {
+					return adminOutput{}, err
+				}
+				return adminOutput{
+					JSON: map[string]any{"id": u.ID, "login": u.Login, "disabled_at": u.DisabledAt},
+					Text: func(w io.Writer) { fmt.Fprintf(w, "disabled user %q (id %d)\n", u.Login, u.ID) },
+				}, nil
+			}
+		},
+	},
+	"sessions-revoke": {
+		summary: "revoke all sessions of a user",
+		flags: func(fs *flag.FlagSet) adminAction {
+			login := fs.String("login", "", "user login")
+			return func(ctx context.Context, repo adminRepo, dryRun bool) (adminOutput, error) {
+				if *login == "" {
+					return adminOutput{}, fmt.Errorf("%w: -login is required", errUsage)
+				}
+				at, err := repo.RevokeSessions(ctx, *login, dryRun)
+				if err != nil {
+					return adminOutput{}, err
+				}
+				return adminOutput{
+					JSON: map[string]any{"login": *login, "revoked_at": at},
+					Text: func(w io.Writer) {
+						fmt.Fprintf(w, "revoked sessions of %q opened before %s\n", *login, at.Format(time.RFC3339))
+					},
+				}, nil
+			}
+		},
+	},
+	"balance": {
+		summary: "show user balance",
+		flags: func(fs *flag.FlagSet) adminAction {
+			login := fs.String("login", "", "user login")
+			return func(ctx context.Context, repo adminRepo, _ bool) (adminOutput, error) {
+				u, err := findUser(ctx, repo, *login)
+				if err != nil {
+					return adminOutput{}, err
+				}
+				return adminOutput{
+					JSON: map[string]any{"login": u.Login, "balance": u.Balance, "disabled": u.Disabled()},
+					Text: func(w io.Writer) {
+						fmt.Fprintf(w, "user:      %s (id %d)\n", u.Login, u.ID)
+						fmt.Fprintf(w, "current:   %s\n", formatCents(u.Balance.Current))
+						fmt.Fprintf(w, "withdrawn: %s\n", formatCents(u.Balance.Withdrawn))
+						if u.Disabled() {
+							fmt.Fprintf(w, "disabled:  %s\n", u.DisabledAt.Format(time.RFC3339))
+						}
+					},
+				}, nil
+			}
+		},
+	},
+	"ledger": {
+		summary: "show accruals, withdrawals and manual adjustments of a user",
+		flags: func(fs *flag.FlagSet) adminAction {
+			login := fs.String("login", "", "user login")
+			limit := fs.Int("limit", 50, "max entries")
+			return func(ctx context.Context, repo adminRepo, _ bool) (adminOutput, error) {
+				u, err := findUser(ctx, repo, *login)
+				if err != nil {
+					return adminOutput{}, err
+				}
+				entries, err := repo.GetLedger(ctx, u.ID, *limit)
+				if err != nil {
+					return adminOutput{}, err
+				}
+				return adminOutput{
+					JSON: entries,
+					Text: func(w io.Writer) {
+						tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+						fmt.Fprintln(tw, "AT\tKIND\tAMOUNT\tREFERENCE")
+						for _, e := range entries {
+							fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", e.At.Format(time.RFC3339), e.Kind, formatCents(e.Amount), e.Reference)
+						}
+						_ = tw.Flush()
+					},
+				}, nil
+			}
+		},
+	},
+	"credit": {
+		summary: "manually credit points to a user",
+		flags:   adjustFlags(1),
+	},
+	"debit": {
+		summary: "manually debit points from a user",
+		flags:   adjustFlags(-1),
+	},
+	"order-repoll": {
+		summary: "move an order back to NEW so the accrual worker polls it again",
+		flags: func(fs *flag.FlagSet) adminAction {
+			number := fs.Int64("number", 0, "order number")
+			return func(ctx context.Context, repo adminRepo, dryRun bool) (adminOutput, error) {
+				if *number <= 0 {
+					return adminOutput{}, fmt.Errorf("%w: -number is required", errUsage)
+				}
+				o, err := repo.RequeueOrder(ctx, *number, dryRun)
+				if err != nil {
+					return adminOutput{}, err
+				}
+				return adminOutput{
+					JSON: map[string]any{"number": o.Number, "status": o.Status, "user_id": o.UserID},
+					Text: func(w io.Writer) { fmt.Fprintf(w, "order %s requeued with status %s\n", o.Number, o.Status) },
+				}, nil
+			}
+		},
+	},
+	"orders-stuck": {
+		summary: "list non-final orders older than a threshold and dead-lettered orders",
+		flags: func(fs *flag.FlagSet) adminAction {
+			olderThan := fs.Duration("older-than", time.Hour, "minimum order age")
+			limit := fs.Int("limit", 100, "max orders")
+			return func(ctx context.Context, repo adminRepo, _ bool) (adminOutput, error) {
+				orders, err := repo.ListStuckOrders(ctx, *olderThan, *limit)
+				if err != nil {
+					return adminOutput{}, err
+				}
+				return adminOutput{
+					JSON: orders,
+					Text: func(w io.Writer) {
+						tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+						fmt.Fprintln(tw, "NUMBER\tSTATUS\tUSER\tATTEMPTS\tUPLOADED\tPARKED")
+						for _, o := range orders {
+							parked := o.Parked
+							if parked == "" {
+								parked = "-"
+							}
+							fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\n",
+								o.Number, o.Status, o.UserID, o.Attempts, o.UploadedAt.Format(time.RFC3339), parked)
+						}
+						_ = tw.Flush()
+					},
+				}, nil
+			}
+		},
+	},
+	"quarantine-list": {
+		summary: "list orders quarantined because of anomalous accrual responses",
+		flags: func(fs *flag.FlagSet) adminAction {
+			limit := fs.Int("limit", 100, "max entries")
+			return func(ctx context.Context, repo adminRepo, _ bool) (adminOutput, error) {
+				entries, err := repo.ListQuarantine(ctx, *limit)
+				if err != nil {
+					return adminOutput{}, err
+				}
+				return adminOutput{
+					JSON: entries,
+					Text: func(w io.Writer) {
+						tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+						fmt.Fprintln(tw, "ORDER\tREASON\tAT\tPAYLOAD")
+						for _, e := range entries {
+							fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", e.OrderNumber, e.Reason, e.CreatedAt.Format(time.RFC3339), e.Payload)
+						}
+						_ = tw.Flush()
+					},
+				}, nil
+			}
+		},
+	},
+	"quarantine-release": {
+		summary: "return a quarantined order to accrual polling",
+		flags:   resolveQuarantineFlags(model.QuarantineReleased),
+	},
+	"quarantine-reject": {
+		summary: "mark a quarantined order INVALID without accrual",
+		flags:   resolveQuarantineFlags(model.QuarantineRejected),
+	},
+}
+
+func resolveQuarantineFlags(resolution string) func(fs *flag.FlagSet) adminAction {
+	return func(fs *flag.FlagSet) adminAction {
+		number := fs.Int64("number", 0, "order number")
+		return func(ctx context.Context, repo adminRepo, dryRun bool) (adminOutput, error) {
+			if *number <= 0 {
+				return adminOutput{}, fmt.Errorf("%w: -number is required", errUsage)
+			}
+			o, err := repo.ResolveQuarantine(ctx, *number, resolution, dryRun)
+			if err != nil {
+				return adminOutput{}, err
+			}
+			return adminOutput{
+				JSON: map[string]any{"number": o.Number, "status": o.Status, "resolution": resolution},
+				Text: func(w io.Writer) {
+					fmt.Fprintf(w, "order %s %s from quarantine, status %s\n", o.Number, resolution, o.Status)
+				},
+			}, nil
+		}
+	}
+}
+
+func adjustFlags(sign int) func(fs *flag.FlagSet) adminAction {
+	return func(fs *flag.FlagSet) adminAction {
+		login := fs.String("login", "", "user login")
+		amount := fs.String("amount", "", "points, e.g. 12.50")
+		reason := fs.String("reason", "", "reason recorded in the ledger")
+		return func(ctx context.Context, repo adminRepo, dryRun bool) (adminOutput, error) {
+			if *login == "" || *amount == "" || strings.TrimSpace(*reason) == "" {
+				return adminOutput{}, fmt.Errorf("%w: -login, -amount and -reason are required", errUsage)
+			}
+			cents, err := parseCents(*amount)
+			if err != nil {
+				return adminOutput{}, fmt.Errorf("%w: -amount: %v", errUsage, err)
+			}
+			b, err := repo.AdjustBalance(ctx, *login, sign*cents, *reason, dryRun)
+			if err != nil {
+				return adminOutput{}, err
+			}
+			return adminOutput{
+				JSON: map[string]any{"login": *login, "amount": float64(sign*cents) / 100, "reason": *reason, "balance": b},
+				Text: func(w io.Writer) {
+					fmt.Fprintf(w, "%s: %s, current balance %s\n", *login, formatCents(sign*cents), formatCents(b.Current))
+				},
+			}, nil
+		}
+	}
+}
+
+func findUser(ctx context.Context, repo adminRepo, login string) (*model.User, error) {
+	if login == "" {
+		return nil, fmt.Errorf("%w: -login is required", errUsage)
+	}
+	u, err := repo.GetUserByLogin(ctx, login)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, repository.ErrNotFound
+	}
+	return u, nil
+}
+
+// parseCents переводит положительную сумму в баллах ("12.5") в копейки.
+func parseCents(s string) (int, error) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	cents := math.Round(v * 100)
+	if cents <= 0 || cents > math.MaxInt32 {
+		return 0, fmt.Errorf("amount must be positive, got %q", s)
+	}
+	return int(cents), nil
+}
+
+func formatCents(c int) string {
+	sign := ""
+	if c < 0 {
+		sign, c = "-", -c
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, c/100, c%100)
+}
+
+type openAdminRepo func(cfg *config.Config) (adminRepo, func(), error)
+
+func openRepo(cfg *config.Config) (adminRepo, func(), error) {
+	repo, err := repository.NewRepository(cfg.DB.DSN)
+	if err != nil {
+		return nil, nil, err
+	}
+	return repo, func() { _ = repo.DB.Close() }, nil
+}
+
+// runAdmin выполняет `gophermart admin <command> [flags]` и возвращает код выхода.
+// Подключение к БД берётся из того же конфига, что и у сервера (-d, -config, DATABASE_URI...).
+func runAdmin(ctx context.Context, args []string, open openAdminRepo, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		adminUsage(stderr)
+		return 2
+	}
+
+	name := args[0]
+	cmd, ok := adminCommands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown admin command %q\n\n", name)
+		adminUsage(stderr)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("gophermart admin "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	dryRun := fs.Bool("dry-run", false, "run in a transaction and roll it back")
+	action := cmd.flags(fs)
+
+	cfg, err := config.Load(fs, args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	repo, closeRepo, err := open(cfg)
+	if err != nil {
+		fmt.Fprintln(stderr, "open repository:", err)
+		return 1
+	}
+	defer closeRepo()
+
+	out, err := action(ctx, repo, *dryRun)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", name, err)
+		if errors.Is(err, errUsage) {
+			fs.Usage()
+			return 2
+		}
+		return 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(map[string]any{"command": name, "dry_run": *dryRun, "result": out.JSON}); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	if *dryRun {
+		fmt.Fprintln(stdout, "dry run: no changes were committed")
+	}
+	out.Text(stdout)
+	return 0
+}
+
+func adminUsage(w io.Writer) {
+	names := make([]string, 0, len(adminCommands))
+	for name := range adminCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "usage: gophermart admin <command> [-json] [-dry-run] [flags]")
+	fmt.Fprintln(w, "\ncommands:")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(tw, "  %s\t%s\n", name, adminCommands[name].summary)
+	}
+	_ = tw.Flush()
+}
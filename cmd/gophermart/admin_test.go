@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/config"
+	"github.com/g123udini/gofemart/internal/model"
+	"github.com/g123udini/gofemart/internal/repository"
+)
+
+type fakeAdminRepo struct {
+	users map[string]*model.User
+
+	adjusted []int
+	dryRuns  []bool
+	dsn      string
+}
+
+func (f *fakeAdminRepo) GetUserByLogin(_ context.Context, login string) (*model.User, error) {
+	return f.users[login], nil
+}
+
+func (f *fakeAdminRepo) CreateUser(_ context.Context, login, hash string, dryRun bool) (*model.User, error) {
+	f.dryRuns = append(f.dryRuns, dryRun)
+	if _, ok := f.users[login]; ok {
+		return nil, repository.ErrUniqConstrait
+	}
+	return &model.User{ID: 10, Login: login, Password: hash}, nil
+}
+
+func (f *fakeAdminRepo) DisableUser(_ context.Context, login string, dryRun bool) (*model.User, error) {
+	f.dryRuns = append(f.dryRuns, dryRun)
+	u, ok := f.users[login]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	now := time.Now()
+	u.DisabledAt = &now
+	return u, nil
+}
+
+func (f *fakeAdminRepo) RevokeSessions(_ context.Context, _ string, dryRun bool) (time.Time, error) {
+	f.dryRuns = append(f.dryRuns, dryRun)
+	return time.Now(), nil
+}
+
+func (f *fakeAdminRepo) AdjustBalance(_ context.Context, login string, amount int, _ string, dryRun bool) (model.Balance, error) {
+	f.dryRuns = append(f.dryRuns, dryRun)
+	f.adjusted = append(f.adjusted, amount)
+	u, ok := f.users[login]
+	if !ok {
+		return model.Balance{}, repository.ErrNotFound
+	}
+	if u.Balance.Current+amount < 0 {
+		return model.Balance{}, repository.ErrInsufficientFunds
+	}
+	b := u.Balance
+	b.Current += amount
+	return b, nil
+}
+
+func (f *fakeAdminRepo) GetLedger(context.Context, int, int) ([]model.LedgerEntry, error) {
+	return []model.LedgerEntry{
+		{Kind: model.LedgerAccrual, Reference: "12345678903", Amount: 50000, At: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{Kind: model.LedgerDebit, Reference: "chargeback", Amount: -1050, At: time.Date(2025, 1, 3, 3, 4, 5, 0, time.UTC)},
+	}, nil
+}
+
+func (f *fakeAdminRepo) RequeueOrder(_ context.Context, number int64, dryRun bool) (*model.Order, error) {
+	f.dryRuns = append(f.dryRuns, dryRun)
+	return &model.Order{Number: "79927398713", Status: "NEW", UserID: 1}, nil
+}
+
+func (f *fakeAdminRepo) ListStuckOrders(context.Context, time.Duration, int) ([]model.StuckOrder, error) {
+	return []model.StuckOrder{{Number: "79927398713", Status: "PROCESSING", UserID: 1, Attempts: 12, Parked: "DEAD_LETTER"}}, nil
+}
+
+func (f *fakeAdminRepo) ListQuarantine(context.Context, int) ([]model.QuarantineEntry, error) {
+	return []model.QuarantineEntry{{
+		ID:          1,
+		OrderNumber: "79927398713",
+		Reason:      "unknown_status",
+		Payload:     `{"order":"79927398713","status":"REFUNDED"}`,
+		CreatedAt:   time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+	}}, nil
+}
+
+func (f *fakeAdminRepo) ResolveQuarantine(_ context.Context, number int64, resolution string, dryRun bool) (*model.Order, error) {
+	f.dryRuns = append(f.dryRuns, dryRun)
+	if number != 79927398713 {
+		return nil, repository.ErrNotQuarantined
+	}
+	status := "NEW"
+	if resolution == model.QuarantineRejected {
+		status = "INVALID"
+	}
+	return &model.Order{Number: "79927398713", Status: status, UserID: 1}, nil
+}
+
+func runAdminTest(t *testing.T, repo *fakeAdminRepo, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	open := func(cfg *config.Config) (adminRepo, func(), error) {
+		repo.dsn = cfg.DB.DSN
+		return repo, func() {}, nil
+	}
+	code := runAdmin(context.Background(), args, open, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func newFakeAdminRepo() *fakeAdminRepo {
+	return &fakeAdminRepo{users: map[string]*model.User{
+		"alice": {ID: 1, Login: "alice", Balance: model.Balance{Current: 1000, Withdrawn: 200}},
+	}}
+}
+
+func TestRunAdmin_CreditJSONDryRun(t *testing.T) {
+	repo := newFakeAdminRepo()
+
+	code, out, errOut := runAdminTest(t, repo, "credit", "-login", "alice", "-amount", "12.50", "-reason", "goodwill", "--json", "--dry-run")
+	if code != 0 {
+		t.Fatalf("code=%d stderr=%s", code, errOut)
+	}
+
+	var got struct {
+		Command string `json:"command"`
+		DryRun  bool   `json:"dry_run"`
+		Result  struct {
+			Amount  float64            `json:"amount"`
+			Balance map[string]float64 `json:"balance"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatalf("unmarshal: %v; out=%s", err, out)
+	}
+	if got.Command != "credit" || !got.DryRun || got.Result.Amount != 12.5 || got.Result.Balance["current"] != 22.5 {
+		t.Fatalf("unexpected output: %+v", got)
+	}
+	if len(repo.dryRuns) != 1 || !repo.dryRuns[0] || repo.adjusted[0] != 1250 {
+		t.Fatalf("dryRuns=%v adjusted=%v", repo.dryRuns, repo.adjusted)
+	}
+}
+
+func TestRunAdmin_DebitIsNegative(t *testing.T) {
+	repo := newFakeAdminRepo()
+
+	code, out, errOut := runAdminTest(t, repo, "debit", "-login", "alice", "-amount", "2", "-reason", "fraud")
+	if code != 0 {
+		t.Fatalf("code=%d stderr=%s", code, errOut)
+	}
+	if repo.adjusted[0] != -200 || repo.dryRuns[0] {
+		t.Fatalf("adjusted=%v dryRuns=%v", repo.adjusted, repo.dryRuns)
+	}
+	if !strings.Contains(out, "-2.00") || !strings.Contains(out, "8.00") {
+		t.Fatalf("out=%q", out)
+	}
+}
+
+func TestRunAdmin_DebitInsufficientFunds(t *testing.T) {
+	code, _, errOut := runAdminTest(t, newFakeAdminRepo(), "debit", "-login", "alice", "-amount", "100", "-reason", "x")
+	if code != 1 || !strings.Contains(errOut, "insufficient funds") {
+		t.Fatalf("code=%d stderr=%q", code, errOut)
+	}
+}
+
+func TestRunAdmin_UsageErrors(t *testing.T) {
+	tests := [][]string{
+		nil,
+		{"nope"},
+		{"credit", "-login", "alice"},
+		{"credit", "-login", "alice", "-amount", "-1", "-reason", "x"},
+		{"order-repoll"},
+	}
+	for _, args := range tests {
+		code, _, _ := runAdminTest(t, newFakeAdminRepo(), args...)
+		if code != 2 {
+			t.Fatalf("args=%v code=%d want=2", args, code)
+		}
+	}
+}
+
+func TestRunAdmin_UsesConfigDSN(t *testing.T) {
+	repo := newFakeAdminRepo()
+
+	code, _, errOut := runAdminTest(t, repo, "balance", "-login", "alice", "-d", "postgres://ops:ops@db:5432/prod")
+	if code != 0 {
+		t.Fatalf("code=%d stderr=%s", code, errOut)
+	}
+	if repo.dsn != "postgres://ops:ops@db:5432/prod" {
+		t.Fatalf("dsn=%q", repo.dsn)
+	}
+}
+
+func TestRunAdmin_LedgerText(t *testing.T) {
+	code, out, errOut := runAdminTest(t, newFakeAdminRepo(), "ledger", "-login", "alice")
+	if code != 0 {
+		t.Fatalf("code=%d stderr=%s", code, errOut)
+	}
+	for _, want := range []string{"accrual", "500.00", "debit", "-10.50", "chargeback"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("ledger output misses %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestRunAdmin_UnknownUser(t *testing.T) {
+	code, _, errOut := runAdminTest(t, newFakeAdminRepo(), "balance", "-login", "bob")
+	if code != 1 || !strings.Contains(errOut, "not found") {
+		t.Fatalf("code=%d stderr=%q", code, errOut)
+	}
+}
+
+func TestParseCents(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+		ok   bool
+	}{
+		{"12.5", 1250, true},
+		{"0.01", 1, true},
+		{"100", 10000, true},
+		{"0", 0, false},
+		{"-3", 0, false},
+		{"abc", 0, false},
+		{"NaN", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseCents(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Fatalf("parseCents(%q)=%d,%v want=%d ok=%v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRunAdmin_Quarantine(t *testing.T) {
+	code, out, errOut := runAdminTest(t, newFakeAdminRepo(), "quarantine-list")
+	if code != 0 || !strings.Contains(out, "unknown_status") || !strings.Contains(out, "REFUNDED") {
+		t.Fatalf("code=%d out=%s stderr=%s", code, out, errOut)
+	}
+
+	repo := newFakeAdminRepo()
+	code, out, errOut = runAdminTest(t, repo, "quarantine-reject", "-number", "79927398713", "-json", "-dry-run")
+	if code != 0 {
+		t.Fatalf("code=%d stderr=%s", code, errOut)
+	}
+	var res struct {
+		DryRun bool `json:"dry_run"`
+		Result struct {
+			Status     string `json:"status"`
+			Resolution string `json:"resolution"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(out), &res); err != nil {
+		t.Fatalf("json: %v\n%s", err, out)
+	}
+	if !res.DryRun || res.Result.Status != "INVALID" || res.Result.Resolution != "rejected" || !repo.dryRuns[0] {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	code, out, _ = runAdminTest(t, newFakeAdminRepo(), "quarantine-release", "-number", "79927398713")
+	if code != 0 || !strings.Contains(out, "released") {
+		t.Fatalf("code=%d out=%s", code, out)
+	}
+
+	code, _, errOut = runAdminTest(t, newFakeAdminRepo(), "quarantine-release", "-number", "1")
+	if code != 1 || !strings.Contains(errOut, "not quarantined") {
+		t.Fatalf("code=%d stderr=%s", code, errOut)
+	}
+}
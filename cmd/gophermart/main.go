@@ -2,85 +2,385 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
 	"github.com/g123udini/gofemart/internal/accrual"
+	"github.com/g123udini/gofemart/internal/config"
 	"github.com/g123udini/gofemart/internal/handler"
+	"github.com/g123udini/gofemart/internal/health"
+	"github.com/g123udini/gofemart/internal/metrics"
 	"github.com/g123udini/gofemart/internal/repository"
 	"github.com/g123udini/gofemart/internal/router"
 	"github.com/g123udini/gofemart/internal/service"
-	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/g123udini/gofemart/internal/tracing"
 	_ "github.com/jackc/pgx/v5/stdlib"
+	"go.uber.org/zap"
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "admin":
+			os.Exit(runAdmin(context.Background(), os.Args[2:], openRepo, os.Stdout, os.Stderr))
+		case "migrate":
+			os.Exit(runMigrate(os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
+
 	f := parseFlags()
-	ms := service.NewMemStorage()
-	repo, err := repository.NewRepository(f.Dsn)
+	if f.PrintConfig {
+		if err := f.Print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	logger, err := service.NewLoggerConfig(f.Log.Level, f.Log.Format)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
+	defer func() { _ = logger.Sync() }()
+	zap.ReplaceGlobals(logger.Desugar())
 
-	initMigrations(repo.DB)
+	ms := service.NewMemStorageTTL(f.Session.TTL)
+	logger.Infow("config loaded", "file", f.File, "storage", f.Storage)
 
-	err = run(repo, ms, f)
+	var (
+		store         repository.Storage
+		repo          *repository.Repo
+		schemaVersion uint
+	)
+	if f.Storage == config.StorageMemory {
+		logger.Warn("using in-memory storage, all data is lost on restart")
+		mem := repository.NewMemory()
+		mem.Backoff = pollBackoff(f)
+		store = mem
+	} else {
+		repo, schemaVersion = openPostgres(logger, f)
+		store = repo
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Config{
+		Exporter:    f.Trace.Exporter,
+		Endpoint:    f.Trace.Endpoint,
+		File:        f.Trace.File,
+		SampleRatio: f.Trace.SampleRatio,
+	})
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Fatalw("setup tracing", "error", err)
+	}
+
+	err = run(ctx, logger, store, repo, ms, f, schemaVersion)
+
+	tctx, cancel := context.WithTimeout(context.Background(), f.Server.ShutdownTimeout)
+	if terr := shutdownTracing(tctx); terr != nil {
+		logger.Errorw("shutdown tracing", "error", terr)
+	}
+	cancel()
+
+	if repo != nil {
+		if cerr := repo.Close(); cerr != nil {
+			logger.Errorw("close db", "error", cerr)
+		}
 	}
+
+	if err != nil {
+		logger.Fatalw("server stopped", "error", err)
+	}
+	logger.Info("server stopped")
 }
 
-func run(repo *repository.Repo, ms *service.MemSessionStorage, f *flags) error {
-	fmt.Println("Running server on", f.RunAddr)
+func pollBackoff(f *config.Config) repository.PollBackoff {
+	return repository.PollBackoff{Base: f.Worker.BackoffBase, Max: f.Worker.BackoffMax}
+}
 
-	host := normalizeHost(f.RunAddr)
+// instanceID отличает экземпляры, делящие одну базу: по нему воркер арендует заказы.
+func instanceID(configured string) string {
+	if configured != "" {
+		return configured
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "gophermart"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
 
-	h := handler.NewHandler(repo, ms)
-	r := router.NewRouter(h)
+func openPostgres(logger *zap.SugaredLogger, f *config.Config) (*repository.Repo, uint) {
+	repo, err := newRepository(context.Background(), logger, f.DB)
+	if err != nil {
+		logger.Fatalw("open repository", "error", err, "driver", f.DB.Driver)
+	}
+	repo.Logger = logger
+	repo.Retry = service.RetryPolicy{
+		MaxAttempts: f.Retry.Attempts,
+		BaseDelay:   f.Retry.BaseDelay,
+		MaxDelay:    f.Retry.MaxDelay,
+		MaxElapsed:  f.Retry.MaxElapsed,
+		Classifiers: []service.Classifier{service.RetryPgTransient},
+		Hooks:       metrics.RetryHooks(),
+	}
+	repo.Timeouts = repository.Timeouts{
+		Query: f.DB.QueryTimeout,
+		Exec:  f.DB.ExecTimeout,
+	}
+	repo.Lease = repository.Lease{
+		Owner: instanceID(f.Worker.InstanceID),
+		TTL:   f.Worker.LeaseTTL,
+	}
+	repo.Backoff = pollBackoff(f)
 
-	accrualClient := accrual.NewClient(f.AccrualAddress, nil)
+	if f.DB.ReplicaDSN != "" {
+		replica, err := repository.NewReplica(f.DB.ReplicaDSN, f.DB.ReplicaMaxLag)
+		if err != nil {
+			logger.Fatalw("open read replica", "error", err)
+		}
+		replica.DB.SetMaxOpenConns(f.DB.MaxOpenConns)
+		replica.DB.SetConnMaxLifetime(f.DB.ConnMaxLifetime)
+		// недоступная на старте реплика не мешает запуску: читаем с мастера, пока она не поднимется
+		ctx, cancel := context.WithTimeout(context.Background(), f.DB.ReplicaCheckEvery)
+		if err := replica.Check(ctx); err != nil {
+			logger.Warnw("read replica unavailable, reading from primary", "error", err)
+		}
+		cancel()
+		repo.Replica = replica
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	schemaVersion, err := initMigrations(repo.DB, f.DB.AutoMigrate)
+	if err != nil {
+		logger.Fatalw("apply migrations", "error", err)
+	}
+	if !f.DB.AutoMigrate {
+		logger.Infow("auto-migration disabled", "expected_schema_version", schemaVersion)
+	}
+	return repo, schemaVersion
+}
 
-	worker := accrual.NewAccrualWorker(repo, accrualClient, log.Default())
-	go worker.Run(ctx)
+// newRepository открывает Postgres выбранным драйвером. pgxpool сам держит пул
+// и пингует базу при старте; stdlib — прежний database/sql, оставлен для сравнения и отката.
+func newRepository(ctx context.Context, logger *zap.SugaredLogger, c config.DBConfig) (*repository.Repo, error) {
+	if c.Driver == config.DriverStdlib {
+		repo, err := repository.NewRepository(c.DSN)
+		if err != nil {
+			return nil, err
+		}
+		repo.DB.SetMaxOpenConns(c.MaxOpenConns)
+		repo.DB.SetMaxIdleConns(c.MaxIdleConns)
+		repo.DB.SetConnMaxLifetime(c.ConnMaxLifetime)
+		repo.DB.SetConnMaxIdleTime(c.ConnMaxIdleTime)
+		return repo, nil
+	}
 
-	return http.ListenAndServe(host, r)
+	return repository.NewPoolRepository(ctx, c.DSN, repository.PoolConfig{
+		MaxConns:          int32(c.MaxOpenConns),
+		MinConns:          int32(c.MinConns),
+		MaxConnLifetime:   c.ConnMaxLifetime,
+		MaxConnIdleTime:   c.ConnMaxIdleTime,
+		HealthCheckPeriod: c.HealthCheckPeriod,
+		ConnectAttempts:   c.ConnectAttempts,
+		ConnectRetryDelay: c.ConnectRetryDelay,
+	}, logger)
 }
 
-func normalizeHost(host string) string {
-	if h, p, err := net.SplitHostPort(host); err == nil {
-		if h == "" {
-			host = fmt.Sprintf("localhost:%s", p)
+// run запускает API и воркер поверх store. repo задан только для Postgres:
+// от него зависят метрики пула и проверки готовности БД.
+func run(ctx context.Context, logger *zap.SugaredLogger, store repository.Storage, repo *repository.Repo, ms *service.MemSessionStorage, f *config.Config, schemaVersion uint) error {
+	logger.Infow("running server", "address", f.Server.Address)
+
+	host := normalizeHost(f.Server.Address)
+
+	var rateStore accrual.RateStore
+	if f.Accrual.RateStateFile != "" {
+		rateStore = accrual.FileRateStore{Path: f.Accrual.RateStateFile}
+	}
+	accrualClient := accrual.NewClient(f.Accrual.Address, &http.Client{Timeout: f.Accrual.Timeout},
+		accrual.WithRateLimiter(accrual.NewAdaptiveLimiter(rateStore, logger)),
+		accrual.WithMaxAccrual(f.Accrual.MaxAccrual))
+	breaker := accrual.NewBreaker(accrualClient, accrual.BreakerConfig{
+		Window:       f.Accrual.BreakerWindow,
+		MinRequests:  f.Accrual.BreakerMinRequests,
+		FailureRatio: f.Accrual.BreakerFailureRatio,
+		CoolDown:     f.Accrual.BreakerCoolDown,
+	}, logger)
+	// с уведомлениями опрос остаётся только страховкой и идёт реже
+	pollEvery := f.Worker.PollEvery
+	if f.Accrual.CallbackSecret != "" {
+		pollEvery = f.Worker.ReconcileEvery
+	}
+	worker := accrual.NewAccrualWorker(store, breaker, logger, accrual.WithConfig(accrual.WorkerConfig{
+		PollEvery:      pollEvery,
+		BatchLimit:     f.Worker.BatchLimit,
+		RequestTimeout: f.Worker.RequestTimeout,
+		Concurrency:    f.Worker.Concurrency,
+		RateLimit:      f.Worker.RateLimit,
+		RateBurst:      f.Worker.RateBurst,
+		MaxAge:         f.Worker.MaxAge,
+		MaxAttempts:    f.Worker.MaxAttempts,
+		MaxAccrual:     f.Accrual.MaxAccrual,
+	}))
+	registrar := accrual.NewRegistrar(store, accrualClient, logger, accrual.RegistrarConfig{
+		Every:          f.Worker.RegisterEvery,
+		BatchLimit:     f.Worker.BatchLimit,
+		RequestTimeout: f.Accrual.Timeout,
+		MaxAttempts:    f.Worker.RegisterMaxAttempts,
+	})
+
+	h := handler.NewHandler(store, ms, handler.WithSecureCookie(f.Session.SecureCookie))
+	if err := metrics.RegisterPendingOrders(store.CountPendingOrders); err != nil {
+		return fmt.Errorf("register pending orders metric: %w", err)
+	}
+	if repo != nil {
+		if err := metrics.RegisterDB(repo.DB); err != nil {
+			return fmt.Errorf("register db metrics: %w", err)
 		}
 	}
-	return host
+
+	routes := []router.Option{
+		router.WithHealth(newHealth(repo, accrualClient, breaker, worker, f, schemaVersion)),
+		router.WithMetrics(),
+		router.WithLogger(logger),
+		router.WithMaxBodyBytes(f.Server.MaxBodyBytes),
+	}
+	switch {
+	case f.Server.AdminToken == "":
+	case repo == nil:
+		logger.Warn("admin API needs postgres storage, /admin is disabled")
+	default:
+		routes = append(routes, router.WithAdmin(handler.NewAdmin(repo, f.Server.AdminToken)))
+	}
+	if f.Accrual.CallbackSecret != "" {
+		routes = append(routes, router.WithAccrualCallback(
+			handler.NewAccrualCallback(worker, f.Accrual.CallbackSecret, f.Accrual.CallbackMaxSkew)))
+	}
+	r := router.NewRouter(h, routes...)
+
+	// воркер не наследует сигнальный контекст: его останавливает shutdown,
+	// когда HTTP уже не принимает запросов, а не сам SIGTERM
+	workerCtx, cancelWorker := context.WithCancel(context.Background())
+	defer cancelWorker()
+
+	workerDone := make(chan struct{})
+	go func() {
+		defer close(workerDone)
+		registrarDone := make(chan struct{})
+		go func() {
+			defer close(registrarDone)
+			registrar.Run(workerCtx)
+		}()
+		worker.Run(workerCtx)
+		<-registrarDone
+	}()
+
+	if repo != nil && repo.Replica != nil {
+		go repo.Replica.Run(workerCtx, f.DB.ReplicaCheckEvery, logger)
+	}
+
+	srv := newServer(host, r, f.Server)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	var err error
+	select {
+	case <-ctx.Done():
+		logger.Info("shutdown signal received, draining")
+	case err = <-serveErr:
+		if errors.Is(err, http.ErrServerClosed) {
+			err = nil
+		}
+	}
+
+	return shutdown(srv, cancelWorker, workerDone, f.Server.ShutdownTimeout, err)
 }
 
-func initMigrations(db *sql.DB) {
-	driver, err := postgres.WithInstance(db, &postgres.Config{})
+func newHealth(repo *repository.Repo, client *accrual.Client, breaker *accrual.Breaker, worker *accrual.AccrualWorker, f *config.Config, schemaVersion uint) *health.Health {
+	hc := health.New(2 * time.Second)
+
+	hc.AddLiveness("accrual_worker", func(context.Context) error {
+		return worker.Alive(f.Worker.LivenessIntervals)
+	})
+
+	if repo != nil {
+		hc.AddReadiness("database", repo.DB.PingContext)
+		hc.AddReadiness("migrations", func(ctx context.Context) error {
+			return checkSchemaVersion(ctx, repo, schemaVersion)
+		})
+	}
+	hc.AddReadiness("accrual", client.Ready)
+	hc.AddReadiness("accrual_breaker", breaker.Check)
+
+	return hc
+}
+
+func checkSchemaVersion(ctx context.Context, repo *repository.Repo, want uint) error {
+	got, dirty, err := repo.SchemaVersion(ctx)
 	if err != nil {
-		log.Fatal("postgres driver error: ", err)
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("schema version %d is dirty", got)
 	}
+	if got != want {
+		return fmt.Errorf("schema version %d, expected %d", got, want)
+	}
+	return nil
+}
 
-	m, err := migrate.NewWithDatabaseInstance(
-		"file://migrations",
-		"postgres",
-		driver,
-	)
+func newServer(addr string, h http.Handler, f config.ServerConfig) *http.Server {
+	if f.MaxBodyBytes > 0 {
+		h = http.MaxBytesHandler(h, f.MaxBodyBytes)
+	}
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadTimeout:       f.ReadTimeout,
+		ReadHeaderTimeout: f.ReadHeaderTimeout,
+		WriteTimeout:      f.WriteTimeout,
+		IdleTimeout:       f.IdleTimeout,
+	}
+}
+
+// shutdown дренирует HTTP-запросы и только потом останавливает воркер.
+// На дренаж уходит не больше половины timeout, воркеру остаётся всё, что
+// HTTP не израсходовал, поэтому долгий дренаж не отнимает у него свою долю.
+func shutdown(srv *http.Server, cancelWorker context.CancelFunc, workerDone <-chan struct{}, timeout time.Duration, runErr error) error {
+	start := time.Now()
+	httpCtx, cancelHTTP := context.WithTimeout(context.Background(), timeout/2)
+	err := srv.Shutdown(httpCtx)
+	cancelHTTP()
 	if err != nil {
-		log.Fatal("migrate init error: ", err)
+		err = fmt.Errorf("http shutdown: %w", err)
 	}
 
-	err = m.Up()
-	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
-		log.Fatalf("migrate up error: %v", err)
+	cancelWorker()
+	workerTimer := time.NewTimer(timeout - time.Since(start))
+	defer workerTimer.Stop()
+	select {
+	case <-workerDone:
+	case <-workerTimer.C:
+		err = errors.Join(err, errors.New("accrual worker did not stop before shutdown deadline"))
 	}
+
+	return errors.Join(runErr, err)
+}
+
+func normalizeHost(host string) string {
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		if h == "" {
+			host = fmt.Sprintf("localhost:%s", p)
+		}
+	}
+	return host
 }
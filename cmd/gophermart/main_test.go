@@ -1,7 +1,17 @@
 package main
 
 import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/config"
 )
 
 func TestNormalizeHost(t *testing.T) {
@@ -26,3 +36,116 @@ func TestNormalizeHost(t *testing.T) {
 		}
 	}
 }
+
+func TestNewServer_AppliesTimeoutsAndBodyLimit(t *testing.T) {
+	f := config.ServerConfig{
+		ReadTimeout:       1 * time.Second,
+		ReadHeaderTimeout: 2 * time.Second,
+		WriteTimeout:      3 * time.Second,
+		IdleTimeout:       4 * time.Second,
+		MaxBodyBytes:      4,
+	}
+
+	srv := newServer("localhost:0", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}), f)
+
+	if srv.ReadTimeout != f.ReadTimeout || srv.ReadHeaderTimeout != f.ReadHeaderTimeout ||
+		srv.WriteTimeout != f.WriteTimeout || srv.IdleTimeout != f.IdleTimeout {
+		t.Fatalf("timeouts not applied: %+v", srv)
+	}
+
+	rr := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("too long body")))
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status=%d want=%d", rr.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestShutdown_WaitsForWorker(t *testing.T) {
+	srv := &http.Server{}
+	workerDone := make(chan struct{})
+	stopped := false
+
+	cancelWorker := func() {
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			stopped = true
+			close(workerDone)
+		}()
+	}
+
+	if err := shutdown(srv, cancelWorker, workerDone, time.Second, nil); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if !stopped {
+		t.Fatalf("shutdown returned before worker stopped")
+	}
+}
+
+func TestShutdown_CancelsWorkerAfterHTTPDrain(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	var cancelled atomic.Bool
+	started := make(chan struct{})
+	cancelledDuringRequest := make(chan bool, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		cancelledDuringRequest <- cancelled.Load()
+	})}
+	go func() { _ = srv.Serve(ln) }()
+
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+
+	workerDone := make(chan struct{})
+	cancelWorker := func() {
+		cancelled.Store(true)
+		close(workerDone)
+	}
+	if err := shutdown(srv, cancelWorker, workerDone, time.Second, nil); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if <-cancelledDuringRequest {
+		t.Fatalf("worker was cancelled before in-flight requests drained")
+	}
+}
+
+func TestShutdown_DeadlineExceeded(t *testing.T) {
+	srv := &http.Server{}
+	workerDone := make(chan struct{})
+
+	err := shutdown(srv, func() {}, workerDone, 20*time.Millisecond, nil)
+	if err == nil {
+		t.Fatalf("expected error when worker does not stop in time")
+	}
+}
+
+func TestLatestMigrationVersion(t *testing.T) {
+	src, err := migrationSource()
+	if err != nil {
+		t.Fatalf("open source: %v", err)
+	}
+	defer src.Close()
+
+	got, err := latestMigrationVersion(src)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	ups, _ := filepath.Glob("../../migrations/*.up.sql")
+	if got != uint(len(ups)) {
+		t.Fatalf("latest=%d want=%d", got, len(ups))
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/g123udini/gofemart/internal/config"
+	"github.com/g123udini/gofemart/migrations"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+// migrationSource открывает встроенные в бинарник миграции.
+func migrationSource() (source.Driver, error) {
+	return iofs.New(migrations.FS, ".")
+}
+
+// newMigrator собирает migrate.Migrate поверх db. Закрытие Migrate закрывает и db.
+func newMigrator(db *sql.DB) (*migrate.Migrate, uint, error) {
+	src, err := migrationSource()
+	if err != nil {
+		return nil, 0, fmt.Errorf("migrate source: %w", err)
+	}
+
+	latest, err := latestMigrationVersion(src)
+	if err != nil {
+		return nil, 0, fmt.Errorf("migrate source: %w", err)
+	}
+
+	driver, err := postgres.WithInstance(db, &postgres.Config{})
+	if err != nil {
+		return nil, 0, fmt.Errorf("postgres driver: %w", err)
+	}
+
+	m, err := migrate.NewWithInstance("iofs", src, "postgres", driver)
+	if err != nil {
+		return nil, 0, fmt.Errorf("migrate init: %w", err)
+	}
+	return m, latest, nil
+}
+
+func migrateUsage(w io.Writer) {
+	fmt.Fprintln(w, `usage: gophermart migrate <command> [flags]
+
+commands:
+  up [N]       apply all pending migrations, or the next N
+  down [N]     roll back N migrations (default 1); -all rolls back everything
+  goto V       migrate up or down to version V
+  version      print the applied version
+  force V      set version V without running migrations (clears the dirty flag; -1 = none)`)
+}
+
+// runMigrate выполняет `gophermart migrate <command>` и возвращает код выхода.
+func runMigrate(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		migrateUsage(stderr)
+		return 2
+	}
+	name := args[0]
+
+	fs := flag.NewFlagSet("gophermart migrate "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	all := fs.Bool("all", false, "with down: roll back all migrations")
+
+	cfg, err := config.Load(fs, args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	action, err := migrateAction(name, fs.Args(), *all)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		migrateUsage(stderr)
+		return 2
+	}
+
+	db, err := sql.Open("pgx", cfg.DB.DSN)
+	if err != nil {
+		fmt.Fprintln(stderr, "open db:", err)
+		return 1
+	}
+	m, _, err := newMigrator(db)
+	if err != nil {
+		_ = db.Close()
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer func() { _, _ = m.Close() }()
+
+	if err := action(m); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		fmt.Fprintf(stderr, "migrate %s: %v\n", name, err)
+		return 1
+	}
+
+	v, dirty, err := m.Version()
+	switch {
+	case errors.Is(err, migrate.ErrNilVersion):
+		fmt.Fprintln(stdout, "no migrations applied")
+	case err != nil:
+		fmt.Fprintln(stderr, "migrate version:", err)
+		return 1
+	case dirty:
+		fmt.Fprintf(stdout, "version %d (dirty)\n", v)
+	default:
+		fmt.Fprintf(stdout, "version %d\n", v)
+	}
+	return 0
+}
+
+// migrateAction разбирает команду и её позиционные аргументы до подключения к БД,
+// чтобы опечатки не требовали доступной базы.
+func migrateAction(name string, args []string, all bool) (func(m *migrate.Migrate) error, error) {
+	arg := func(def int) (int, error) {
+		if len(args) == 0 {
+			return def, nil
+		}
+		if len(args) > 1 {
+			return 0, fmt.Errorf("migrate %s: too many arguments", name)
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			return 0, fmt.Errorf("migrate %s: %q is not a number", name, args[0])
+		}
+		return n, nil
+	}
+
+	switch name {
+	case "up":
+		n, err := arg(0)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("migrate up: expected a positive step count")
+		}
+		if n == 0 {
+			return (*migrate.Migrate).Up, nil
+		}
+		return func(m *migrate.Migrate) error { return m.Steps(n) }, nil
+	case "down":
+		if all {
+			if len(args) > 0 {
+				return nil, errors.New("migrate down: -all does not take a step count")
+			}
+			return (*migrate.Migrate).Down, nil
+		}
+		n, err := arg(1)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("migrate down: expected a positive step count")
+		}
+		return func(m *migrate.Migrate) error { return m.Steps(-n) }, nil
+	case "goto":
+		if len(args) != 1 {
+			return nil, errors.New("migrate goto: version is required")
+		}
+		v, err := arg(0)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("migrate goto: invalid version %q", args[0])
+		}
+		return func(m *migrate.Migrate) error { return m.Migrate(uint(v)) }, nil
+	case "force":
+		if len(args) != 1 {
+			return nil, errors.New("migrate force: version is required")
+		}
+		v, err := arg(0)
+		if err != nil || v < -1 {
+			return nil, fmt.Errorf("migrate force: invalid version %q", args[0])
+		}
+		return func(m *migrate.Migrate) error { return m.Force(v) }, nil
+	case "version":
+		if len(args) > 0 {
+			return nil, errors.New("migrate version: takes no arguments")
+		}
+		return func(*migrate.Migrate) error { return nil }, nil
+	default:
+		return nil, fmt.Errorf("unknown migrate command %q", name)
+	}
+}
+
+// initMigrations накатывает миграции при старте сервера (если apply) и возвращает
+// последнюю версию из исходников — её ждёт проверка готовности.
+func initMigrations(db *sql.DB, apply bool) (uint, error) {
+	if !apply {
+		src, err := migrationSource()
+		if err != nil {
+			return 0, fmt.Errorf("migrate source: %w", err)
+		}
+		defer src.Close()
+		return latestMigrationVersion(src)
+	}
+
+	// m не закрываем: Close закрыл бы и общий пул db.
+	m, latest, err := newMigrator(db)
+	if err != nil {
+		return 0, err
+	}
+
+	err = m.Up()
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return 0, fmt.Errorf("migrate up: %w", err)
+	}
+
+	return latest, nil
+}
+
+func latestMigrationVersion(src source.Driver) (uint, error) {
+	v, err := src.First()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		next, err := src.Next(v)
+		if errors.Is(err, os.ErrNotExist) {
+			return v, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		v = next
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMigrateAction_ParsesArguments(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		all  bool
+		ok   bool
+	}{
+		{"up", nil, false, true},
+		{"up", []string{"2"}, false, true},
+		{"up", []string{"-1"}, false, false},
+		{"down", nil, false, true},
+		{"down", []string{"3"}, false, true},
+		{"down", []string{"0"}, false, false},
+		{"down", nil, true, true},
+		{"down", []string{"1"}, true, false},
+		{"goto", []string{"3"}, false, true},
+		{"goto", nil, false, false},
+		{"goto", []string{"x"}, false, false},
+		{"force", []string{"-1"}, false, true},
+		{"force", []string{"-2"}, false, false},
+		{"version", nil, false, true},
+		{"version", []string{"1"}, false, false},
+		{"sideways", nil, false, false},
+	}
+
+	for _, tt := range tests {
+		_, err := migrateAction(tt.name, tt.args, tt.all)
+		if (err == nil) != tt.ok {
+			t.Fatalf("migrateAction(%s, %v, all=%v) err=%v want ok=%v", tt.name, tt.args, tt.all, err, tt.ok)
+		}
+	}
+}
+
+func TestRunMigrate_UsageWithoutDatabase(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	if code := runMigrate([]string{"goto"}, &stdout, &stderr); code != 2 {
+		t.Fatalf("code=%d want=2", code)
+	}
+	if !strings.Contains(stderr.String(), "version is required") || !strings.Contains(stderr.String(), "usage: gophermart migrate") {
+		t.Fatalf("stderr=%q", stderr.String())
+	}
+}
+
+func TestInitMigrations_SkipsApplyWhenDisabled(t *testing.T) {
+	got, err := initMigrations(nil, false)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	src, _ := migrationSource()
+	want, _ := latestMigrationVersion(src)
+	if got != want || got == 0 {
+		t.Fatalf("version=%d want=%d", got, want)
+	}
+}
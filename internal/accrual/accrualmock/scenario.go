@@ -0,0 +1,106 @@
+// Package accrualmock — имитация системы расчёта начислений для локальной
+// разработки и интеграционных тестов. Ответы на GET /api/orders/{number}
+// задаются сценариями: для каждого заказа — последовательность шагов
+// (статус, 204, 429, 500, задержка), которую сервер проигрывает по мере запросов.
+package accrualmock
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Step — один ответ сценария. Заданный Status означает 200 с телом заказа,
+// иначе отвечаем Code (по умолчанию 204). Шаг действует Times запросов или
+// в течение For, после чего сценарий переходит к следующему; без Times и For
+// шаг отдаётся один раз. Последний шаг повторяется бесконечно.
+type Step struct {
+	Status  string   `json:"status,omitempty"`
+	Accrual *float64 `json:"accrual,omitempty"`
+	Code    int      `json:"code,omitempty"`
+	// RetryAfter — секунды в заголовке Retry-After для 429.
+	RetryAfter int `json:"retry_after,omitempty"`
+	// Body заменяет тело ответа, например для битого JSON.
+	Body string `json:"body,omitempty"`
+	// Delay — пауза перед ответом, имитирует медленную систему.
+	Delay Duration `json:"delay,omitempty"`
+
+	Times int      `json:"times,omitempty"`
+	For   Duration `json:"for,omitempty"`
+}
+
+// Scenario — сценарий одного заказа. Order "*" задаёт сценарий по умолчанию:
+// каждый неизвестный заказ проигрывает его независимо от остальных.
+type Scenario struct {
+	Order string `json:"order"`
+	Steps []Step `json:"steps"`
+}
+
+// DefaultOrder — номер сценария по умолчанию.
+const DefaultOrder = "*"
+
+func (s Scenario) validate() error {
+	if s.Order == "" {
+		return fmt.Errorf("scenario: empty order")
+	}
+	if len(s.Steps) == 0 {
+		return fmt.Errorf("scenario %s: no steps", s.Order)
+	}
+	for i, st := range s.Steps {
+		switch st.Status {
+		case "", "REGISTERED", "PROCESSING", "PROCESSED", "INVALID":
+		default:
+			// неизвестный статус допустим только явным телом: так проверяют карантин
+			if st.Body == "" {
+				return fmt.Errorf("scenario %s step %d: unknown status %q", s.Order, i, st.Status)
+			}
+		}
+		if st.Code != 0 && (st.Code < 100 || st.Code > 599) {
+			return fmt.Errorf("scenario %s step %d: bad code %d", s.Order, i, st.Code)
+		}
+		if st.Times < 0 || st.For < 0 || st.Delay < 0 || st.RetryAfter < 0 {
+			return fmt.Errorf("scenario %s step %d: negative times, for, delay or retry_after", s.Order, i)
+		}
+	}
+	return nil
+}
+
+// code — HTTP-код ответа шага.
+func (st Step) code() int {
+	switch {
+	case st.Code != 0:
+		return st.Code
+	case st.Status != "":
+		return http.StatusOK
+	default:
+		return http.StatusNoContent
+	}
+}
+
+// Duration читается из JSON строкой вида "1.5s" или числом наносекунд.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case float64:
+		*d = Duration(v)
+	case string:
+		p, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(p)
+	default:
+		return fmt.Errorf("invalid duration %s", b)
+	}
+	return nil
+}
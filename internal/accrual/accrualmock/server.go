@@ -0,0 +1,300 @@
+package accrualmock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// Server отвечает как система расчёта по загруженным сценариям.
+//
+// GET /api/orders/{number} — ответ по сценарию заказа; без сценария — 204.
+// Управление, чтобы тесты вели сервер детерминированно:
+// GET /control/scenarios — сценарии и сколько раз спрашивали каждый заказ;
+// POST /control/scenarios — загрузить массив сценариев (заменяет одноимённые, прогресс сбрасывается);
+// DELETE /control/scenarios — удалить все сценарии и прогресс.
+type Server struct {
+	logger *zap.SugaredLogger
+	now    func() time.Time
+	router chi.Router
+
+	mu        sync.Mutex
+	scenarios map[string]Scenario
+	progress  map[string]*progress
+}
+
+// progress — положение заказа в сценарии.
+type progress struct {
+	step   int
+	served int       // ответов текущим шагом
+	since  time.Time // начало текущего шага
+	calls  int
+}
+
+type Option func(s *Server)
+
+func WithLogger(l *zap.SugaredLogger) Option {
+	return func(s *Server) {
+		s.logger = l
+	}
+}
+
+// WithClock подменяет часы для шагов с For.
+func WithClock(now func() time.Time) Option {
+	return func(s *Server) {
+		s.now = now
+	}
+}
+
+func New(opts ...Option) *Server {
+	s := &Server{
+		logger:    zap.S(),
+		now:       time.Now,
+		scenarios: make(map[string]Scenario),
+		progress:  make(map[string]*progress),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	r := chi.NewRouter()
+	r.Get("/api/orders/{number}", s.getOrder)
+	r.Route("/control/scenarios", func(r chi.Router) {
+		r.Get("/", s.listScenarios)
+		r.Post("/", s.loadScenarios)
+		r.Delete("/", s.resetScenarios)
+	})
+	s.router = r
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// Load добавляет сценарии; сценарий с тем же номером заменяется и проигрывается с начала.
+func (s *Server) Load(scenarios ...Scenario) error {
+	for _, sc := range scenarios {
+		if err := sc.validate(); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sc := range scenarios {
+		s.scenarios[sc.Order] = sc
+		if sc.Order == DefaultOrder {
+			// заказы без своего сценария начинают новый сценарий по умолчанию заново
+			for order := range s.progress {
+				if _, own := s.scenarios[order]; !own {
+					delete(s.progress, order)
+				}
+			}
+			continue
+		}
+		delete(s.progress, sc.Order)
+	}
+	return nil
+}
+
+// LoadFile читает JSON-массив сценариев из файла.
+func (s *Server) LoadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var scenarios []Scenario
+	if err := json.Unmarshal(b, &scenarios); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return s.Load(scenarios...)
+}
+
+// Reset удаляет все сценарии и прогресс.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.scenarios)
+	clear(s.progress)
+}
+
+// Calls — сколько раз спрашивали заказ.
+func (s *Server) Calls(order string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.progress[order]; ok {
+		return p.calls
+	}
+	return 0
+}
+
+// next выбирает шаг для очередного запроса заказа и сдвигает прогресс.
+func (s *Server) next(order string) (Step, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sc, ok := s.scenarios[order]
+	if !ok {
+		sc, ok = s.scenarios[DefaultOrder]
+	}
+	p, seen := s.progress[order]
+	if !seen {
+		p = &progress{since: s.now()}
+		s.progress[order] = p
+	}
+	p.calls++
+	if !ok {
+		return Step{}, false
+	}
+
+	now := s.now()
+	for p.step < len(sc.Steps)-1 && p.stepDone(sc.Steps[p.step], now) {
+		p.step++
+		p.served = 0
+	}
+	p.served++
+	return sc.Steps[p.step], true
+}
+
+// stepDone сообщает, что шаг отыгран, и переносит начало следующего шага.
+func (p *progress) stepDone(st Step, now time.Time) bool {
+	if st.For > 0 {
+		if now.Sub(p.since) < time.Duration(st.For) {
+			return false
+		}
+		p.since = p.since.Add(time.Duration(st.For))
+		return true
+	}
+	if p.served < max(st.Times, 1) {
+		return false
+	}
+	p.since = now
+	return true
+}
+
+func (s *Server) getOrder(w http.ResponseWriter, r *http.Request) {
+	number := chi.URLParam(r, "number")
+	st, ok := s.next(number)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if st.Delay > 0 {
+		t := time.NewTimer(time.Duration(st.Delay))
+		select {
+		case <-r.Context().Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+
+	code := st.code()
+	s.logger.Debugw("accrual mock response", "order", number, "code", code, "status", st.Status)
+
+	switch code {
+	case http.StatusOK:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if st.Body != "" {
+			_, _ = io.WriteString(w, st.Body)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(struct {
+			Order   string   `json:"order"`
+			Status  string   `json:"status"`
+			Accrual *float64 `json:"accrual,omitempty"`
+		}{number, st.Status, st.Accrual})
+
+	case http.StatusNoContent:
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.StatusTooManyRequests:
+		retryAfter := st.RetryAfter
+		if retryAfter == 0 {
+			retryAfter = 60
+		}
+		body := st.Body
+		if body == "" {
+			body = "No more than 60 requests per minute allowed"
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = io.WriteString(w, body)
+
+	default:
+		body := st.Body
+		if body == "" {
+			body = http.StatusText(code)
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(code)
+		_, _ = io.WriteString(w, body)
+	}
+}
+
+type scenarioState struct {
+	Scenario
+	Step  int `json:"step"`
+	Calls int `json:"calls"`
+}
+
+func (s *Server) listScenarios(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	out := struct {
+		Scenarios []scenarioState `json:"scenarios"`
+		// Calls — число запросов по каждому заказу, в том числе без сценария
+		Calls map[string]int `json:"calls"`
+	}{
+		Scenarios: make([]scenarioState, 0, len(s.scenarios)),
+		Calls:     make(map[string]int, len(s.progress)),
+	}
+	for order, sc := range s.scenarios {
+		st := scenarioState{Scenario: sc}
+		if p, ok := s.progress[order]; ok {
+			st.Step, st.Calls = p.step, p.calls
+		}
+		out.Scenarios = append(out.Scenarios, st)
+	}
+	for order, p := range s.progress {
+		out.Calls[order] = p.calls
+	}
+	s.mu.Unlock()
+
+	sort.Slice(out.Scenarios, func(i, j int) bool { return out.Scenarios[i].Order < out.Scenarios[j].Order })
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+func (s *Server) loadScenarios(w http.ResponseWriter, r *http.Request) {
+	var scenarios []Scenario
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&scenarios); err != nil {
+		http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.Load(scenarios...); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) resetScenarios(w http.ResponseWriter, r *http.Request) {
+	s.Reset()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,205 @@
+package accrualmock_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/accrual"
+	"github.com/g123udini/gofemart/internal/accrual/accrualmock"
+	"github.com/g123udini/gofemart/internal/service"
+	"go.uber.org/zap"
+)
+
+func newClient(t *testing.T, mock *accrualmock.Server) *accrual.Client {
+	t.Helper()
+	srv := httptest.NewServer(mock)
+	t.Cleanup(srv.Close)
+	// без повторов: каждый GetOrder — ровно один шаг сценария
+	return accrual.NewClient(srv.URL, srv.Client(), accrual.WithRetryPolicy(service.RetryPolicy{MaxAttempts: 1}))
+}
+
+func accrualOf(v float64) *float64 { return &v }
+
+func TestServer_StepsByTimes(t *testing.T) {
+	mock := accrualmock.New(accrualmock.WithLogger(zap.NewNop().Sugar()))
+	err := mock.Load(accrualmock.Scenario{Order: "1", Steps: []accrualmock.Step{
+		{Times: 2},
+		{Status: "REGISTERED"},
+		{Status: "PROCESSING", Times: 2},
+		{Status: "PROCESSED", Accrual: accrualOf(729.98)},
+	}})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	c := newClient(t, mock)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetOrder(ctx, "1"); !errors.Is(err, accrual.ErrNotRegistered) {
+			t.Fatalf("call %d: want ErrNotRegistered, got %v", i, err)
+		}
+	}
+	want := []accrual.Status{accrual.StatusRegistered, accrual.StatusProcessing, accrual.StatusProcessing, accrual.StatusProcessed, accrual.StatusProcessed}
+	for i, w := range want {
+		info, err := c.GetOrder(ctx, "1")
+		if err != nil || info.Status != w {
+			t.Fatalf("call %d: got %+v, %v; want %s", i, info, err, w)
+		}
+	}
+	info, _ := c.GetOrder(ctx, "1")
+	if info.Accrual == nil || *info.Accrual != 729.98 {
+		t.Fatalf("accrual=%v want 729.98", info.Accrual)
+	}
+	if n := mock.Calls("1"); n != 8 {
+		t.Fatalf("Calls=%d want 8", n)
+	}
+}
+
+func TestServer_StepsByTime(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock := accrualmock.New(accrualmock.WithClock(func() time.Time { return now }))
+	_ = mock.Load(accrualmock.Scenario{Order: "1", Steps: []accrualmock.Step{
+		{Status: "REGISTERED", For: accrualmock.Duration(time.Second)},
+		{Status: "PROCESSING", For: accrualmock.Duration(2 * time.Second)},
+		{Status: "INVALID"},
+	}})
+	c := newClient(t, mock)
+
+	for _, tc := range []struct {
+		after time.Duration
+		want  accrual.Status
+	}{
+		{0, accrual.StatusRegistered},
+		{999 * time.Millisecond, accrual.StatusRegistered},
+		{time.Second, accrual.StatusProcessing},
+		{3 * time.Second, accrual.StatusInvalid},
+	} {
+		now = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).Add(tc.after)
+		info, err := c.GetOrder(context.Background(), "1")
+		if err != nil || info.Status != tc.want {
+			t.Fatalf("after %s: got %+v, %v; want %s", tc.after, info, err, tc.want)
+		}
+	}
+}
+
+func TestServer_ErrorShapes(t *testing.T) {
+	mock := accrualmock.New()
+	_ = mock.Load(
+		accrualmock.Scenario{Order: "429", Steps: []accrualmock.Step{{Code: 429, RetryAfter: 7}}},
+		accrualmock.Scenario{Order: "500", Steps: []accrualmock.Step{{Code: 500}}},
+		accrualmock.Scenario{Order: "bad", Steps: []accrualmock.Step{{Status: "REFUNDED", Body: `{"order":"bad","status":"REFUNDED"}`}}},
+	)
+	c := newClient(t, mock)
+	ctx := context.Background()
+
+	// после 429 клиент сам выжидает Retry-After, поэтому у него отдельный клиент
+	var rl accrual.RateLimitError
+	limited := newClient(t, mock)
+	if _, err := limited.GetOrder(ctx, "429"); !errors.As(err, &rl) || rl.RetryAfter != 7*time.Second {
+		t.Fatalf("429: got %v", err)
+	}
+	if got := limited.Limiter().PerMinute(); got != 60 {
+		t.Fatalf("client must learn the limit from the 429 body, got %d", got)
+	}
+
+	var he *service.HTTPError
+	if _, err := c.GetOrder(ctx, "500"); !errors.As(err, &he) || he.StatusCode != 500 {
+		t.Fatalf("500: got %v", err)
+	}
+	if _, err := c.GetOrder(ctx, "bad"); !errors.Is(err, accrual.ErrAnomalous) {
+		t.Fatalf("unknown status: want anomaly, got %v", err)
+	}
+	// без сценария — 204
+	if _, err := c.GetOrder(ctx, "404"); !errors.Is(err, accrual.ErrNotRegistered) {
+		t.Fatalf("unknown order: got %v", err)
+	}
+}
+
+func TestServer_SlowResponse(t *testing.T) {
+	mock := accrualmock.New()
+	_ = mock.Load(accrualmock.Scenario{Order: "1", Steps: []accrualmock.Step{
+		{Status: "PROCESSED", Delay: accrualmock.Duration(time.Second)},
+	}})
+	srv := httptest.NewServer(mock)
+	defer srv.Close()
+	c := accrual.NewClient(srv.URL, &http.Client{Timeout: 50 * time.Millisecond},
+		accrual.WithRetryPolicy(service.RetryPolicy{MaxAttempts: 1}))
+
+	if _, err := c.GetOrder(context.Background(), "1"); err == nil {
+		t.Fatalf("slow response must time out")
+	}
+}
+
+func TestServer_DefaultScenarioPerOrder(t *testing.T) {
+	mock := accrualmock.New()
+	_ = mock.Load(accrualmock.Scenario{Order: accrualmock.DefaultOrder, Steps: []accrualmock.Step{
+		{Status: "PROCESSING"},
+		{Status: "PROCESSED", Accrual: accrualOf(10)},
+	}})
+	c := newClient(t, mock)
+	ctx := context.Background()
+
+	// каждый заказ проигрывает сценарий по умолчанию сам по себе
+	for _, num := range []string{"1", "2"} {
+		if info, _ := c.GetOrder(ctx, num); info.Status != accrual.StatusProcessing {
+			t.Fatalf("order %s first call: %s", num, info.Status)
+		}
+	}
+	if info, _ := c.GetOrder(ctx, "1"); info.Status != accrual.StatusProcessed {
+		t.Fatalf("order 1 second call: %s", info.Status)
+	}
+}
+
+func TestServer_ControlAPI(t *testing.T) {
+	mock := accrualmock.New()
+	srv := httptest.NewServer(mock)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/control/scenarios", "application/json",
+		strings.NewReader(`[{"order":"1","steps":[{"status":"PROCESSING","times":1},{"status":"INVALID","delay":"1ms"}]}]`))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("load status=%d", resp.StatusCode)
+	}
+
+	c := accrual.NewClient(srv.URL, srv.Client())
+	if info, _ := c.GetOrder(context.Background(), "1"); info.Status != accrual.StatusProcessing {
+		t.Fatalf("first call: %s", info.Status)
+	}
+
+	resp, err = http.Post(srv.URL+"/control/scenarios", "application/json",
+		strings.NewReader(`[{"order":"2","steps":[{"status":"DONE"}]}]`))
+	if err != nil {
+		t.Fatalf("load invalid: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unknown status without body must be rejected, status=%d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/control/scenarios", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	resp.Body.Close()
+	if _, err := c.GetOrder(context.Background(), "1"); !errors.Is(err, accrual.ErrNotRegistered) {
+		t.Fatalf("after reset: want 204, got %v", err)
+	}
+}
+
+func TestServer_LoadFile(t *testing.T) {
+	mock := accrualmock.New()
+	if err := mock.LoadFile(filepath.Join("..", "..", "..", "cmd", "accrual-mock", "scenarios.example.json")); err != nil {
+		t.Fatalf("example scenarios must load: %v", err)
+	}
+}
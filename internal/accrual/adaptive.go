@@ -0,0 +1,176 @@
+package accrual
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// Так система расчёта объясняет 429: "No more than N requests per minute allowed".
+var rateLimitBody = regexp.MustCompile(`(?i)no more than\s+(\d+)\s+requests?\s+per\s+minute`)
+
+// parseRateLimit достаёт N из тела ответа 429; 0 — предел не указан.
+func parseRateLimit(body string) int {
+	m := rateLimitBody.FindStringSubmatch(body)
+	if m == nil {
+		return 0
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return n
+}
+
+// RateStore хранит выученный предел между перезапусками.
+type RateStore interface {
+	// LoadRate возвращает 0, если ничего не сохранено.
+	LoadRate() (perMinute int, err error)
+	SaveRate(perMinute int) error
+}
+
+// FileRateStore хранит предел в JSON-файле.
+type FileRateStore struct {
+	Path string
+}
+
+type rateState struct {
+	RequestsPerMinute int       `json:"requests_per_minute"`
+	LearnedAt         time.Time `json:"learned_at"`
+}
+
+func (s FileRateStore) LoadRate() (int, error) {
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var st rateState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return 0, err
+	}
+	return max(st.RequestsPerMinute, 0), nil
+}
+
+// SaveRate пишет во временный файл и переименовывает его, чтобы падение
+// посреди записи не оставило битый файл.
+func (s FileRateStore) SaveRate(perMinute int) error {
+	data, err := json.Marshal(rateState{RequestsPerMinute: perMinute, LearnedAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(s.Path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	tmp := s.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.Path)
+}
+
+// AdaptiveLimiter — token bucket клиента, который подстраивается под ответы 429:
+// предел N в минуту берётся из тела ответа, Retry-After блокирует запросы до своего конца.
+// Пока предел неизвестен, запросы не ограничиваются.
+type AdaptiveLimiter struct {
+	bucket *TokenBucket
+	store  RateStore
+	logger *zap.SugaredLogger
+
+	mu        sync.Mutex
+	perMinute int
+}
+
+// NewAdaptiveLimiter поднимает сохранённый в store предел. store может быть nil —
+// тогда предел учится заново после каждого перезапуска.
+func NewAdaptiveLimiter(store RateStore, logger *zap.SugaredLogger) *AdaptiveLimiter {
+	if logger == nil {
+		logger = zap.S()
+	}
+	l := &AdaptiveLimiter{
+		bucket: NewTokenBucket(0, 1),
+		store:  store,
+		logger: logger.With("component", "accrual_limiter"),
+	}
+	metrics.AccrualRateLimit.Set(0)
+
+	if store != nil {
+		n, err := store.LoadRate()
+		if err != nil {
+			l.logger.Warnw("load learned accrual rate limit failed", "error", err)
+		}
+		if n > 0 {
+			l.setRate(n)
+			l.logger.Infow("restored learned accrual rate limit", "requests_per_minute", n)
+		}
+	}
+	return l
+}
+
+// Wait ждёт разрешения на запрос.
+func (l *AdaptiveLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+	return l.bucket.Wait(ctx)
+}
+
+// PerMinute — выученный предел, 0 — неизвестен.
+func (l *AdaptiveLimiter) PerMinute() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.perMinute
+}
+
+// Observe429 учитывает ответ 429: тело с пределом и Retry-After.
+func (l *AdaptiveLimiter) Observe429(body string, retryAfter time.Duration) {
+	if l == nil {
+		return
+	}
+	if retryAfter > 0 {
+		l.bucket.Block(time.Now().Add(retryAfter))
+	}
+
+	n := parseRateLimit(body)
+	if n == 0 {
+		return
+	}
+	l.mu.Lock()
+	changed := n != l.perMinute
+	l.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	l.setRate(n)
+	l.logger.Infow("learned accrual rate limit", "requests_per_minute", n)
+	if l.store != nil {
+		if err := l.store.SaveRate(n); err != nil {
+			l.logger.Warnw("save learned accrual rate limit failed", "error", err)
+		}
+	}
+}
+
+// setRate распределяет N запросов равномерно по минуте: без запаса burst
+// ни в одно окно в минуту не уходит больше N запросов.
+func (l *AdaptiveLimiter) setRate(perMinute int) {
+	l.mu.Lock()
+	l.perMinute = perMinute
+	l.mu.Unlock()
+
+	l.bucket.SetRate(float64(perMinute)/60, 1)
+	metrics.AccrualRateLimit.Set(float64(perMinute))
+}
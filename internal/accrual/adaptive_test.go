@@ -0,0 +1,104 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/metrics"
+	"github.com/g123udini/gofemart/internal/service"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	cases := map[string]int{
+		"No more than 60 requests per minute allowed": 60,
+		"no more than 1 request per minute allowed\n": 1,
+		"No more than 0 requests per minute allowed":  0,
+		"Too Many Requests":                           0,
+		"":                                            0,
+		"No more than 99999999999999999999 requests per minute": 0,
+	}
+	for body, want := range cases {
+		if got := parseRateLimit(body); got != want {
+			t.Fatalf("parseRateLimit(%q)=%d want %d", body, got, want)
+		}
+	}
+}
+
+func TestAdaptiveLimiter_LearnsAndPersists(t *testing.T) {
+	store := FileRateStore{Path: filepath.Join(t.TempDir(), "state", "accrual_rate.json")}
+
+	l := NewAdaptiveLimiter(store, nil)
+	if l.PerMinute() != 0 || l.bucket.Rate() != 0 {
+		t.Fatalf("fresh limiter must be unlimited, got %d/min", l.PerMinute())
+	}
+
+	l.Observe429("No more than 120 requests per minute allowed", 0)
+	if l.PerMinute() != 120 || l.bucket.Rate() != 2 {
+		t.Fatalf("expected 120/min (2 rps), got %d/min, %v rps", l.PerMinute(), l.bucket.Rate())
+	}
+	if v := testutil.ToFloat64(metrics.AccrualRateLimit); v != 120 {
+		t.Fatalf("rate limit metric=%v want 120", v)
+	}
+
+	// после перезапуска предел поднимается из файла
+	restored := NewAdaptiveLimiter(store, nil)
+	if restored.PerMinute() != 120 {
+		t.Fatalf("restored limit=%d want 120", restored.PerMinute())
+	}
+
+	// 429 без предела в теле не сбрасывает выученное значение
+	restored.Observe429("Too Many Requests", 0)
+	if restored.PerMinute() != 120 {
+		t.Fatalf("limit changed without a hint: %d", restored.PerMinute())
+	}
+}
+
+func TestAdaptiveLimiter_RetryAfterBlocks(t *testing.T) {
+	l := NewAdaptiveLimiter(nil, nil)
+	l.Observe429("", 50*time.Millisecond)
+
+	start := time.Now()
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if el := time.Since(start); el < 40*time.Millisecond {
+		t.Fatalf("Retry-After not honoured, waited %s", el)
+	}
+}
+
+func TestClient_GetOrder_429_LearnsRateLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte("No more than 30 requests per minute allowed"))
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, srv.Client(), WithRetryPolicy(service.RetryPolicy{MaxAttempts: 1}))
+
+	_, err := c.GetOrder(context.Background(), "123")
+	var rl RateLimitError
+	if !errors.As(err, &rl) || rl.RetryAfter != time.Minute {
+		t.Fatalf("expected RateLimitError with 60s, got %v", err)
+	}
+	if c.Limiter().PerMinute() != 30 {
+		t.Fatalf("learned limit=%d want 30", c.Limiter().PerMinute())
+	}
+
+	// до конца Retry-After клиент сам не ходит в систему расчёта и не ждёт дедлайна
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.GetOrder(ctx, "123"); !errors.As(err, &rl) || !rl.Local {
+		t.Fatalf("expected a local RateLimitError from the limiter, got %v", err)
+	}
+	if el := time.Since(start); el > 10*time.Millisecond {
+		t.Fatalf("limiter waited %s for a token it could not get before the deadline", el)
+	}
+}
@@ -0,0 +1,266 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/metrics"
+	"go.uber.org/zap"
+)
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerHalfOpen
+	BreakerOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerHalfOpen:
+		return "half-open"
+	case BreakerOpen:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
+var ErrBreakerOpen = errors.New("accrual circuit breaker is open")
+
+// BreakerOpenError возвращается без обращения к системе расчёта, пока breaker открыт.
+type BreakerOpenError struct {
+	Until time.Time
+}
+
+func (e *BreakerOpenError) Error() string {
+	return fmt.Sprintf("%s until %s", ErrBreakerOpen, e.Until.Format(time.RFC3339))
+}
+
+func (e *BreakerOpenError) Is(target error) bool {
+	return target == ErrBreakerOpen
+}
+
+// BreakerConfig задаёт срабатывание. Нулевые поля оставляют значения по умолчанию.
+type BreakerConfig struct {
+	// Window — сколько последних вызовов учитывать.
+	Window int
+	// MinRequests — меньше вызовов в окне не хватает для решения.
+	MinRequests int
+	// FailureRatio — доля ошибок в окне, при которой breaker открывается.
+	FailureRatio float64
+	// CoolDown — сколько держать breaker открытым до пробного запроса.
+	CoolDown time.Duration
+}
+
+func (c BreakerConfig) withDefaults() BreakerConfig {
+	if c.Window <= 0 {
+		c.Window = 20
+	}
+	if c.MinRequests <= 0 {
+		c.MinRequests = 10
+	}
+	if c.MinRequests > c.Window {
+		c.MinRequests = c.Window
+	}
+	if c.FailureRatio <= 0 || c.FailureRatio > 1 {
+		c.FailureRatio = 0.5
+	}
+	if c.CoolDown <= 0 {
+		c.CoolDown = 30 * time.Second
+	}
+	return c
+}
+
+// Breaker — circuit breaker вокруг AccrualClient.
+//
+// closed: вызовы проходят, результаты копятся в окне; при доле ошибок FailureRatio → open.
+// open: вызовы сразу получают BreakerOpenError; через CoolDown → half-open.
+// half-open: пропускается один пробный вызов; успех → closed, ошибка → снова open.
+//
+// Ошибкой считаются сетевые сбои, таймауты и 5xx. 204, 429 и аномальные ответы — ответы
+// работающей системы, отмена ctx вызывающим — не вина системы расчёта.
+type Breaker struct {
+	client AccrualClient
+	cfg    BreakerConfig
+	logger *zap.SugaredLogger
+	now    func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState
+	results  []bool // кольцо результатов, true — ошибка
+	next     int
+	filled   int
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func NewBreaker(client AccrualClient, cfg BreakerConfig, logger *zap.SugaredLogger) *Breaker {
+	if logger == nil {
+		logger = zap.S()
+	}
+	cfg = cfg.withDefaults()
+	b := &Breaker{
+		client:  client,
+		cfg:     cfg,
+		logger:  logger.With("component", "accrual_breaker"),
+		now:     time.Now,
+		results: make([]bool, cfg.Window),
+	}
+	metrics.AccrualBreakerState.Set(float64(BreakerClosed))
+	return b
+}
+
+func (b *Breaker) GetOrder(ctx context.Context, number string) (OrderInfo, error) {
+	if err := b.allow(); err != nil {
+		return OrderInfo{}, err
+	}
+
+	info, err := b.client.GetOrder(ctx, number)
+	if isCallerCancel(ctx, err) {
+		b.release()
+		return info, err
+	}
+	b.record(isBreakerFailure(err))
+	return info, err
+}
+
+// State возвращает текущее состояние с учётом истёкшего cool-down.
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && !b.now().Before(b.openUntil()) {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// Check — проверка для /readyz: открытый breaker значит, что система расчёта недоступна.
+func (b *Breaker) Check(context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && b.now().Before(b.openUntil()) {
+		return &BreakerOpenError{Until: b.openUntil()}
+	}
+	return nil
+}
+
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Before(b.openUntil()) {
+			return &BreakerOpenError{Until: b.openUntil()}
+		}
+		b.transition(BreakerHalfOpen)
+		b.probing = true
+		return nil
+
+	case BreakerHalfOpen:
+		// пробный запрос уже в полёте — остальные ждут его результата
+		if b.probing {
+			return &BreakerOpenError{Until: b.now().Add(b.cfg.CoolDown)}
+		}
+		b.probing = true
+		return nil
+	}
+	return nil
+}
+
+// release отпускает пробу, не засчитывая результат.
+func (b *Breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *Breaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerHalfOpen {
+		b.probing = false
+		if failed {
+			b.open()
+		} else {
+			b.reset()
+			b.transition(BreakerClosed)
+		}
+		return
+	}
+	if b.state == BreakerOpen {
+		return
+	}
+
+	if b.filled == len(b.results) && b.results[b.next] {
+		b.failures--
+	}
+	b.results[b.next] = failed
+	if failed {
+		b.failures++
+	}
+	b.next = (b.next + 1) % len(b.results)
+	if b.filled < len(b.results) {
+		b.filled++
+	}
+
+	if b.filled >= b.cfg.MinRequests && float64(b.failures)/float64(b.filled) >= b.cfg.FailureRatio {
+		b.open()
+	}
+}
+
+func (b *Breaker) open() {
+	b.openedAt = b.now()
+	b.reset()
+	b.transition(BreakerOpen)
+}
+
+func (b *Breaker) reset() {
+	clear(b.results)
+	b.next, b.filled, b.failures = 0, 0, 0
+}
+
+func (b *Breaker) openUntil() time.Time {
+	return b.openedAt.Add(b.cfg.CoolDown)
+}
+
+// transition — единственное место, где пишется лог: одна строка на смену состояния.
+func (b *Breaker) transition(to BreakerState) {
+	if b.state == to {
+		return
+	}
+	from := b.state
+	b.state = to
+	metrics.AccrualBreakerState.Set(float64(to))
+
+	switch to {
+	case BreakerOpen:
+		b.logger.Warnw("accrual circuit breaker opened", "from", from.String(), "retry_at", b.openUntil())
+	default:
+		b.logger.Infow("accrual circuit breaker state changed", "from", from.String(), "to", to.String())
+	}
+}
+
+func isBreakerFailure(err error) bool {
+	var rl RateLimitError
+	switch {
+	case err == nil, errors.Is(err, ErrNotRegistered), errors.Is(err, ErrAnomalous), errors.As(err, &rl):
+		return false
+	}
+	return true
+}
+
+func isCallerCancel(ctx context.Context, err error) bool {
+	return err != nil && errors.Is(err, context.Canceled) && ctx.Err() != nil
+}
@@ -0,0 +1,194 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// stubClient отвечает err на каждый вызов и считает вызовы.
+type stubClient struct {
+	mu    sync.Mutex
+	err   error
+	calls int
+}
+
+func (c *stubClient) GetOrder(context.Context, string) (OrderInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	if c.err != nil {
+		return OrderInfo{}, c.err
+	}
+	return OrderInfo{Status: StatusProcessing}, nil
+}
+
+func (c *stubClient) set(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+}
+
+func newTestBreaker(client AccrualClient, now *time.Time) (*Breaker, *observer.ObservedLogs) {
+	core, logs := observer.New(zap.InfoLevel)
+	b := NewBreaker(client, BreakerConfig{Window: 4, MinRequests: 4, FailureRatio: 0.5, CoolDown: time.Minute}, zap.New(core).Sugar())
+	b.now = func() time.Time { return *now }
+	return b, logs
+}
+
+func TestBreaker_OpensOnFailureRatioAndFailsFast(t *testing.T) {
+	now := time.Now()
+	client := &stubClient{err: errors.New("connection refused")}
+	b, logs := newTestBreaker(client, &now)
+
+	for i := 0; i < 4; i++ {
+		_, _ = b.GetOrder(context.Background(), "1")
+	}
+	if b.State() != BreakerOpen {
+		t.Fatalf("state=%s want=open", b.State())
+	}
+
+	for i := 0; i < 100; i++ {
+		_, err := b.GetOrder(context.Background(), "1")
+		if !errors.Is(err, ErrBreakerOpen) {
+			t.Fatalf("err=%v want ErrBreakerOpen", err)
+		}
+	}
+	if client.calls != 4 {
+		t.Fatalf("calls=%d want=4: open breaker must not reach the client", client.calls)
+	}
+	if logs.Len() != 1 {
+		t.Fatalf("logged %d lines, want a single transition line", logs.Len())
+	}
+	if err := b.Check(context.Background()); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("Check=%v want ErrBreakerOpen", err)
+	}
+}
+
+func TestBreaker_HalfOpenProbe(t *testing.T) {
+	now := time.Now()
+	client := &stubClient{err: errors.New("503")}
+	b, _ := newTestBreaker(client, &now)
+
+	for i := 0; i < 4; i++ {
+		_, _ = b.GetOrder(context.Background(), "1")
+	}
+
+	// проба после cool-down снова неудачна — breaker открывается заново
+	now = now.Add(time.Minute)
+	if b.State() != BreakerHalfOpen {
+		t.Fatalf("state=%s want=half-open after cool-down", b.State())
+	}
+	_, _ = b.GetOrder(context.Background(), "1")
+	if b.State() != BreakerOpen || client.calls != 5 {
+		t.Fatalf("state=%s calls=%d, want open after a failed probe", b.State(), client.calls)
+	}
+
+	// успешная проба закрывает breaker
+	now = now.Add(time.Minute)
+	client.set(nil)
+	if _, err := b.GetOrder(context.Background(), "1"); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if b.State() != BreakerClosed {
+		t.Fatalf("state=%s want=closed", b.State())
+	}
+	if err := b.Check(context.Background()); err != nil {
+		t.Fatalf("Check=%v want nil", err)
+	}
+}
+
+func TestBreaker_IgnoresNotRegisteredRateLimitAndCancel(t *testing.T) {
+	now := time.Now()
+	for _, err := range []error{ErrNotRegistered, RateLimitError{RetryAfter: time.Second}} {
+		b, _ := newTestBreaker(&stubClient{err: err}, &now)
+		for i := 0; i < 10; i++ {
+			_, _ = b.GetOrder(context.Background(), "1")
+		}
+		if b.State() != BreakerClosed {
+			t.Fatalf("%v must not open the breaker", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b, _ := newTestBreaker(&stubClient{err: context.Canceled}, &now)
+	for i := 0; i < 10; i++ {
+		_, _ = b.GetOrder(ctx, "1")
+	}
+	if b.State() != BreakerClosed {
+		t.Fatalf("caller cancellation must not open the breaker")
+	}
+}
+
+func TestAccrualWorker_BacksOffWhileBreakerOpen(t *testing.T) {
+	repo := &fakeRepo{pendingBatches: [][]int64{{1, 2, 3}, {4}, {5}}}
+	client := &fakeClient{
+		results: []getOrderResult{
+			{err: &BreakerOpenError{Until: time.Now().Add(time.Second)}},
+			{info: OrderInfo{Order: "2", Status: StatusInvalid}},
+		},
+	}
+
+	w := NewAccrualWorker(repo, client, zap.NewNop().Sugar())
+	w.pollEvery = 5 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 80*time.Millisecond)
+	defer cancel()
+	w.Run(ctx)
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if len(client.calls) != 1 {
+		t.Fatalf("calls=%v, worker must stop the batch while the breaker is open", client.calls)
+	}
+	if repo.listCalls != 1 {
+		t.Fatalf("listCalls=%d, worker must not poll the database while the breaker is open", repo.listCalls)
+	}
+}
+
+// Ожидание собственного ограничителя не должно выглядеть для breaker сбоем системы расчёта.
+func TestAccrualWorker_LearnedRateDoesNotOpenBreaker(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		number := strings.TrimPrefix(r.URL.Path, "/api/orders/")
+		_, _ = w.Write([]byte(`{"order":"` + number + `","status":"PROCESSING"}`))
+	}))
+	defer srv.Close()
+
+	limiter := NewAdaptiveLimiter(nil, zap.NewNop().Sugar())
+	limiter.Observe429("No more than 60 requests per minute allowed", 0)
+	client := NewClient(srv.URL, srv.Client(), WithRateLimiter(limiter))
+
+	now := time.Now()
+	b, _ := newTestBreaker(client, &now)
+	repo := &fakeRepo{}
+	w := NewAccrualWorker(repo, b, zap.NewNop().Sugar())
+
+	for i := 0; i < 12; i++ {
+		w.process(context.Background(), 79927398713)
+	}
+
+	if b.State() != BreakerClosed {
+		t.Fatalf("breaker state=%s, waiting for a limiter token must not count as a failure", b.State())
+	}
+	if hits.Load() != 1 || len(repo.updated) != 1 {
+		t.Fatalf("hits=%d updated=%d, want one request within the learned rate", hits.Load(), len(repo.updated))
+	}
+	if !w.paused() {
+		t.Fatalf("worker must pause until the limiter has a token")
+	}
+}
@@ -5,9 +5,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/g123udini/gofemart/internal/metrics"
+	"github.com/g123udini/gofemart/internal/service"
+	"github.com/g123udini/gofemart/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"io"
 	"net/http"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -30,30 +37,154 @@ var ErrNotRegistered = errors.New("order not registered (204)")
 
 type RateLimitError struct {
 	RetryAfter time.Duration
+	// Local — сработал собственный ограничитель клиента, запрос не отправлялся.
+	Local bool
 }
 
 func (e RateLimitError) Error() string {
 	return fmt.Sprintf("accrual rate limited, retry after %s", e.RetryAfter)
 }
 
+// Unwrap даёт service.RetryHTTP увидеть 429 и Retry-After.
+func (e RateLimitError) Unwrap() error {
+	return &service.HTTPError{StatusCode: http.StatusTooManyRequests, RetryAfter: e.RetryAfter}
+}
+
+// maxResponseBody — ответ о заказе укладывается в сотню байт; больше не читаем.
+const maxResponseBody = 64 << 10
+
 type Client struct {
-	baseURL string
-	http    *http.Client
+	baseURL    string
+	http       *http.Client
+	retry      service.RetryPolicy
+	limiter    *AdaptiveLimiter
+	maxAccrual float64
+
+	readyTTL time.Duration
+	readyMu  sync.Mutex
+	readyAt  time.Time
+	readyErr error
+}
+
+// DefaultReadyTTL — сколько Ready отдаёт последний результат пинга, не трогая accrual.
+const DefaultReadyTTL = 10 * time.Second
+
+type ClientOption func(c *Client)
+
+// WithRetryPolicy заменяет политику повторов GetOrder. Op и пустые Classifiers
+// заполняются значениями по умолчанию.
+func WithRetryPolicy(p service.RetryPolicy) ClientOption {
+	return func(c *Client) {
+		c.retry = p
+	}
 }
 
-func NewClient(baseURL string, httpClient *http.Client) *Client {
+// WithRateLimiter задаёт ограничитель запросов, например с сохранением выученного предела.
+func WithRateLimiter(l *AdaptiveLimiter) ClientOption {
+	return func(c *Client) {
+		c.limiter = l
+	}
+}
+
+// WithMaxAccrual задаёт начисление, выше которого ответ считается аномальным.
+func WithMaxAccrual(v float64) ClientOption {
+	return func(c *Client) {
+		if v > 0 {
+			c.maxAccrual = v
+		}
+	}
+}
+
+// WithReadyTTL задаёт, как долго Ready кэширует результат пинга.
+func WithReadyTTL(d time.Duration) ClientOption {
+	return func(c *Client) {
+		if d > 0 {
+			c.readyTTL = d
+		}
+	}
+}
+
+// DefaultRetryPolicy повторяет обрывы соединения и ответы 5xx/429. Ответ 429 с Retry-After
+// длиннее бюджета сразу возвращается вызывающему: паузу выдерживает воркер.
+func DefaultRetryPolicy() service.RetryPolicy {
+	return service.RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   50 * time.Millisecond,
+		MaxDelay:    500 * time.Millisecond,
+		MaxElapsed:  2 * time.Second,
+		Hooks:       metrics.RetryHooks(),
+	}
+}
+
+func NewClient(baseURL string, httpClient *http.Client, opts ...ClientOption) *Client {
 	baseURL = strings.TrimRight(strings.TrimSpace(baseURL), "/")
 	if httpClient == nil {
 		httpClient = &http.Client{Timeout: 3 * time.Second}
 	}
-	return &Client{baseURL: baseURL, http: httpClient}
+	c := &Client{baseURL: baseURL, http: httpClient, retry: DefaultRetryPolicy(), maxAccrual: DefaultMaxAccrual, readyTTL: DefaultReadyTTL}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.limiter == nil {
+		c.limiter = NewAdaptiveLimiter(nil, nil)
+	}
+	c.retry.Op = "accrual.GetOrder"
+	if len(c.retry.Classifiers) == 0 {
+		c.retry.Classifiers = []service.Classifier{service.RetryConnReset, service.RetryHTTP}
+	}
+	return c
 }
 
 func (c *Client) GetOrder(ctx context.Context, number string) (OrderInfo, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "accrual.GetOrder", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	oi, err := service.RetryValue(ctx, c.retry, func(ctx context.Context) (OrderInfo, error) {
+		return c.getOrder(ctx, number)
+	})
+
+	res := outcome(err)
+	metrics.AccrualRequests.WithLabelValues(res).Inc()
+	span.SetAttributes(attribute.String("accrual.outcome", res))
+	if res == metrics.AccrualOutcomeError {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return oi, err
+}
+
+func outcome(err error) string {
+	var rl RateLimitError
+	switch {
+	case err == nil:
+		return metrics.AccrualOutcomeOK
+	case errors.Is(err, ErrNotRegistered):
+		return metrics.AccrualOutcomeNoContent
+	case errors.As(err, &rl):
+		return metrics.AccrualOutcomeRateLimited
+	case errors.Is(err, ErrAnomalous):
+		return metrics.AccrualOutcomeAnomaly
+	default:
+		return metrics.AccrualOutcomeError
+	}
+}
+
+// Limiter — ограничитель клиента, выученный из ответов 429.
+func (c *Client) Limiter() *AdaptiveLimiter {
+	return c.limiter
+}
+
+func (c *Client) getOrder(ctx context.Context, number string) (OrderInfo, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return OrderInfo{}, err
+	}
+
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/api/orders/"+number, nil)
 	if err != nil {
 		return OrderInfo{}, err
 	}
+	tracing.Inject(ctx, req.Header)
 
 	resp, err := c.http.Do(req)
 	if err != nil {
@@ -61,29 +192,94 @@ func (c *Client) GetOrder(ctx context.Context, number string) (OrderInfo, error)
 	}
 	defer resp.Body.Close()
 
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+
 	switch resp.StatusCode {
 	case http.StatusOK: // 200
-		var oi OrderInfo
-		if err := json.NewDecoder(resp.Body).Decode(&oi); err != nil {
+		// тело нужно целиком: аномальный ответ сохраняется в карантин как есть
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+		if err != nil {
 			return OrderInfo{}, err
 		}
+		var oi OrderInfo
+		if err := json.Unmarshal(body, &oi); err != nil {
+			return OrderInfo{}, &AnomalyError{Reason: AnomalyMalformedBody, Detail: err.Error(), Payload: string(body)}
+		}
+		if reason, detail := validateOrderInfo(number, oi, c.maxAccrual); reason != "" {
+			return OrderInfo{}, &AnomalyError{Reason: reason, Detail: detail, Payload: string(body)}
+		}
 		return oi, nil
 
 	case http.StatusNoContent: // 204
 		return OrderInfo{}, ErrNotRegistered
 
 	case http.StatusTooManyRequests: // 429
-		return OrderInfo{}, RateLimitError{RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		c.limiter.Observe429(string(body), retryAfter)
+		return OrderInfo{}, RateLimitError{RetryAfter: retryAfter}
 
 	default:
-		return OrderInfo{}, fmt.Errorf("accrual unexpected status code=%d", resp.StatusCode)
+		return OrderInfo{}, fmt.Errorf("accrual %w", &service.HTTPError{StatusCode: resp.StatusCode})
+	}
+}
+
+// Ready — проверка готовности для /readyz: пинг не чаще раза в readyTTL,
+// в промежутке отдаётся последний результат. Если ограничитель не даёт
+// отправить пинг, остаётся прежний результат: занятый лимит — не недоступность.
+func (c *Client) Ready(ctx context.Context) error {
+	c.readyMu.Lock()
+	defer c.readyMu.Unlock()
+
+	if !c.readyAt.IsZero() && time.Since(c.readyAt) < c.readyTTL {
+		return c.readyErr
+	}
+
+	err := c.Ping(ctx)
+	var rl RateLimitError
+	if errors.As(err, &rl) && rl.Local {
+		return c.readyErr
+	}
+	c.readyAt, c.readyErr = time.Now(), err
+	return err
+}
+
+// Ping проверяет, что система расчёта отвечает. Любой ответ, кроме 5xx,
+// считаем признаком доступности: 204 на несуществующий заказ — норма.
+// Пинг расходует тот же лимит запросов, что и опрос заказов.
+func (c *Client) Ping(ctx context.Context) error {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/api/orders/0", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		c.limiter.Observe429(string(body), parseRetryAfter(resp.Header.Get("Retry-After")))
+		return nil
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("accrual unexpected status code=%d", resp.StatusCode)
 	}
+	return nil
 }
 
+// parseRetryAfter понимает секунды и HTTP-дату; без внятного значения ждём минуту.
 func parseRetryAfter(v string) time.Duration {
-	sec, err := strconv.Atoi(strings.TrimSpace(v))
-	if err != nil || sec <= 0 {
-		return 60 * time.Second
+	const fallback = 60 * time.Second
+	d := service.Parse(strings.TrimSpace(v), fallback)
+	if d <= 0 {
+		return fallback
 	}
-	return time.Duration(sec) * time.Second
+	return d
 }
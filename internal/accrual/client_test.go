@@ -6,8 +6,16 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/g123udini/gofemart/internal/metrics"
+	"github.com/g123udini/gofemart/internal/service"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 func TestClient_GetOrder_OK_WithAccrual(t *testing.T) {
@@ -114,6 +122,32 @@ func TestClient_GetOrder_UnexpectedStatus(t *testing.T) {
 	}
 }
 
+func TestClient_GetOrder_RetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"order":"123","status":"PROCESSED","accrual":5}`))
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, srv.Client(), WithRetryPolicy(service.RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+	}))
+
+	oi, err := c.GetOrder(context.Background(), "123")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if oi.Status != StatusProcessed || calls.Load() != 2 {
+		t.Fatalf("status=%s calls=%d", oi.Status, calls.Load())
+	}
+}
+
 func TestClient_GetOrder_InvalidJSON(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -150,3 +184,97 @@ func TestParseRetryAfter(t *testing.T) {
 		})
 	}
 }
+
+func TestClient_Ping(t *testing.T) {
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, srv.Client())
+
+	if err := c.Ping(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	status = http.StatusInternalServerError
+	if err := c.Ping(context.Background()); err == nil {
+		t.Fatalf("expected error on 500")
+	}
+
+	srv.Close()
+	if err := c.Ping(context.Background()); err == nil {
+		t.Fatalf("expected error when server is down")
+	}
+}
+
+func TestClient_Ready_CachesPing(t *testing.T) {
+	var calls atomic.Int32
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, srv.Client(), WithReadyTTL(time.Hour))
+
+	for i := 0; i < 5; i++ {
+		if err := c.Ready(context.Background()); err != nil {
+			t.Fatalf("Ready: %v", err)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("calls=%d want=1: probes within TTL must not reach accrual", calls.Load())
+	}
+
+	// результат держится до конца TTL, затем пинг повторяется
+	status = http.StatusInternalServerError
+	c.readyAt = time.Now().Add(-2 * time.Hour)
+	if err := c.Ready(context.Background()); err == nil {
+		t.Fatalf("expected error after TTL on 500")
+	}
+	if err := c.Ready(context.Background()); err == nil || calls.Load() != 2 {
+		t.Fatalf("err=%v calls=%d: cached failure must be returned without a new request", err, calls.Load())
+	}
+}
+
+func TestClient_GetOrder_RecordsOutcome(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, srv.Client())
+
+	before := testutil.ToFloat64(metrics.AccrualRequests.WithLabelValues(metrics.AccrualOutcomeNoContent))
+	_, _ = c.GetOrder(context.Background(), "123")
+	after := testutil.ToFloat64(metrics.AccrualRequests.WithLabelValues(metrics.AccrualOutcomeNoContent))
+
+	if after-before != 1 {
+		t.Fatalf("204 outcome delta=%v want=1", after-before)
+	}
+}
+
+func TestClient_GetOrder_PropagatesTraceparent(t *testing.T) {
+	old := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+	defer otel.SetTracerProvider(old)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, srv.Client())
+
+	_, _ = c.GetOrder(context.Background(), "123")
+
+	if got == "" {
+		t.Fatalf("traceparent header was not sent to accrual system")
+	}
+}
@@ -0,0 +1,59 @@
+package accrual
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/g123udini/gofemart/internal/repository"
+)
+
+var (
+	// ErrBadNotification — уведомление нельзя отнести ни к одному заказу.
+	ErrBadNotification = errors.New("bad accrual notification")
+	// ErrUnknownOrder — такой заказ в gophermart не загружали.
+	ErrUnknownOrder = errors.New("order not found")
+	// ErrOrderParked — заказ в карантине или dead letter: его судьбу решает администратор.
+	ErrOrderParked = errors.New("order is parked for review")
+)
+
+// Ingest применяет уведомление о статусе заказа, которое система расчёта прислала сама.
+// Уведомление проходит те же проверки, что и ответ на опрос: аномальное уходит
+// в карантин и возвращается как *AnomalyError. Финальные статусы идемпотентны,
+// поэтому повтор уведомления или гонка с опросом начисление не удваивают.
+// Заказ, который сейчас опрашивает другой экземпляр, даёт repository.ErrLeaseLost.
+// Незагруженный заказ даёт ErrUnknownOrder, снятый с опроса — ErrOrderParked.
+func (w *AccrualWorker) Ingest(ctx context.Context, info OrderInfo) error {
+	num, err := strconv.ParseInt(strings.TrimSpace(info.Order), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: order %q", ErrBadNotification, info.Order)
+	}
+
+	parked, err := w.repo.GetOrderParked(ctx, num)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		return fmt.Errorf("%w: %d", ErrUnknownOrder, num)
+	case err != nil:
+		return fmt.Errorf("get order: %w", err)
+	case parked != "":
+		return fmt.Errorf("%w: order %d is %s", ErrOrderParked, num, parked)
+	}
+
+	if reason, detail := validateOrderInfo(info.Order, info, w.maxAccrual); reason != "" {
+		payload, _ := json.Marshal(info)
+		an := &AnomalyError{Reason: reason, Detail: detail, Payload: string(payload)}
+		w.quarantine(ctx, num, an)
+		return an
+	}
+	if info.Status == StatusRegistered || info.Status == StatusProcessing {
+		// уведомление — не опрос: попытки и время следующего опроса не трогаем
+		if err := w.repo.SetOrderStatusNonFinal(ctx, num, string(info.Status)); err != nil {
+			return fmt.Errorf("set order status: %w", err)
+		}
+		return nil
+	}
+	return w.apply(ctx, num, info)
+}
@@ -0,0 +1,66 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestWorker_Ingest(t *testing.T) {
+	repo := &fakeRepo{}
+	w := NewAccrualWorker(repo, &fakeClient{}, nil)
+	ctx := context.Background()
+	acc := 12.5
+
+	if err := w.Ingest(ctx, OrderInfo{Order: "12345678903", Status: StatusProcessed, Accrual: &acc}); err != nil {
+		t.Fatalf("Ingest(PROCESSED): %v", err)
+	}
+	if len(repo.processed) != 1 || repo.processed[0].num != 12345678903 || repo.processed[0].accural != 1250 {
+		t.Fatalf("processed=%+v want order 12345678903 with 1250 cents", repo.processed)
+	}
+
+	if err := w.Ingest(ctx, OrderInfo{Order: "79927398713", Status: StatusInvalid}); err != nil {
+		t.Fatalf("Ingest(INVALID): %v", err)
+	}
+	if len(repo.invalid) != 1 || repo.invalid[0] != 79927398713 {
+		t.Fatalf("invalid=%v want [79927398713]", repo.invalid)
+	}
+
+	// промежуточный статус из уведомления не считается опросом
+	if err := w.Ingest(ctx, OrderInfo{Order: "18", Status: StatusProcessing}); err != nil {
+		t.Fatalf("Ingest(PROCESSING): %v", err)
+	}
+	if len(repo.statusSet) != 1 || repo.statusSet[0] != "18:PROCESSING" || len(repo.updated) != 0 {
+		t.Fatalf("statusSet=%v updated=%v: push must not postpone the poll", repo.statusSet, repo.updated)
+	}
+
+	// уведомление проверяется так же, как ответ на опрос
+	neg := -1.0
+	err := w.Ingest(ctx, OrderInfo{Order: "42", Status: StatusProcessed, Accrual: &neg})
+	if !errors.Is(err, ErrAnomalous) {
+		t.Fatalf("negative accrual err=%v want ErrAnomalous", err)
+	}
+	if len(repo.quarantined) != 1 || repo.quarantined[0] != "42:"+AnomalyNegativeAccrual {
+		t.Fatalf("quarantined=%v", repo.quarantined)
+	}
+	if len(repo.processed) != 1 {
+		t.Fatalf("anomalous notification must not be applied")
+	}
+
+	if err := w.Ingest(ctx, OrderInfo{Order: "abc", Status: StatusInvalid}); !errors.Is(err, ErrBadNotification) {
+		t.Fatalf("bad order err=%v want ErrBadNotification", err)
+	}
+
+	// незагруженный и снятый с опроса заказы уведомление не меняет
+	repo.missing = map[int64]bool{404: true}
+	repo.parked = map[int64]string{26: "QUARANTINED"}
+	if err := w.Ingest(ctx, OrderInfo{Order: "404", Status: StatusProcessed, Accrual: &acc}); !errors.Is(err, ErrUnknownOrder) {
+		t.Fatalf("unknown order err=%v want ErrUnknownOrder", err)
+	}
+	if err := w.Ingest(ctx, OrderInfo{Order: "26", Status: StatusProcessed, Accrual: &acc}); !errors.Is(err, ErrOrderParked) {
+		t.Fatalf("parked order err=%v want ErrOrderParked", err)
+	}
+	if len(repo.processed) != 1 {
+		t.Fatalf("processed=%+v: unknown or parked order must not be credited", repo.processed)
+	}
+}
@@ -0,0 +1,123 @@
+package accrual
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/service"
+)
+
+// TokenBucket — ограничитель частоты, общий для всех горутин воркера.
+// Токены копятся со скоростью rate в секунду, но не больше burst.
+// Нулевой *TokenBucket и rate <= 0 ничего не ограничивают.
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+
+	blockedUntil time.Time
+}
+
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst <= 0 {
+		burst = 1
+	}
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		now:    time.Now,
+	}
+}
+
+// Wait ждёт свободный токен. Отмена ctx прерывает ожидание, токен при этом не тратится.
+// Если токен не появится до дедлайна ctx, Wait сразу возвращает RateLimitError с Local:
+// запрос не отправлялся, и breaker не считает это сбоем системы расчёта.
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	if b == nil {
+		return ctx.Err()
+	}
+	for {
+		wait := b.reserve()
+		if wait == 0 {
+			return nil
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return RateLimitError{RetryAfter: wait, Local: true}
+		}
+		if err := service.SleepContext(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// SetRate меняет частоту на ходу. Накопленные токены сверх нового burst сгорают.
+func (b *TokenBucket) SetRate(rate float64, burst int) {
+	if burst <= 0 {
+		burst = 1
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(b.now())
+	b.rate = rate
+	b.burst = float64(burst)
+	b.tokens = min(b.tokens, b.burst)
+}
+
+// Rate — текущая частота в запросах в секунду, 0 — без ограничения.
+func (b *TokenBucket) Rate() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return max(b.rate, 0)
+}
+
+// Block не выдаёт токены до until, например до конца Retry-After.
+// Более ранний until не сокращает уже действующую блокировку.
+func (b *TokenBucket) Block(until time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if until.After(b.blockedUntil) {
+		b.blockedUntil = until
+		b.tokens = 0
+	}
+}
+
+// reserve забирает токен и возвращает 0 либо время, через которое он появится.
+func (b *TokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if now.Before(b.blockedUntil) {
+		return b.blockedUntil.Sub(now)
+	}
+	if b.rate <= 0 {
+		return 0
+	}
+	b.refill(now)
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	// во время блокировки токены не копятся
+	from := b.last
+	if b.blockedUntil.After(from) {
+		from = b.blockedUntil
+	}
+	if now.After(from) && b.rate > 0 {
+		b.tokens = min(b.burst, b.tokens+now.Sub(from).Seconds()*b.rate)
+	}
+	b.last = now
+}
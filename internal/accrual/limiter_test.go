@@ -0,0 +1,52 @@
+package accrual
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket_Refill(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := NewTokenBucket(10, 2)
+	b.now = func() time.Time { return now }
+	b.last = now
+
+	if d := b.reserve(); d != 0 {
+		t.Fatalf("first token must be free, wait=%s", d)
+	}
+	if d := b.reserve(); d != 0 {
+		t.Fatalf("second token must be free within burst, wait=%s", d)
+	}
+	if d := b.reserve(); d != 100*time.Millisecond {
+		t.Fatalf("expected 100ms wait after burst, got %s", d)
+	}
+
+	// за секунду накопилось бы 10 токенов, но больше burst не бывает
+	now = now.Add(time.Second)
+	b.reserve()
+	b.reserve()
+	if d := b.reserve(); d == 0 {
+		t.Fatalf("bucket must not hold more than burst tokens")
+	}
+}
+
+func TestTokenBucket_WaitCancelled(t *testing.T) {
+	b := NewTokenBucket(0.001, 1)
+	if err := b.Wait(context.Background()); err != nil {
+		t.Fatalf("first token: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.Wait(ctx); err == nil {
+		t.Fatalf("expected ctx error while waiting for a token")
+	}
+}
+
+func TestTokenBucket_NilIsUnlimited(t *testing.T) {
+	var b *TokenBucket
+	if err := b.Wait(context.Background()); err != nil {
+		t.Fatalf("nil bucket must not limit: %v", err)
+	}
+}
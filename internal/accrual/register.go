@@ -0,0 +1,91 @@
+package accrual
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/g123udini/gofemart/internal/service"
+	"github.com/g123udini/gofemart/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// ErrAlreadyRegistered — система расчёта уже знает заказ (409). Для повторной
+	// регистрации после обрыва это нормальный исход.
+	ErrAlreadyRegistered = errors.New("order already registered (409)")
+	// ErrReceiptRejected — система расчёта не приняла чек (400); повтор не поможет.
+	ErrReceiptRejected = errors.New("receipt rejected (400)")
+)
+
+// Good — позиция чека в формате системы расчёта: цена в рублях.
+type Good struct {
+	Description string  `json:"description"`
+	Price       float64 `json:"price"`
+}
+
+type Receipt struct {
+	Order string `json:"order"`
+	Goods []Good `json:"goods"`
+}
+
+// RegisterOrder регистрирует чек для расчёта (POST /api/orders).
+// Повторять запрос безопасно: уже зарегистрированный заказ даёт ErrAlreadyRegistered.
+func (c *Client) RegisterOrder(ctx context.Context, receipt Receipt) error {
+	ctx, span := tracing.Tracer().Start(ctx, "accrual.RegisterOrder", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	body, err := json.Marshal(receipt)
+	if err != nil {
+		return err
+	}
+
+	p := c.retry
+	p.Op = "accrual.RegisterOrder"
+	return p.Do(ctx, func(ctx context.Context) error {
+		return c.registerOrder(ctx, body)
+	})
+}
+
+func (c *Client) registerOrder(ctx context.Context, body []byte) error {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api/orders", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	tracing.Inject(ctx, req.Header)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+
+	switch resp.StatusCode {
+	case http.StatusAccepted, http.StatusOK:
+		return nil
+	case http.StatusConflict:
+		return ErrAlreadyRegistered
+	case http.StatusBadRequest:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%w: %s", ErrReceiptRejected, bytes.TrimSpace(msg))
+	case http.StatusTooManyRequests:
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		c.limiter.Observe429(string(msg), retryAfter)
+		return RateLimitError{RetryAfter: retryAfter}
+	default:
+		return fmt.Errorf("accrual %w", &service.HTTPError{StatusCode: resp.StatusCode})
+	}
+}
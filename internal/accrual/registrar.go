@@ -0,0 +1,174 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/metrics"
+	"github.com/g123udini/gofemart/internal/model"
+	"github.com/g123udini/gofemart/internal/repository"
+	"go.uber.org/zap"
+)
+
+// Outcome-метки регистрации чеков.
+const (
+	registrationRegistered = "registered"
+	registrationConflict   = "conflict"
+	registrationRejected   = "rejected"
+	registrationRetry      = "retry"
+	registrationFailed     = "failed"
+)
+
+type ReceiptClient interface {
+	RegisterOrder(ctx context.Context, receipt Receipt) error
+}
+
+// Registrar регистрирует в системе расчёта чеки, загруженные вместе с заказами.
+// Заказ опрашивает AccrualWorker независимо от регистрации: до неё система расчёта
+// отвечает 204, и заказ просто ждёт следующего опроса.
+type Registrar struct {
+	repo   repository.Registrations
+	client ReceiptClient
+	logger *zap.SugaredLogger
+
+	every       time.Duration
+	batchLimit  int
+	reqTimeout  time.Duration
+	maxAttempts int
+}
+
+// RegistrarConfig — нулевые поля оставляют значения по умолчанию.
+type RegistrarConfig struct {
+	Every          time.Duration
+	BatchLimit     int
+	RequestTimeout time.Duration
+	// MaxAttempts — после стольких неудач чек помечается FAILED; 0 — без ограничения.
+	MaxAttempts int
+}
+
+func NewRegistrar(repo repository.Registrations, client ReceiptClient, logger *zap.SugaredLogger, cfg RegistrarConfig) *Registrar {
+	if logger == nil {
+		logger = zap.S()
+	}
+	r := &Registrar{
+		repo:        repo,
+		client:      client,
+		logger:      logger.With("component", "accrual_registrar"),
+		every:       time.Second,
+		batchLimit:  100,
+		reqTimeout:  3 * time.Second,
+		maxAttempts: cfg.MaxAttempts,
+	}
+	if cfg.Every > 0 {
+		r.every = cfg.Every
+	}
+	if cfg.BatchLimit > 0 {
+		r.batchLimit = cfg.BatchLimit
+	}
+	if cfg.RequestTimeout > 0 {
+		r.reqTimeout = cfg.RequestTimeout
+	}
+	return r
+}
+
+func (r *Registrar) Run(ctx context.Context) {
+	t := time.NewTicker(r.every)
+	defer t.Stop()
+
+	for {
+		if _, err := r.RegisterBatch(ctx); err != nil && ctx.Err() == nil {
+			r.logger.Errorw("register receipts failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// RegisterBatch отправляет одну пачку чеков и возвращает, сколько из них зарегистрировано.
+func (r *Registrar) RegisterBatch(ctx context.Context) (int, error) {
+	receipts, err := r.repo.ClaimPendingRegistrations(ctx, r.batchLimit)
+	if err != nil {
+		return 0, err
+	}
+
+	registered := 0
+	for _, rc := range receipts {
+		if ctx.Err() != nil {
+			return registered, ctx.Err()
+		}
+
+		ok, err := r.register(ctx, rc)
+		if err != nil {
+			return registered, err
+		}
+		if !ok {
+			// 429: остаток пачки вернётся в очередь после аренды, клиент сам выдержит паузу
+			break
+		}
+		registered++
+	}
+	return registered, nil
+}
+
+// register возвращает false, если система расчёта попросила подождать.
+func (r *Registrar) register(ctx context.Context, rc model.Receipt) (bool, error) {
+	number, err := strconv.ParseInt(rc.Number, 10, 64)
+	if err != nil {
+		return false, err
+	}
+	log := r.logger.With("order", rc.Number, "attempts", rc.Attempts)
+
+	rctx, cancel := context.WithTimeout(ctx, r.reqTimeout)
+	err = r.client.RegisterOrder(rctx, toReceipt(rc))
+	cancel()
+
+	var rl RateLimitError
+	switch {
+	case err == nil || errors.Is(err, ErrAlreadyRegistered):
+		outcome := registrationRegistered
+		if err != nil {
+			outcome = registrationConflict
+		}
+		metrics.ReceiptRegistrations.WithLabelValues(outcome).Inc()
+		log.Infow("receipt registered", "event", "receipt_registered", "outcome", outcome)
+		return true, r.repo.MarkRegistered(ctx, number)
+
+	case errors.As(err, &rl):
+		metrics.ReceiptRegistrations.WithLabelValues(registrationRetry).Inc()
+		log.Debugw("receipt registration rate limited", "retry_after", rl.RetryAfter)
+		return false, nil
+
+	case errors.Is(err, ErrReceiptRejected):
+		metrics.ReceiptRegistrations.WithLabelValues(registrationRejected).Inc()
+		log.Warnw("receipt rejected by accrual", "event", "receipt_rejected", "error", err)
+		return true, r.repo.MarkRegistrationFailed(ctx, number, err.Error(), true)
+
+	case ctx.Err() != nil:
+		return false, ctx.Err()
+	}
+
+	final := r.maxAttempts > 0 && rc.Attempts+1 >= r.maxAttempts
+	if final {
+		metrics.ReceiptRegistrations.WithLabelValues(registrationFailed).Inc()
+		log.Warnw("receipt registration failed", "event", "receipt_registration_failed", "error", err)
+	} else {
+		metrics.ReceiptRegistrations.WithLabelValues(registrationRetry).Inc()
+		log.Infow("receipt registration will be retried", "error", err)
+	}
+	return true, r.repo.MarkRegistrationFailed(ctx, number, err.Error(), final)
+}
+
+// toReceipt переводит цены из копеек в рубли, как их ждёт система расчёта.
+func toReceipt(rc model.Receipt) Receipt {
+	goods := make([]Good, 0, len(rc.Goods))
+	for _, g := range rc.Goods {
+		goods = append(goods, Good{Description: g.Description, Price: float64(g.Price) / 100})
+	}
+	return Receipt{Order: rc.Number, Goods: goods}
+}
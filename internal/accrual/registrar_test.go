@@ -0,0 +1,153 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/accrualserver"
+	"github.com/g123udini/gofemart/internal/model"
+	"github.com/g123udini/gofemart/internal/repository"
+)
+
+func TestClient_RegisterOrder_Statuses(t *testing.T) {
+	tests := []struct {
+		code int
+		want error
+	}{
+		{http.StatusAccepted, nil},
+		{http.StatusConflict, ErrAlreadyRegistered},
+		{http.StatusBadRequest, ErrReceiptRejected},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost || r.URL.Path != "/api/orders" {
+				t.Fatalf("unexpected request %s %s", r.Method, r.URL.Path)
+			}
+			w.WriteHeader(tt.code)
+		}))
+
+		err := NewClient(srv.URL, srv.Client()).RegisterOrder(context.Background(), Receipt{Order: "12345678903"})
+		srv.Close()
+		if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+			t.Fatalf("code %d: err=%v want %v", tt.code, err, tt.want)
+		}
+	}
+}
+
+// Чек, загруженный в gophermart, доходит до системы расчёта и считается там.
+func TestRegistrar_RegistersWithAccrualServer(t *testing.T) {
+	ctx := context.Background()
+	accrualStore := accrualserver.NewMemory()
+	_ = accrualStore.SaveRule(ctx, accrualserver.Rule{Match: "Bork", Reward: 10, RewardType: accrualserver.RewardPercent})
+	processor := accrualserver.NewProcessor(accrualStore, nil)
+	srv := httptest.NewServer(accrualserver.NewRouter(accrualStore, processor, nil, nil))
+	defer srv.Close()
+
+	store := repository.NewMemory()
+	u := &model.User{Login: "alice"}
+	_ = store.SaveUser(ctx, u)
+	u, _ = store.GetUserByLogin(ctx, "alice")
+	order := &model.Order{Number: "12345678903", Status: "NEW", UploadedAt: time.Now(), UserID: u.ID}
+	if err := store.SaveOrderReceipt(ctx, order, []model.Good{{Description: "Чайник Bork", Price: 700000}}); err != nil {
+		t.Fatalf("SaveOrderReceipt: %v", err)
+	}
+
+	client := NewClient(srv.URL, srv.Client())
+	r := NewRegistrar(store, client, nil, RegistrarConfig{})
+	n, err := r.RegisterBatch(ctx)
+	if err != nil || n != 1 {
+		t.Fatalf("RegisterBatch n=%d err=%v want 1 registered", n, err)
+	}
+	if _, err := processor.ProcessBatch(ctx); err != nil {
+		t.Fatalf("ProcessBatch: %v", err)
+	}
+
+	oi, err := client.GetOrder(ctx, "12345678903")
+	if err != nil {
+		t.Fatalf("GetOrder: %v", err)
+	}
+	if oi.Status != StatusProcessed || oi.Accrual == nil || *oi.Accrual != 700 {
+		t.Fatalf("order=%+v want PROCESSED with accrual 700", oi)
+	}
+	if again, _ := store.ClaimPendingRegistrations(ctx, 10); len(again) != 0 {
+		t.Fatalf("registered receipt is still pending: %+v", again)
+	}
+}
+
+type fakeRegistrations struct {
+	receipts   []model.Receipt
+	registered []int64
+	failed     map[int64]bool // номер -> final
+}
+
+func (f *fakeRegistrations) ClaimPendingRegistrations(ctx context.Context, limit int) ([]model.Receipt, error) {
+	out := f.receipts
+	f.receipts = nil
+	return out, nil
+}
+
+func (f *fakeRegistrations) MarkRegistered(ctx context.Context, number int64) error {
+	f.registered = append(f.registered, number)
+	return nil
+}
+
+func (f *fakeRegistrations) MarkRegistrationFailed(ctx context.Context, number int64, reason string, final bool) error {
+	f.failed[number] = final
+	return nil
+}
+
+type fakeReceiptClient map[string]error
+
+func (f fakeReceiptClient) RegisterOrder(ctx context.Context, receipt Receipt) error {
+	return f[receipt.Order]
+}
+
+func TestRegistrar_Outcomes(t *testing.T) {
+	repo := &fakeRegistrations{
+		receipts: []model.Receipt{
+			{Number: "1"},
+			{Number: "2"},
+			{Number: "3"},
+			{Number: "4", Attempts: 1},
+			{Number: "5", Attempts: 2},
+			{Number: "6"},
+		},
+		failed: make(map[int64]bool),
+	}
+	client := fakeReceiptClient{
+		"2": ErrAlreadyRegistered,
+		"3": ErrReceiptRejected,
+		"4": errors.New("connection reset"),
+		"5": errors.New("connection reset"),
+		"6": RateLimitError{RetryAfter: time.Second},
+	}
+
+	r := NewRegistrar(repo, client, nil, RegistrarConfig{MaxAttempts: 3})
+	n, err := r.RegisterBatch(context.Background())
+	if err != nil {
+		t.Fatalf("RegisterBatch: %v", err)
+	}
+	if n != 5 {
+		t.Fatalf("handled %d receipts, want 5", n)
+	}
+	if len(repo.registered) != 2 || repo.registered[0] != 1 || repo.registered[1] != 2 {
+		t.Fatalf("registered=%v want [1 2]: 409 means the accrual system already has the order", repo.registered)
+	}
+	want := map[int64]bool{3: true, 4: false, 5: true}
+	if len(repo.failed) != len(want) {
+		t.Fatalf("failed=%v want %v", repo.failed, want)
+	}
+	for n, final := range want {
+		if got, ok := repo.failed[n]; !ok || got != final {
+			t.Fatalf("receipt %d final=%v want %v", n, got, final)
+		}
+	}
+	// 429 не считается неудачей: чек вернётся в очередь после аренды
+	if _, ok := repo.failed[6]; ok {
+		t.Fatalf("rate limited receipt must not be marked failed")
+	}
+}
@@ -0,0 +1,69 @@
+package accrual
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Причины, по которым ответ системы расчёта не применяется, а заказ уходит в карантин.
+const (
+	AnomalyMalformedBody   = "malformed_body"
+	AnomalyUnknownStatus   = "unknown_status"
+	AnomalyOrderMismatch   = "order_mismatch"
+	AnomalyNegativeAccrual = "negative_accrual"
+	AnomalyAccrualTooLarge = "accrual_too_large"
+)
+
+// DefaultMaxAccrual — начисление больше этого считается ошибкой системы расчёта.
+const DefaultMaxAccrual = 1_000_000.0
+
+var ErrAnomalous = errors.New("anomalous accrual response")
+
+// AnomalyError — ответ 200, который нельзя применять. Payload — тело ответа как есть.
+type AnomalyError struct {
+	Reason  string
+	Detail  string
+	Payload string
+}
+
+func (e *AnomalyError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", ErrAnomalous, e.Reason, e.Detail)
+}
+
+func (e *AnomalyError) Is(target error) bool {
+	return target == ErrAnomalous
+}
+
+// validateOrderInfo проверяет ответ на запрос заказа number.
+func validateOrderInfo(number string, oi OrderInfo, maxAccrual float64) (reason, detail string) {
+	switch oi.Status {
+	case StatusRegistered, StatusProcessing, StatusInvalid, StatusProcessed:
+	default:
+		return AnomalyUnknownStatus, fmt.Sprintf("status %q", oi.Status)
+	}
+
+	if !sameOrderNumber(number, oi.Order) {
+		return AnomalyOrderMismatch, fmt.Sprintf("requested %s, got %q", number, oi.Order)
+	}
+
+	if oi.Accrual != nil {
+		acc := *oi.Accrual
+		switch {
+		case math.IsNaN(acc) || acc < 0:
+			return AnomalyNegativeAccrual, fmt.Sprintf("accrual %v", acc)
+		case math.IsInf(acc, 1) || acc > maxAccrual:
+			return AnomalyAccrualTooLarge, fmt.Sprintf("accrual %v exceeds %v", acc, maxAccrual)
+		}
+	}
+	return "", ""
+}
+
+// sameOrderNumber сравнивает номера как числа: "0042" и "42" — один заказ.
+func sameOrderNumber(requested, got string) bool {
+	a, err1 := strconv.ParseInt(strings.TrimSpace(requested), 10, 64)
+	b, err2 := strconv.ParseInt(strings.TrimSpace(got), 10, 64)
+	return err1 == nil && err2 == nil && a == b
+}
@@ -0,0 +1,69 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/g123udini/gofemart/internal/service"
+)
+
+func TestClient_GetOrder_RejectsAnomalies(t *testing.T) {
+	cases := []struct {
+		name   string
+		body   string
+		reason string
+	}{
+		{"unknown status", `{"order":"123","status":"REFUNDED"}`, AnomalyUnknownStatus},
+		{"other order", `{"order":"124","status":"PROCESSED","accrual":10}`, AnomalyOrderMismatch},
+		{"negative accrual", `{"order":"123","status":"PROCESSED","accrual":-0.01}`, AnomalyNegativeAccrual},
+		{"absurd accrual", `{"order":"123","status":"PROCESSED","accrual":1e9}`, AnomalyAccrualTooLarge},
+		{"malformed", `{"order":"123","status":`, AnomalyMalformedBody},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			defer srv.Close()
+
+			c := NewClient(srv.URL, srv.Client(), WithRetryPolicy(service.RetryPolicy{MaxAttempts: 1}))
+			_, err := c.GetOrder(context.Background(), "123")
+
+			var an *AnomalyError
+			if !errors.As(err, &an) || !errors.Is(err, ErrAnomalous) {
+				t.Fatalf("expected AnomalyError, got %v", err)
+			}
+			if an.Reason != tc.reason || an.Payload != tc.body {
+				t.Fatalf("reason=%q payload=%q", an.Reason, an.Payload)
+			}
+		})
+	}
+}
+
+func TestValidateOrderInfo_Accepts(t *testing.T) {
+	acc := 500.5
+	zero := 0.0
+	for _, oi := range []OrderInfo{
+		{Order: "123", Status: StatusProcessed, Accrual: &acc},
+		{Order: "0123", Status: StatusProcessed, Accrual: &zero},
+		{Order: "123", Status: StatusRegistered},
+		{Order: "123", Status: StatusInvalid},
+	} {
+		if reason, detail := validateOrderInfo("123", oi, DefaultMaxAccrual); reason != "" {
+			t.Fatalf("%+v rejected: %s %s", oi, reason, detail)
+		}
+	}
+	if reason, _ := validateOrderInfo("123", OrderInfo{Order: "123", Status: StatusProcessed, Accrual: &acc}, 100); reason != AnomalyAccrualTooLarge {
+		t.Fatalf("custom max accrual ignored, reason=%q", reason)
+	}
+}
+
+func TestBreaker_AnomalyIsNotFailure(t *testing.T) {
+	if isBreakerFailure(&AnomalyError{Reason: AnomalyUnknownStatus}) {
+		t.Fatalf("anomalous response must not open the breaker")
+	}
+}
@@ -2,18 +2,21 @@ package accrual
 
 import (
 	"context"
-	"log"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/g123udini/gofemart/internal/metrics"
+	"github.com/g123udini/gofemart/internal/repository"
+	"go.uber.org/zap"
 	"math"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-type Repo interface {
-	ListPendingOrders(ctx context.Context, limit int) ([]int64, error)
-	ApplyOrderProcessedOnce(ctx context.Context, number int64, accural int64) error
-	MarkOrderInvalidOnce(ctx context.Context, number int64) error
-	UpdateOrderStatusNonFinal(ctx context.Context, number int64, status string) error
-}
+// Repo — очередь незавершённых заказов, которую опрашивает воркер.
+type Repo = repository.PendingOrders
 
 type AccrualClient interface {
 	GetOrder(ctx context.Context, number string) (OrderInfo, error)
@@ -22,17 +25,86 @@ type AccrualClient interface {
 type AccrualWorker struct {
 	repo       Repo
 	client     AccrualClient
-	logger     *log.Logger
+	logger     *zap.SugaredLogger
 	pollEvery  time.Duration
 	batchLimit int
 	reqTimeout time.Duration
+
+	// concurrency горутин опрашивают систему расчёта параллельно,
+	// limiter ограничивает суммарную частоту их запросов.
+	concurrency int
+	limiter     *TokenBucket
+
+	// заказы старше maxAge или опрошенные maxAttempts раз уходят в dead letter;
+	// проверка идёт не чаще раза в deadLetterEvery
+	maxAge          time.Duration
+	maxAttempts     int
+	deadLetterEvery time.Duration
+	lastDeadLetter  time.Time
+
+	// maxAccrual — порог аномального начисления для уведомлений, см. Ingest
+	maxAccrual float64
+
+	lastLoop   atomic.Int64 // unix nano завершения последнего цикла опроса
+	pauseUntil atomic.Int64 // unix nano конца общей паузы после 429 или открытого breaker
+}
+
+// WorkerConfig задаёт параметры опроса. Нулевые поля оставляют значения по умолчанию.
+type WorkerConfig struct {
+	PollEvery      time.Duration
+	BatchLimit     int
+	RequestTimeout time.Duration
+	// Concurrency — число параллельных запросов к системе расчёта.
+	Concurrency int
+	// RateLimit — общий предел запросов в секунду на все горутины, 0 — без ограничения.
+	RateLimit float64
+	// RateBurst — сколько запросов можно сделать подряд без ожидания.
+	RateBurst int
+	// MaxAge и MaxAttempts — после этого возраста или числа опросов заказ перестаёт
+	// опрашиваться и уходит в dead letter. 0 — без ограничения.
+	MaxAge      time.Duration
+	MaxAttempts int
+	// MaxAccrual — начисление в уведомлении выше этого считается аномальным.
+	MaxAccrual float64
+}
+
+type WorkerOption func(w *AccrualWorker)
+
+func WithConfig(cfg WorkerConfig) WorkerOption {
+	return func(w *AccrualWorker) {
+		if cfg.PollEvery > 0 {
+			w.pollEvery = cfg.PollEvery
+		}
+		if cfg.BatchLimit > 0 {
+			w.batchLimit = cfg.BatchLimit
+		}
+		if cfg.RequestTimeout > 0 {
+			w.reqTimeout = cfg.RequestTimeout
+		}
+		if cfg.Concurrency > 0 {
+			w.concurrency = cfg.Concurrency
+		}
+		if cfg.RateLimit > 0 {
+			w.limiter = NewTokenBucket(cfg.RateLimit, cfg.RateBurst)
+		}
+		if cfg.MaxAge > 0 {
+			w.maxAge = cfg.MaxAge
+		}
+		if cfg.MaxAttempts > 0 {
+			w.maxAttempts = cfg.MaxAttempts
+		}
+		if cfg.MaxAccrual > 0 {
+			w.maxAccrual = cfg.MaxAccrual
+		}
+	}
 }
 
-func NewAccrualWorker(repo Repo, client AccrualClient, logger *log.Logger) *AccrualWorker {
+func NewAccrualWorker(repo Repo, client AccrualClient, logger *zap.SugaredLogger, opts ...WorkerOption) *AccrualWorker {
 	if logger == nil {
-		logger = log.Default()
+		logger = zap.S()
 	}
-	return &AccrualWorker{
+	logger = logger.With("component", "accrual_worker")
+	w := &AccrualWorker{
 		repo:   repo,
 		client: client,
 		logger: logger,
@@ -40,82 +112,289 @@ func NewAccrualWorker(repo Repo, client AccrualClient, logger *log.Logger) *Accr
 		pollEvery:  50 * time.Millisecond,
 		batchLimit: 100,
 		reqTimeout: 300 * time.Millisecond,
+
+		concurrency: 1,
+
+		deadLetterEvery: time.Minute,
+		maxAccrual:      DefaultMaxAccrual,
+	}
+	for _, opt := range opts {
+		opt(w)
 	}
+	w.lastLoop.Store(time.Now().UnixNano())
+	return w
+}
+
+// LastLoop возвращает время завершения последнего цикла опроса.
+func (w *AccrualWorker) LastLoop() time.Time {
+	return time.Unix(0, w.lastLoop.Load())
+}
+
+// Alive возвращает ошибку, если воркер не завершил ни одного цикла опроса
+// за maxMissed интервалов. В интервал включено время на обработку полного батча.
+func (w *AccrualWorker) Alive(maxMissed int) error {
+	if maxMissed <= 0 {
+		maxMissed = 1
+	}
+	rounds := (w.batchLimit + w.concurrency - 1) / w.concurrency
+	interval := w.pollEvery + time.Duration(rounds)*w.reqTimeout
+	since := time.Since(w.LastLoop())
+	if since > time.Duration(maxMissed)*interval {
+		return fmt.Errorf("accrual worker: no completed poll loop for %s", since.Round(time.Millisecond))
+	}
+	return nil
 }
 
 func (w *AccrualWorker) Run(ctx context.Context) {
 	ticker := time.NewTicker(w.pollEvery)
 	defer ticker.Stop()
 
-	var pauseUntil time.Time
-
 	for {
 		select {
 		case <-ctx.Done():
 			return
 
 		case <-ticker.C:
-			if !pauseUntil.IsZero() && time.Now().Before(pauseUntil) {
-				continue
+			w.deadLetter(ctx)
+			if !w.poll(ctx) {
+				return
 			}
+			w.lastLoop.Store(time.Now().UnixNano())
+		}
+	}
+}
 
-			numbers, err := w.repo.ListPendingOrders(ctx, w.batchLimit)
-			if err != nil {
-				w.logger.Printf("accrual worker: ListPendingOrders: %v", err)
-				continue
-			}
-			if len(numbers) == 0 {
-				continue
-			}
+// deadLetter снимает с опроса заказы, которые система расчёта так и не обработала.
+// Строка лога с event=order_dead_lettered — событие для разбора и алертов;
+// вернуть заказ в опрос можно через POST /admin/orders/{number}/requeue.
+func (w *AccrualWorker) deadLetter(ctx context.Context) {
+	if w.maxAge <= 0 && w.maxAttempts <= 0 {
+		return
+	}
+	if time.Since(w.lastDeadLetter) < w.deadLetterEvery {
+		return
+	}
+	w.lastDeadLetter = time.Now()
+
+	orders, err := w.repo.DeadLetterOrders(ctx, w.maxAge, w.maxAttempts, w.batchLimit)
+	if err != nil {
+		w.logger.Errorw("dead letter orders failed", "error", err)
+		return
+	}
+	for _, o := range orders {
+		reason := "max_age"
+		if w.maxAttempts > 0 && o.Attempts >= w.maxAttempts {
+			reason = "max_attempts"
+		}
+		metrics.DeadLetteredOrders.WithLabelValues(reason).Inc()
+		w.logger.Warnw("order moved to dead letter",
+			"event", "order_dead_lettered",
+			"order", o.Number,
+			"user_id", o.UserID,
+			"status", o.Status,
+			"reason", reason,
+			"attempts", o.Attempts,
+			"age", time.Since(o.UploadedAt).Round(time.Second).String(),
+		)
+	}
+}
+
+// pauseFor ставит общую паузу для всех горутин; более ранняя пауза не сокращает текущую.
+func (w *AccrualWorker) pauseFor(until time.Time) {
+	for {
+		cur := w.pauseUntil.Load()
+		if until.UnixNano() <= cur || w.pauseUntil.CompareAndSwap(cur, until.UnixNano()) {
+			return
+		}
+	}
+}
+
+func (w *AccrualWorker) paused() bool {
+	return time.Now().UnixNano() < w.pauseUntil.Load()
+}
+
+// poll выполняет один цикл опроса: раздаёт батч пулу из concurrency горутин.
+// Каждый номер попадает в канал ровно один раз, поэтому в пределах батча заказ
+// не обрабатывается дважды. После отмены ctx новые заказы не раздаются, а уже
+// начатые доводятся до конца. Возвращает false, если ctx отменён посреди батча.
+func (w *AccrualWorker) poll(ctx context.Context) bool {
+	if w.paused() {
+		return true
+	}
 
-			for _, num := range numbers {
-				reqCtx, cancel := context.WithTimeout(ctx, w.reqTimeout)
-				info, err := w.client.GetOrder(reqCtx, itoa64(num))
-				cancel()
-
-				if err != nil {
-					if err == ErrNotRegistered {
-						continue
-					}
-					if rl, ok := err.(RateLimitError); ok {
-						pauseUntil = time.Now().Add(rl.RetryAfter)
-						w.logger.Printf("accrual worker: 429 rate limited, pause %s", rl.RetryAfter)
-						break
-					}
-					w.logger.Printf("accrual worker: GetOrder %d: %v", num, err)
+	numbers, err := w.repo.ListPendingOrders(ctx, w.batchLimit)
+	if err != nil {
+		w.logger.Errorw("list pending orders failed", "error", err)
+		return true
+	}
+	if len(numbers) == 0 {
+		return true
+	}
+
+	// текущий заказ доводим до конца даже после отмены ctx,
+	// новые после отмены не берём
+	opCtx := context.WithoutCancel(ctx)
+
+	jobs := make(chan int64)
+	var wg sync.WaitGroup
+	for i := 0; i < min(w.concurrency, len(numbers)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for num := range jobs {
+				// пауза после 429 или открытого breaker останавливает все горутины
+				if w.paused() {
 					continue
 				}
-
-				switch info.Status {
-				case StatusProcessed:
-					acc := 0.0
-					if info.Accrual != nil {
-						acc = *info.Accrual
-					}
-					accural := moneyToCents(acc)
-
-					if err := w.repo.ApplyOrderProcessedOnce(ctx, num, accural); err != nil {
-						w.logger.Printf("accrual worker: ApplyOrderProcessedOnce %d: %v", num, err)
-					}
-
-				case StatusInvalid:
-					if err := w.repo.MarkOrderInvalidOnce(ctx, num); err != nil {
-						w.logger.Printf("accrual worker: MarkOrderInvalidOnce %d: %v", num, err)
-					}
-
-				case StatusRegistered, StatusProcessing:
-					if err := w.repo.UpdateOrderStatusNonFinal(ctx, num, string(info.Status)); err != nil {
-						w.logger.Printf("accrual worker: UpdateOrderStatusNonFinal %d: %v", num, err)
-					}
-
-				default:
-					if err := w.repo.UpdateOrderStatusNonFinal(ctx, num, string(info.Status)); err != nil {
-						w.logger.Printf("accrual worker: UpdateOrderStatusNonFinal %d: %v", num, err)
-					}
+				if err := w.limiter.Wait(ctx); err != nil {
+					continue
 				}
+				w.process(opCtx, num)
+			}
+		}()
+	}
+
+	stopped := w.dispatch(ctx, jobs, numbers)
+	close(jobs)
+	wg.Wait()
+
+	return !stopped
+}
+
+// dispatch отдаёт номера в jobs, пока нет паузы и ctx не отменён. Возвращает true при отмене.
+func (w *AccrualWorker) dispatch(ctx context.Context, jobs chan<- int64, numbers []int64) bool {
+	seen := make(map[int64]struct{}, len(numbers))
+	for _, num := range numbers {
+		if ctx.Err() != nil {
+			return true
+		}
+		if w.paused() {
+			return false
+		}
+		if _, dup := seen[num]; dup {
+			continue
+		}
+		seen[num] = struct{}{}
+
+		select {
+		case jobs <- num:
+		case <-ctx.Done():
+			return true
+		}
+	}
+	return false
+}
+
+// process опрашивает систему расчёта об одном заказе и сохраняет результат.
+func (w *AccrualWorker) process(ctx context.Context, num int64) {
+	reqCtx, cancel := context.WithTimeout(ctx, w.reqTimeout)
+	info, err := w.client.GetOrder(reqCtx, itoa64(num))
+	cancel()
+
+	if err != nil {
+		if errors.Is(err, ErrNotRegistered) {
+			// заказ ещё не дошёл до системы расчёта — спросим позже, а не на каждом тике
+			if err := w.repo.PostponeOrder(ctx, num); err != nil {
+				w.saveFailed("postpone order failed", num, err)
 			}
+			return
+		}
+		// breaker уже записал в лог своё открытие; до конца cool-down не трогаем ни accrual, ни базу
+		var bo *BreakerOpenError
+		if errors.As(err, &bo) {
+			w.pauseFor(bo.Until)
+			return
 		}
+		var an *AnomalyError
+		if errors.As(err, &an) {
+			w.quarantine(ctx, num, an)
+			return
+		}
+		var rl RateLimitError
+		if errors.As(err, &rl) && rl.Local {
+			// свой ограничитель: токена не дождаться за reqTimeout, ждём его без запроса
+			w.pauseFor(time.Now().Add(rl.RetryAfter))
+			return
+		}
+		if errors.As(err, &rl) {
+			w.pauseFor(time.Now().Add(rl.RetryAfter))
+			metrics.RateLimitPause.Observe(rl.RetryAfter.Seconds())
+			w.logger.Warnw("accrual rate limited, pausing", "retry_after", rl.RetryAfter)
+			return
+		}
+		w.logger.Errorw("get order from accrual failed", "order", num, "error", err)
+		return
+	}
+
+	if err := w.apply(ctx, num, info); err != nil {
+		w.saveFailed("save accrual result failed", num, err, "status", info.Status)
+	}
+}
+
+// apply сохраняет статус заказа из проверенного ответа системы расчёта:
+// и из опроса, и из уведомления, присланного через Ingest.
+func (w *AccrualWorker) apply(ctx context.Context, num int64, info OrderInfo) error {
+	switch info.Status {
+	case StatusProcessed:
+		acc := 0.0
+		if info.Accrual != nil {
+			acc = *info.Accrual
+		}
+		accural := moneyToCents(acc)
+
+		if err := w.repo.ApplyOrderProcessedOnce(ctx, num, accural); err != nil {
+			return fmt.Errorf("apply processed order (accrual %d): %w", accural, err)
+		}
+		metrics.AccruedCents.Add(float64(accural))
+		w.logger.Infow("order processed", "order", num, "accrual", accural)
+
+	case StatusInvalid:
+		if err := w.repo.MarkOrderInvalidOnce(ctx, num); err != nil {
+			return fmt.Errorf("mark order invalid: %w", err)
+		}
+
+	case StatusRegistered, StatusProcessing:
+		if err := w.repo.UpdateOrderStatusNonFinal(ctx, num, string(info.Status)); err != nil {
+			return fmt.Errorf("update order status: %w", err)
+		}
+
+	default:
+		// Client такие ответы отсекает сам, но в orders.status неизвестный статус не пишем ни при каком клиенте
+		payload, _ := json.Marshal(info)
+		w.quarantine(ctx, num, &AnomalyError{
+			Reason:  AnomalyUnknownStatus,
+			Detail:  fmt.Sprintf("status %q", info.Status),
+			Payload: string(payload),
+		})
+	}
+	return nil
+}
+
+// quarantine снимает заказ с опроса до решения администратора. Строка лога
+// с alert=accrual_anomaly — сигнал для алерта.
+func (w *AccrualWorker) quarantine(ctx context.Context, num int64, an *AnomalyError) {
+	if err := w.repo.QuarantineOrder(ctx, num, an.Reason, an.Detail, an.Payload); err != nil {
+		w.saveFailed("quarantine order failed", num, err, "reason", an.Reason)
+		return
+	}
+	metrics.QuarantinedOrders.WithLabelValues(an.Reason).Inc()
+	w.logger.Errorw("accrual anomaly, order quarantined",
+		"alert", "accrual_anomaly",
+		"order", num,
+		"reason", an.Reason,
+		"detail", an.Detail,
+		"payload", an.Payload,
+	)
+}
+
+// saveFailed пишет в лог ошибку сохранения результата. Потерянная аренда —
+// не сбой: заказ уже опрашивает другой экземпляр.
+func (w *AccrualWorker) saveFailed(msg string, num int64, err error, kv ...any) {
+	if errors.Is(err, repository.ErrLeaseLost) {
+		w.logger.Warnw("order lease lost, result dropped", "order", num)
+		return
 	}
+	w.logger.Errorw(msg, append([]any{"order", num, "error", err}, kv...)...)
 }
 
 func moneyToCents(v float64) int64 {
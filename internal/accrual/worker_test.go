@@ -3,11 +3,15 @@ package accrual
 import (
 	"context"
 	"errors"
-	"log"
-	"os"
+	"fmt"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/g123udini/gofemart/internal/model"
+	"github.com/g123udini/gofemart/internal/repository"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 type fakeRepo struct {
@@ -22,12 +26,26 @@ type fakeRepo struct {
 		num     int64
 		accural int64
 	}
-	invalid []int64
-	updated []struct {
+	invalid     []int64
+	postponed   []int64
+	quarantined []string // номер:причина
+	statusSet   []string // номер:статус из SetOrderStatusNonFinal
+	updated     []struct {
 		num    int64
 		status string
 	}
 
+	// ошибка, которую возвращает UpdateOrderStatusNonFinal
+	updateErr error
+
+	// GetOrderParked: parked[номер] — причина, missing — заказа нет
+	parked  map[int64]string
+	missing map[int64]bool
+
+	// DeadLetterOrders отдаёт deadLetter один раз и считает вызовы
+	deadLetter      []model.StuckOrder
+	deadLetterCalls int
+
 	// каналы для синхронизации тестов
 	onProcessed chan struct{}
 	onInvalid   chan struct{}
@@ -47,6 +65,10 @@ func (r *fakeRepo) ListPendingOrders(ctx context.Context, limit int) ([]int64, e
 	return b, nil
 }
 
+func (r *fakeRepo) CountPendingOrders(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
 func (r *fakeRepo) ApplyOrderProcessedOnce(ctx context.Context, number int64, accural int64) error {
 	r.mu.Lock()
 	r.processed = append(r.processed, struct {
@@ -87,6 +109,7 @@ func (r *fakeRepo) UpdateOrderStatusNonFinal(ctx context.Context, number int64,
 		status string
 	}{number, status})
 	ch := r.onUpdated
+	err := r.updateErr
 	r.mu.Unlock()
 
 	if ch != nil {
@@ -95,9 +118,48 @@ func (r *fakeRepo) UpdateOrderStatusNonFinal(ctx context.Context, number int64,
 		default:
 		}
 	}
+	return err
+}
+
+func (r *fakeRepo) SetOrderStatusNonFinal(ctx context.Context, number int64, status string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statusSet = append(r.statusSet, fmt.Sprintf("%d:%s", number, status))
+	return nil
+}
+
+func (r *fakeRepo) GetOrderParked(ctx context.Context, number int64) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.missing[number] {
+		return "", repository.ErrNotFound
+	}
+	return r.parked[number], nil
+}
+
+func (r *fakeRepo) PostponeOrder(ctx context.Context, number int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.postponed = append(r.postponed, number)
 	return nil
 }
 
+func (r *fakeRepo) QuarantineOrder(ctx context.Context, number int64, reason, detail, payload string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.quarantined = append(r.quarantined, fmt.Sprintf("%d:%s", number, reason))
+	return nil
+}
+
+func (r *fakeRepo) DeadLetterOrders(ctx context.Context, maxAge time.Duration, maxAttempts, limit int) ([]model.StuckOrder, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deadLetterCalls++
+	out := r.deadLetter
+	r.deadLetter = nil
+	return out, nil
+}
+
 type fakeClient struct {
 	mu sync.Mutex
 
@@ -142,7 +204,7 @@ func TestAccrualWorker_Processed_UpdatesOrderAndBalanceOnce(t *testing.T) {
 		}},
 	}
 
-	w := NewAccrualWorker(repo, client, log.New(os.Stdout, "", 0))
+	w := NewAccrualWorker(repo, client, zap.NewNop().Sugar())
 	w.pollEvery = 5 * time.Millisecond
 	w.reqTimeout = 50 * time.Millisecond
 	w.batchLimit = 10
@@ -184,7 +246,7 @@ func TestAccrualWorker_Invalid_MarksInvalid(t *testing.T) {
 		}},
 	}
 
-	w := NewAccrualWorker(repo, client, log.New(os.Stdout, "", 0))
+	w := NewAccrualWorker(repo, client, zap.NewNop().Sugar())
 	w.pollEvery = 5 * time.Millisecond
 	w.reqTimeout = 50 * time.Millisecond
 
@@ -219,7 +281,7 @@ func TestAccrualWorker_Processing_UpdatesStatus(t *testing.T) {
 		}},
 	}
 
-	w := NewAccrualWorker(repo, client, log.New(os.Stdout, "", 0))
+	w := NewAccrualWorker(repo, client, zap.NewNop().Sugar())
 	w.pollEvery = 5 * time.Millisecond
 	w.reqTimeout = 50 * time.Millisecond
 
@@ -246,7 +308,7 @@ func TestAccrualWorker_Processing_UpdatesStatus(t *testing.T) {
 	}
 }
 
-func TestAccrualWorker_NotRegistered_JustSkips(t *testing.T) {
+func TestAccrualWorker_NotRegistered_Postpones(t *testing.T) {
 	repo := &fakeRepo{
 		pendingBatches: [][]int64{{404}},
 	}
@@ -256,7 +318,7 @@ func TestAccrualWorker_NotRegistered_JustSkips(t *testing.T) {
 		}},
 	}
 
-	w := NewAccrualWorker(repo, client, log.New(os.Stdout, "", 0))
+	w := NewAccrualWorker(repo, client, zap.NewNop().Sugar())
 	w.pollEvery = 5 * time.Millisecond
 	w.reqTimeout = 50 * time.Millisecond
 
@@ -272,6 +334,9 @@ func TestAccrualWorker_NotRegistered_JustSkips(t *testing.T) {
 		t.Fatalf("expected no repo updates, got processed=%d invalid=%d updated=%d",
 			len(repo.processed), len(repo.invalid), len(repo.updated))
 	}
+	if len(repo.postponed) != 1 || repo.postponed[0] != 404 {
+		t.Fatalf("expected order to be postponed once, got %v", repo.postponed)
+	}
 }
 
 func TestAccrualWorker_RateLimit_Pauses(t *testing.T) {
@@ -287,7 +352,7 @@ func TestAccrualWorker_RateLimit_Pauses(t *testing.T) {
 		},
 	}
 
-	w := NewAccrualWorker(repo, client, log.New(os.Stdout, "", 0))
+	w := NewAccrualWorker(repo, client, zap.NewNop().Sugar())
 	w.pollEvery = 5 * time.Millisecond
 	w.reqTimeout = 50 * time.Millisecond
 
@@ -319,3 +384,364 @@ func TestItoa64(t *testing.T) {
 		t.Fatalf("got %q want %q", got, "123")
 	}
 }
+
+type blockingClient struct {
+	mu      sync.Mutex
+	calls   []string
+	started chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingClient) GetOrder(ctx context.Context, number string) (OrderInfo, error) {
+	c.mu.Lock()
+	c.calls = append(c.calls, number)
+	c.mu.Unlock()
+
+	c.started <- struct{}{}
+	<-c.release
+	return OrderInfo{Order: number, Status: StatusInvalid}, nil
+}
+
+func TestAccrualWorker_StopsAfterCurrentOrderOnCancel(t *testing.T) {
+	repo := &fakeRepo{
+		pendingBatches: [][]int64{{1, 2, 3}},
+	}
+	client := &blockingClient{
+		started: make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+
+	w := NewAccrualWorker(repo, client, zap.NewNop().Sugar())
+	w.pollEvery = 5 * time.Millisecond
+	w.reqTimeout = time.Second
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-client.started:
+	case <-time.After(200 * time.Millisecond):
+		t.Fatalf("timeout waiting first GetOrder")
+	}
+
+	cancel()
+	close(client.release)
+
+	select {
+	case <-done:
+	case <-time.After(200 * time.Millisecond):
+		t.Fatalf("worker did not stop after cancel")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if len(repo.invalid) != 1 || repo.invalid[0] != 1 {
+		t.Fatalf("expected current order to be finished, got invalid=%+v", repo.invalid)
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if len(client.calls) != 1 {
+		t.Fatalf("expected no new orders after cancel, got calls=%+v", client.calls)
+	}
+}
+
+func TestAccrualWorker_Alive(t *testing.T) {
+	repo := &fakeRepo{}
+	w := NewAccrualWorker(repo, &fakeClient{}, zap.NewNop().Sugar())
+	w.pollEvery = 5 * time.Millisecond
+	w.reqTimeout = time.Millisecond
+	w.batchLimit = 1
+
+	if err := w.Alive(2); err != nil {
+		t.Fatalf("fresh worker must be alive: %v", err)
+	}
+
+	w.lastLoop.Store(time.Now().Add(-time.Second).UnixNano())
+	if err := w.Alive(2); err == nil {
+		t.Fatalf("expected error for stale worker")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	w.Run(ctx)
+
+	if err := w.Alive(2); err != nil {
+		t.Fatalf("worker must be alive after running: %v", err)
+	}
+}
+
+// countingClient отвечает PROCESSED на любой номер и считает вызовы по номерам.
+type countingClient struct {
+	mu       sync.Mutex
+	calls    map[string]int
+	inFlight int
+	maxPar   int
+	delay    time.Duration
+	err      error
+}
+
+func (c *countingClient) GetOrder(ctx context.Context, number string) (OrderInfo, error) {
+	c.mu.Lock()
+	if c.calls == nil {
+		c.calls = make(map[string]int)
+	}
+	c.calls[number]++
+	c.inFlight++
+	c.maxPar = max(c.maxPar, c.inFlight)
+	err := c.err
+	c.mu.Unlock()
+
+	time.Sleep(c.delay)
+
+	c.mu.Lock()
+	c.inFlight--
+	c.mu.Unlock()
+
+	if err != nil {
+		return OrderInfo{}, err
+	}
+	acc := 1.0
+	return OrderInfo{Order: number, Status: StatusProcessed, Accrual: &acc}, nil
+}
+
+func TestAccrualWorker_Concurrent_ProcessesEachOrderOnce(t *testing.T) {
+	batch := make([]int64, 0, 110)
+	for i := int64(1); i <= 100; i++ {
+		batch = append(batch, i)
+	}
+	// дубли в выдаче не должны приводить к повторному опросу
+	batch = append(batch, 1, 2, 3, 50, 100)
+
+	repo := &fakeRepo{pendingBatches: [][]int64{batch}}
+	client := &countingClient{delay: time.Millisecond}
+
+	w := NewAccrualWorker(repo, client, zap.NewNop().Sugar(), WithConfig(WorkerConfig{Concurrency: 8}))
+	if !w.poll(context.Background()) {
+		t.Fatalf("poll must not report cancellation")
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if len(client.calls) != 100 {
+		t.Fatalf("expected 100 distinct orders polled, got %d", len(client.calls))
+	}
+	for num, n := range client.calls {
+		if n != 1 {
+			t.Fatalf("order %s polled %d times", num, n)
+		}
+	}
+	if client.maxPar < 2 || client.maxPar > 8 {
+		t.Fatalf("expected between 2 and 8 parallel requests, got %d", client.maxPar)
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	seen := make(map[int64]bool)
+	for _, p := range repo.processed {
+		if seen[p.num] {
+			t.Fatalf("order %d applied twice", p.num)
+		}
+		seen[p.num] = true
+	}
+	if len(seen) != 100 {
+		t.Fatalf("expected 100 processed orders, got %d", len(seen))
+	}
+}
+
+func TestAccrualWorker_Concurrent_RateLimitPausesAllPollers(t *testing.T) {
+	batch := make([]int64, 0, 50)
+	for i := int64(1); i <= 50; i++ {
+		batch = append(batch, i)
+	}
+	repo := &fakeRepo{pendingBatches: [][]int64{batch, batch}}
+	client := &countingClient{
+		delay: 5 * time.Millisecond,
+		err:   RateLimitError{RetryAfter: time.Minute},
+	}
+
+	w := NewAccrualWorker(repo, client, zap.NewNop().Sugar(), WithConfig(WorkerConfig{Concurrency: 4}))
+	w.pollEvery = 5 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Millisecond)
+	defer cancel()
+	w.Run(ctx)
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	total := 0
+	for _, n := range client.calls {
+		total += n
+	}
+	// после первого 429 новые запросы не уходят; успеть могли только уже начатые
+	if total > 4 {
+		t.Fatalf("expected at most one request per poller before pause, got %d", total)
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if repo.listCalls != 1 {
+		t.Fatalf("expected no new batches during pause, got %d list calls", repo.listCalls)
+	}
+}
+
+func TestAccrualWorker_Concurrent_StopsOnCancel(t *testing.T) {
+	repo := &fakeRepo{pendingBatches: [][]int64{{1, 2, 3, 4, 5, 6, 7, 8}}}
+	client := &blockingClient{
+		started: make(chan struct{}, 8),
+		release: make(chan struct{}),
+	}
+
+	w := NewAccrualWorker(repo, client, zap.NewNop().Sugar(), WithConfig(WorkerConfig{Concurrency: 2}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() { done <- w.poll(ctx) }()
+
+	for range 2 {
+		select {
+		case <-client.started:
+		case <-time.After(200 * time.Millisecond):
+			t.Fatalf("timeout waiting for pollers to start")
+		}
+	}
+
+	cancel()
+	close(client.release)
+
+	select {
+	case ok := <-done:
+		if ok {
+			t.Fatalf("poll must report cancellation")
+		}
+	case <-time.After(200 * time.Millisecond):
+		t.Fatalf("poll did not return after cancel")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	// начатые заказы доведены до конца, новые не взяты
+	if len(repo.invalid) != 2 {
+		t.Fatalf("expected 2 in-flight orders finished, got invalid=%+v", repo.invalid)
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if len(client.calls) != 2 {
+		t.Fatalf("expected no new orders after cancel, got calls=%+v", client.calls)
+	}
+}
+
+func TestAccrualWorker_Concurrent_SharesRateLimit(t *testing.T) {
+	batch := make([]int64, 0, 10)
+	for i := int64(1); i <= 10; i++ {
+		batch = append(batch, i)
+	}
+	repo := &fakeRepo{pendingBatches: [][]int64{batch}}
+	client := &countingClient{}
+
+	// 200 rps на всех: 10 запросов с burst 1 занимают не меньше 45мс, сколько бы ни было горутин
+	w := NewAccrualWorker(repo, client, zap.NewNop().Sugar(), WithConfig(WorkerConfig{
+		Concurrency: 8,
+		RateLimit:   200,
+		RateBurst:   1,
+	}))
+
+	start := time.Now()
+	w.poll(context.Background())
+	if el := time.Since(start); el < 40*time.Millisecond {
+		t.Fatalf("rate limit not applied: 10 requests took %s", el)
+	}
+}
+
+func TestAccrualWorker_LeaseLost_IsNotAnError(t *testing.T) {
+	repo := &fakeRepo{
+		pendingBatches: [][]int64{{1}},
+		updateErr:      fmt.Errorf("update: %w", repository.ErrLeaseLost),
+	}
+	client := &fakeClient{results: []getOrderResult{{info: OrderInfo{Order: "1", Status: StatusProcessing}}}}
+
+	core, logs := observer.New(zap.InfoLevel)
+	w := NewAccrualWorker(repo, client, zap.New(core).Sugar())
+	w.poll(context.Background())
+
+	if n := logs.FilterMessage("order lease lost, result dropped").Len(); n != 1 {
+		t.Fatalf("expected lease loss warning, got %d", n)
+	}
+	if n := logs.FilterLevelExact(zap.ErrorLevel).Len(); n != 0 {
+		t.Fatalf("lease loss must not be logged as error, got %d errors", n)
+	}
+}
+
+func TestAccrualWorker_Anomaly_Quarantines(t *testing.T) {
+	repo := &fakeRepo{pendingBatches: [][]int64{{1, 2}}}
+	client := &fakeClient{results: []getOrderResult{
+		{err: &AnomalyError{Reason: AnomalyNegativeAccrual, Detail: "accrual -5", Payload: `{"order":"1","status":"PROCESSED","accrual":-5}`}},
+		// клиент без проверок: неизвестный статус всё равно не должен попасть в orders.status
+		{info: OrderInfo{Order: "2", Status: "REFUNDED"}},
+	}}
+
+	core, logs := observer.New(zap.InfoLevel)
+	w := NewAccrualWorker(repo, client, zap.New(core).Sugar())
+	w.poll(context.Background())
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if len(repo.quarantined) != 2 || repo.quarantined[0] != "1:negative_accrual" || repo.quarantined[1] != "2:unknown_status" {
+		t.Fatalf("quarantined=%v", repo.quarantined)
+	}
+	if len(repo.updated) != 0 || len(repo.processed) != 0 {
+		t.Fatalf("anomalous responses must not be applied: updated=%v processed=%v", repo.updated, repo.processed)
+	}
+
+	alerts := logs.FilterField(zap.String("alert", "accrual_anomaly"))
+	if alerts.Len() != 2 {
+		t.Fatalf("expected 2 alert log lines, got %d", alerts.Len())
+	}
+	if p := alerts.All()[0].ContextMap()["payload"]; p != `{"order":"1","status":"PROCESSED","accrual":-5}` {
+		t.Fatalf("alert must carry the raw payload, got %v", p)
+	}
+}
+
+func TestAccrualWorker_DeadLetter_EmitsEvent(t *testing.T) {
+	repo := &fakeRepo{deadLetter: []model.StuckOrder{
+		{Number: "1", Status: "PROCESSING", Attempts: 50, UploadedAt: time.Now().Add(-time.Hour)},
+		{Number: "2", Status: "NEW", Attempts: 2, UploadedAt: time.Now().Add(-100 * time.Hour)},
+	}}
+
+	core, logs := observer.New(zap.InfoLevel)
+	w := NewAccrualWorker(repo, &fakeClient{}, zap.New(core).Sugar(),
+		WithConfig(WorkerConfig{MaxAge: 72 * time.Hour, MaxAttempts: 50}))
+
+	w.deadLetter(context.Background())
+	// повторная проверка раньше deadLetterEvery в базу не ходит
+	w.deadLetter(context.Background())
+
+	if repo.deadLetterCalls != 1 {
+		t.Fatalf("DeadLetterOrders called %d times, want 1", repo.deadLetterCalls)
+	}
+	events := logs.FilterField(zap.String("event", "order_dead_lettered")).All()
+	if len(events) != 2 {
+		t.Fatalf("expected 2 dead letter events, got %d", len(events))
+	}
+	if r := events[0].ContextMap()["reason"]; r != "max_attempts" {
+		t.Fatalf("order 1 reason=%v want max_attempts", r)
+	}
+	if r := events[1].ContextMap()["reason"]; r != "max_age" {
+		t.Fatalf("order 2 reason=%v want max_age", r)
+	}
+}
+
+func TestAccrualWorker_DeadLetter_DisabledByDefault(t *testing.T) {
+	repo := &fakeRepo{}
+	w := NewAccrualWorker(repo, &fakeClient{}, zap.NewNop().Sugar())
+	w.deadLetter(context.Background())
+	if repo.deadLetterCalls != 0 {
+		t.Fatalf("worker without limits must not dead-letter orders")
+	}
+}
@@ -0,0 +1,121 @@
+package accrualserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/g123udini/gofemart/internal/service"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// maxBody — чек с сотнями позиций укладывается в мегабайт.
+const maxBody = 1 << 20
+
+type api struct {
+	store     Store
+	processor *Processor
+}
+
+// NewRouter собирает HTTP API системы расчёта:
+//
+//	POST /api/orders          — регистрация чека: 202, 400, 409;
+//	POST /api/goods           — новое правило вознаграждения: 200, 400, 409;
+//	GET  /api/orders/{number} — результат расчёта: 200, 204.
+//
+// limiter ограничивает все запросы к /api; nil — без ограничения.
+func NewRouter(store Store, processor *Processor, limiter *RateLimiter, logger *zap.SugaredLogger) http.Handler {
+	if logger == nil {
+		logger = zap.S()
+	}
+	a := &api{store: store, processor: processor}
+
+	r := chi.NewRouter()
+	r.Use(service.RequestLogger(logger))
+	r.Route("/api", func(r chi.Router) {
+		r.Use(limiter.Middleware)
+		r.Post("/orders", a.registerOrder)
+		r.Post("/goods", a.saveRule)
+		r.Get("/orders/{number}", a.getOrder)
+	})
+	return r
+}
+
+func (a *api) registerOrder(w http.ResponseWriter, r *http.Request) {
+	var receipt Receipt
+	if !decode(w, r, &receipt) {
+		return
+	}
+	if err := receipt.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := a.store.RegisterOrder(r.Context(), receipt)
+	if errors.Is(err, ErrExists) {
+		http.Error(w, "order already registered", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if a.processor != nil {
+		a.processor.Notify()
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (a *api) saveRule(w http.ResponseWriter, r *http.Request) {
+	var rule Rule
+	if !decode(w, r, &rule) {
+		return
+	}
+	if err := rule.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := a.store.SaveRule(r.Context(), rule)
+	if errors.Is(err, ErrExists) {
+		http.Error(w, "match already registered", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *api) getOrder(w http.ResponseWriter, r *http.Request) {
+	o, err := a.store.GetOrder(r.Context(), chi.URLParam(r, "number"))
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if o == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(o)
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func internalError(w http.ResponseWriter, r *http.Request, err error) {
+	service.LoggerFrom(r.Context()).Errorw("request failed", "error", err)
+	http.Error(w, "internal server error", http.StatusInternalServerError)
+}
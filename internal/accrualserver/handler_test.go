@@ -0,0 +1,96 @@
+package accrualserver_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/g123udini/gofemart/internal/accrual"
+	"github.com/g123udini/gofemart/internal/accrualserver"
+	"go.uber.org/zap"
+)
+
+func post(t *testing.T, url, body string) int {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST %s: %v", url, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestAPI_RegisterAndCalculate(t *testing.T) {
+	store := accrualserver.NewMemory()
+	proc := accrualserver.NewProcessor(store, zap.NewNop().Sugar())
+	srv := httptest.NewServer(accrualserver.NewRouter(store, proc, nil, zap.NewNop().Sugar()))
+	defer srv.Close()
+
+	if code := post(t, srv.URL+"/api/goods", `{"match":"Bork","reward":10,"reward_type":"%"}`); code != http.StatusOK {
+		t.Fatalf("save rule: %d", code)
+	}
+	if code := post(t, srv.URL+"/api/goods", `{"match":"Bork","reward":5,"reward_type":"pt"}`); code != http.StatusConflict {
+		t.Fatalf("duplicate rule: %d want 409", code)
+	}
+	if code := post(t, srv.URL+"/api/goods", `{"match":"Bork","reward":5,"reward_type":"rub"}`); code != http.StatusBadRequest {
+		t.Fatalf("bad rule: %d want 400", code)
+	}
+
+	receipt := `{"order":"79927398713","goods":[{"description":"Чайник Bork","price":7000}]}`
+	if code := post(t, srv.URL+"/api/orders", receipt); code != http.StatusAccepted {
+		t.Fatalf("register: %d want 202", code)
+	}
+	if code := post(t, srv.URL+"/api/orders", receipt); code != http.StatusConflict {
+		t.Fatalf("duplicate order: %d want 409", code)
+	}
+	if code := post(t, srv.URL+"/api/orders", `{"order":"123","goods":[]}`); code != http.StatusBadRequest {
+		t.Fatalf("bad receipt: %d want 400", code)
+	}
+	if code := post(t, srv.URL+"/api/orders", `{"order":"4561261212345467","goods":[{"description":"Хлеб","price":50}]}`); code != http.StatusAccepted {
+		t.Fatalf("register second: %d", code)
+	}
+
+	// клиент gophermart понимает ответы сервиса
+	c := accrual.NewClient(srv.URL, srv.Client())
+	ctx := context.Background()
+	if info, err := c.GetOrder(ctx, "79927398713"); err != nil || info.Status != accrual.StatusRegistered {
+		t.Fatalf("before calculation: %+v, %v", info, err)
+	}
+	if _, err := c.GetOrder(ctx, "12345678903"); !errors.Is(err, accrual.ErrNotRegistered) {
+		t.Fatalf("unknown order: want 204, got %v", err)
+	}
+
+	if n, err := proc.ProcessBatch(ctx); err != nil || n != 2 {
+		t.Fatalf("ProcessBatch=%d, %v", n, err)
+	}
+
+	info, err := c.GetOrder(ctx, "79927398713")
+	if err != nil || info.Status != accrual.StatusProcessed || info.Accrual == nil || *info.Accrual != 700 {
+		t.Fatalf("processed order: %+v, %v", info, err)
+	}
+	info, err = c.GetOrder(ctx, "4561261212345467")
+	if err != nil || info.Status != accrual.StatusInvalid || info.Accrual != nil {
+		t.Fatalf("order without rewards: %+v, %v", info, err)
+	}
+}
+
+func TestAPI_RateLimited(t *testing.T) {
+	store := accrualserver.NewMemory()
+	srv := httptest.NewServer(accrualserver.NewRouter(store, nil, accrualserver.NewRateLimiter(1), zap.NewNop().Sugar()))
+	defer srv.Close()
+
+	c := accrual.NewClient(srv.URL, srv.Client())
+	_, _ = c.GetOrder(context.Background(), "79927398713")
+
+	resp, err := http.Get(srv.URL + "/api/orders/79927398713")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("second request: status=%d retry-after=%q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+}
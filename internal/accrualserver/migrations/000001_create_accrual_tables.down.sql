@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS accrual_order_goods;
+DROP TABLE IF EXISTS accrual_orders;
+DROP TABLE IF EXISTS accrual_rules;
@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS accrual_rules
+(
+    match       TEXT PRIMARY KEY,
+    reward      NUMERIC(14, 4) NOT NULL,
+    reward_type VARCHAR(2)     NOT NULL CHECK (reward_type IN ('%', 'pt')),
+    created_at  TIMESTAMP      NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS accrual_orders
+(
+    number     TEXT PRIMARY KEY,
+    status     VARCHAR(16)    NOT NULL DEFAULT 'REGISTERED',
+    accrual    NUMERIC(14, 2),
+    created_at TIMESTAMP      NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP      NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- очередь расчёта: только незавершённые заказы
+CREATE INDEX IF NOT EXISTS accrual_orders_queue_idx
+    ON accrual_orders (created_at)
+    WHERE status IN ('REGISTERED', 'PROCESSING');
+
+CREATE TABLE IF NOT EXISTS accrual_order_goods
+(
+    id           BIGSERIAL PRIMARY KEY,
+    order_number TEXT           NOT NULL REFERENCES accrual_orders (number) ON DELETE CASCADE,
+    description  TEXT           NOT NULL,
+    price        NUMERIC(14, 2) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS accrual_order_goods_order_idx ON accrual_order_goods (order_number);
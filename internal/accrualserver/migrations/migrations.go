@@ -0,0 +1,8 @@
+// Package migrations встраивает SQL-миграции системы расчёта в бинарник cmd/accrual.
+// Версии хранятся в отдельной таблице, поэтому база может быть общей с gophermart.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,123 @@
+// Package accrualserver — собственная система расчёта начислений: принимает чеки
+// (POST /api/orders), правила вознаграждения (POST /api/goods) и асинхронно
+// считает баллы, которые gophermart забирает через GET /api/orders/{number}.
+package accrualserver
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/g123udini/gofemart/internal/service"
+)
+
+// Статусы расчёта заказа, как их видит gophermart.
+const (
+	StatusRegistered = "REGISTERED"
+	StatusProcessing = "PROCESSING"
+	StatusProcessed  = "PROCESSED"
+	StatusInvalid    = "INVALID"
+)
+
+// Типы вознаграждения: процент от цены товара или фиксированные баллы за товар.
+const (
+	RewardPercent = "%"
+	RewardPoints  = "pt"
+)
+
+// ErrExists — заказ или правило с таким ключом уже есть.
+var ErrExists = errors.New("already exists")
+
+// Good — позиция чека.
+type Good struct {
+	Description string  `json:"description"`
+	Price       float64 `json:"price"`
+}
+
+// Receipt — чек, который регистрирует магазин.
+type Receipt struct {
+	Order string `json:"order"`
+	Goods []Good `json:"goods"`
+}
+
+// Rule — механика вознаграждения за товары, в названии которых встречается Match.
+type Rule struct {
+	Match      string  `json:"match"`
+	Reward     float64 `json:"reward"`
+	RewardType string  `json:"reward_type"`
+}
+
+// Order — результат расчёта. Accrual есть только у PROCESSED.
+type Order struct {
+	Order   string   `json:"order"`
+	Status  string   `json:"status"`
+	Accrual *float64 `json:"accrual,omitempty"`
+}
+
+func (r Receipt) validate() error {
+	if strings.Trim(r.Order, "0123456789") != "" || !service.ValidLun(r.Order) {
+		return errors.New("invalid order number")
+	}
+	if len(r.Goods) == 0 {
+		return errors.New("receipt has no goods")
+	}
+	for _, g := range r.Goods {
+		if strings.TrimSpace(g.Description) == "" {
+			return errors.New("good without description")
+		}
+		if g.Price < 0 || math.IsNaN(g.Price) || math.IsInf(g.Price, 0) {
+			return errors.New("invalid good price")
+		}
+	}
+	return nil
+}
+
+func (r Rule) validate() error {
+	if strings.TrimSpace(r.Match) == "" {
+		return errors.New("empty match")
+	}
+	if r.Reward <= 0 || math.IsInf(r.Reward, 0) {
+		return errors.New("reward must be positive")
+	}
+	switch r.RewardType {
+	case RewardPercent:
+		if r.Reward > 100 {
+			return errors.New("percent reward must not exceed 100")
+		}
+	case RewardPoints:
+	default:
+		return errors.New(`reward_type must be "%" or "pt"`)
+	}
+	return nil
+}
+
+// Calculate считает начисление по чеку. Товар получает вознаграждение по одному
+// правилу: самому конкретному (с самым длинным Match) из подходящих, сравнение без
+// учёта регистра. ok=false — ни один товар не подошёл, заказ не принимается к расчёту.
+func Calculate(goods []Good, rules []Rule) (accrual float64, ok bool) {
+	rules = append([]Rule(nil), rules...)
+	sort.Slice(rules, func(i, j int) bool {
+		if len(rules[i].Match) != len(rules[j].Match) {
+			return len(rules[i].Match) > len(rules[j].Match)
+		}
+		return rules[i].Match < rules[j].Match
+	})
+
+	for _, g := range goods {
+		desc := strings.ToLower(g.Description)
+		for _, r := range rules {
+			if !strings.Contains(desc, strings.ToLower(r.Match)) {
+				continue
+			}
+			ok = true
+			if r.RewardType == RewardPercent {
+				accrual += g.Price * r.Reward / 100
+			} else {
+				accrual += r.Reward
+			}
+			break
+		}
+	}
+	return math.Round(accrual*100) / 100, ok
+}
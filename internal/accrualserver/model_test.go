@@ -0,0 +1,69 @@
+package accrualserver
+
+import "testing"
+
+func TestCalculate(t *testing.T) {
+	rules := []Rule{
+		{Match: "Bork", Reward: 10, RewardType: RewardPercent},
+		{Match: "Bork V7", Reward: 500, RewardType: RewardPoints},
+		{Match: "LG", Reward: 15.5, RewardType: RewardPercent},
+	}
+
+	cases := []struct {
+		name   string
+		goods  []Good
+		want   float64
+		wantOK bool
+	}{
+		{"percent", []Good{{"Чайник Bork", 7000}}, 700, true},
+		{"most specific match wins", []Good{{"Пылесос bork v7", 50000}}, 500, true},
+		{"sum over goods", []Good{{"Чайник Bork", 1000}, {"Телевизор LG", 10000.01}, {"Хлеб", 50}}, 1650, true},
+		{"rounded to cents", []Good{{"LG провод", 3.33}}, 0.52, true},
+		{"nothing matches", []Good{{"Хлеб", 50}}, 0, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := Calculate(tc.goods, rules)
+			if got != tc.want || ok != tc.wantOK {
+				t.Fatalf("Calculate=%v,%v want %v,%v", got, ok, tc.want, tc.wantOK)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	good := []Good{{"Чайник", 10}}
+	receipts := []struct {
+		r  Receipt
+		ok bool
+	}{
+		{Receipt{"79927398713", good}, true},
+		{Receipt{"79927398710", good}, false},
+		{Receipt{"7992 7398 713", good}, false},
+		{Receipt{"79927398713", nil}, false},
+		{Receipt{"79927398713", []Good{{"", 10}}}, false},
+		{Receipt{"79927398713", []Good{{"Чайник", -1}}}, false},
+	}
+	for _, tc := range receipts {
+		if err := tc.r.validate(); (err == nil) != tc.ok {
+			t.Fatalf("receipt %+v: err=%v, want ok=%v", tc.r, err, tc.ok)
+		}
+	}
+
+	rules := []struct {
+		r  Rule
+		ok bool
+	}{
+		{Rule{"Bork", 10, RewardPercent}, true},
+		{Rule{"Bork", 10, RewardPoints}, true},
+		{Rule{"Bork", 101, RewardPercent}, false},
+		{Rule{"Bork", 0, RewardPoints}, false},
+		{Rule{"Bork", 10, "rub"}, false},
+		{Rule{" ", 10, RewardPoints}, false},
+	}
+	for _, tc := range rules {
+		if err := tc.r.validate(); (err == nil) != tc.ok {
+			t.Fatalf("rule %+v: err=%v, want ok=%v", tc.r, err, tc.ok)
+		}
+	}
+}
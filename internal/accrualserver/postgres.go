@@ -0,0 +1,218 @@
+package accrualserver
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/accrualserver/migrations"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// migrationsTable — своя таблица версий, чтобы не путаться с миграциями gophermart в общей базе.
+const migrationsTable = "accrual_schema_migrations"
+
+// Postgres — хранилище системы расчёта в Postgres.
+type Postgres struct {
+	DB *sql.DB
+}
+
+func OpenPostgres(dsn string) (*Postgres, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &Postgres{DB: db}, nil
+}
+
+// Migrate применяет встроенные миграции.
+func (p *Postgres) Migrate() error {
+	src, err := iofs.New(migrations.FS, ".")
+	if err != nil {
+		return fmt.Errorf("migrate source: %w", err)
+	}
+	driver, err := postgres.WithInstance(p.DB, &postgres.Config{MigrationsTable: migrationsTable})
+	if err != nil {
+		return fmt.Errorf("postgres driver: %w", err)
+	}
+	m, err := migrate.NewWithInstance("iofs", src, "postgres", driver)
+	if err != nil {
+		return fmt.Errorf("migrate init: %w", err)
+	}
+	// m.Close закрыл бы и p.DB, поэтому не закрываем
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+	return nil
+}
+
+func (p *Postgres) SaveRule(ctx context.Context, rule Rule) error {
+	res, err := p.DB.ExecContext(ctx,
+		`INSERT INTO accrual_rules (match, reward, reward_type) VALUES ($1, $2, $3)
+		 ON CONFLICT (match) DO NOTHING`,
+		rule.Match, rule.Reward, rule.RewardType,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrExists
+	}
+	return nil
+}
+
+func (p *Postgres) Rules(ctx context.Context) ([]Rule, error) {
+	rows, err := p.DB.QueryContext(ctx, `SELECT match, reward::float8, reward_type FROM accrual_rules`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make([]Rule, 0)
+	for rows.Next() {
+		var r Rule
+		if err := rows.Scan(&r.Match, &r.Reward, &r.RewardType); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+func (p *Postgres) RegisterOrder(ctx context.Context, receipt Receipt) error {
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO accrual_orders (number) VALUES ($1) ON CONFLICT (number) DO NOTHING`,
+		receipt.Order,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrExists
+	}
+
+	for _, g := range receipt.Goods {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO accrual_order_goods (order_number, description, price) VALUES ($1, $2, $3)`,
+			receipt.Order, g.Description, g.Price,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (p *Postgres) GetOrder(ctx context.Context, number string) (*Order, error) {
+	var (
+		o       Order
+		accrual sql.NullFloat64
+	)
+	err := p.DB.QueryRowContext(ctx,
+		`SELECT number, status, accrual::float8 FROM accrual_orders WHERE number = $1`,
+		number,
+	).Scan(&o.Order, &o.Status, &accrual)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if accrual.Valid {
+		o.Accrual = &accrual.Float64
+	}
+	return &o, nil
+}
+
+// Заказы берутся с SKIP LOCKED: несколько экземпляров считают непересекающиеся пачки.
+const claimOrders = `WITH batch AS (
+	SELECT number
+	  FROM accrual_orders
+	 WHERE status = 'REGISTERED'
+	    OR (status = 'PROCESSING' AND updated_at < now() - $2::float8 * interval '1 millisecond')
+	 ORDER BY created_at ASC
+	 LIMIT $1
+	   FOR UPDATE SKIP LOCKED
+)
+UPDATE accrual_orders o
+   SET status = 'PROCESSING',
+       updated_at = now()
+  FROM batch
+ WHERE o.number = batch.number
+RETURNING o.number`
+
+func (p *Postgres) ClaimOrders(ctx context.Context, limit int, stale time.Duration) ([]Receipt, error) {
+	rows, err := p.DB.QueryContext(ctx, claimOrders, limit, float64(stale.Milliseconds()))
+	if err != nil {
+		return nil, err
+	}
+	var numbers []string
+	for rows.Next() {
+		var n string
+		if err := rows.Scan(&n); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		numbers = append(numbers, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(numbers) == 0 {
+		return nil, nil
+	}
+
+	rows, err = p.DB.QueryContext(ctx,
+		`SELECT order_number, description, price::float8
+		   FROM accrual_order_goods
+		  WHERE order_number = ANY($1)
+		  ORDER BY id`,
+		numbers,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	goods := make(map[string][]Good, len(numbers))
+	for rows.Next() {
+		var (
+			n string
+			g Good
+		)
+		if err := rows.Scan(&n, &g.Description, &g.Price); err != nil {
+			return nil, err
+		}
+		goods[n] = append(goods[n], g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	out := make([]Receipt, 0, len(numbers))
+	for _, n := range numbers {
+		out = append(out, Receipt{Order: n, Goods: goods[n]})
+	}
+	return out, nil
+}
+
+func (p *Postgres) FinishOrder(ctx context.Context, number, status string, accrual *float64) error {
+	_, err := p.DB.ExecContext(ctx,
+		`UPDATE accrual_orders
+		    SET status = $2, accrual = $3, updated_at = now()
+		  WHERE number = $1 AND status = 'PROCESSING'`,
+		number, status, accrual,
+	)
+	return err
+}
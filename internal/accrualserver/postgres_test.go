@@ -0,0 +1,73 @@
+package accrualserver
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPostgres_Flow(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URI")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+
+	pg, err := OpenPostgres(dsn)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { _ = pg.DB.Close() })
+	if err := pg.Migrate(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if _, err := pg.DB.Exec(`TRUNCATE accrual_order_goods, accrual_orders, accrual_rules`); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := pg.SaveRule(ctx, Rule{Match: "Bork", Reward: 10, RewardType: RewardPercent}); err != nil {
+		t.Fatalf("SaveRule: %v", err)
+	}
+	if err := pg.SaveRule(ctx, Rule{Match: "Bork", Reward: 1, RewardType: RewardPoints}); !errors.Is(err, ErrExists) {
+		t.Fatalf("duplicate rule: %v", err)
+	}
+
+	receipt := Receipt{Order: "79927398713", Goods: []Good{{"Чайник Bork", 7000}, {"Хлеб", 50.5}}}
+	if err := pg.RegisterOrder(ctx, receipt); err != nil {
+		t.Fatalf("RegisterOrder: %v", err)
+	}
+	if err := pg.RegisterOrder(ctx, receipt); !errors.Is(err, ErrExists) {
+		t.Fatalf("duplicate order: %v", err)
+	}
+
+	claimed, err := pg.ClaimOrders(ctx, 10, time.Minute)
+	if err != nil || len(claimed) != 1 || len(claimed[0].Goods) != 2 || claimed[0].Goods[1].Price != 50.5 {
+		t.Fatalf("ClaimOrders=%+v, %v", claimed, err)
+	}
+
+	if again, _ := pg.ClaimOrders(ctx, 10, time.Minute); len(again) != 0 {
+		t.Fatalf("order in PROCESSING must not be claimed twice, got %+v", again)
+	}
+	// расчёт брошен упавшим процессом — заказ берут снова
+	if again, _ := pg.ClaimOrders(ctx, 10, 0); len(again) != 1 {
+		t.Fatalf("stale order must be reclaimed, got %+v", again)
+	}
+
+	rules, err := pg.Rules(ctx)
+	if err != nil {
+		t.Fatalf("Rules: %v", err)
+	}
+	accrual, _ := Calculate(claimed[0].Goods, rules)
+	if err := pg.FinishOrder(ctx, "79927398713", StatusProcessed, &accrual); err != nil {
+		t.Fatalf("FinishOrder: %v", err)
+	}
+	o, err := pg.GetOrder(ctx, "79927398713")
+	if err != nil || o.Status != StatusProcessed || o.Accrual == nil || *o.Accrual != 700 {
+		t.Fatalf("GetOrder=%+v, %v", o, err)
+	}
+	if o, _ := pg.GetOrder(ctx, "12345678903"); o != nil {
+		t.Fatalf("unknown order must be nil, got %+v", o)
+	}
+}
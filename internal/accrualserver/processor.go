@@ -0,0 +1,97 @@
+package accrualserver
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Processor асинхронно считает зарегистрированные заказы. Регистрация будит его
+// через Notify; без неё он проверяет очередь раз в Every.
+type Processor struct {
+	store  Store
+	logger *zap.SugaredLogger
+
+	// Every — период проверки очереди, Batch — заказов за проход,
+	// Stale — сколько ждать заказ, брошенный в PROCESSING упавшим процессом.
+	Every time.Duration
+	Batch int
+	Stale time.Duration
+
+	wake chan struct{}
+}
+
+func NewProcessor(store Store, logger *zap.SugaredLogger) *Processor {
+	if logger == nil {
+		logger = zap.S()
+	}
+	return &Processor{
+		store:  store,
+		logger: logger.With("component", "accrual_processor"),
+		Every:  time.Second,
+		Batch:  100,
+		Stale:  time.Minute,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Notify будит Processor, не дожидаясь очередной проверки.
+func (p *Processor) Notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *Processor) Run(ctx context.Context) {
+	t := time.NewTicker(p.Every)
+	defer t.Stop()
+
+	for {
+		// очередь разбираем до конца, потом ждём новых заказов
+		for {
+			n, err := p.ProcessBatch(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					p.logger.Errorw("process orders failed", "error", err)
+				}
+				break
+			}
+			if n < p.Batch {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-p.wake:
+		}
+	}
+}
+
+// ProcessBatch считает одну пачку заказов и возвращает её размер.
+func (p *Processor) ProcessBatch(ctx context.Context) (int, error) {
+	receipts, err := p.store.ClaimOrders(ctx, p.Batch, p.Stale)
+	if err != nil || len(receipts) == 0 {
+		return 0, err
+	}
+	rules, err := p.store.Rules(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, r := range receipts {
+		status, accrual := StatusInvalid, (*float64)(nil)
+		if v, ok := Calculate(r.Goods, rules); ok {
+			status, accrual = StatusProcessed, &v
+		}
+		if err := p.store.FinishOrder(ctx, r.Order, status, accrual); err != nil {
+			return 0, err
+		}
+		p.logger.Debugw("order calculated", "order", r.Order, "status", status, "accrual", accrual)
+	}
+	return len(receipts), nil
+}
@@ -0,0 +1,61 @@
+package accrualserver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestProcessor_RunWakesOnNotify(t *testing.T) {
+	store := NewMemory()
+	ctx := context.Background()
+	_ = store.SaveRule(ctx, Rule{Match: "Чайник", Reward: 100, RewardType: RewardPoints})
+
+	p := NewProcessor(store, zap.NewNop().Sugar())
+	p.Every = time.Hour
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.Run(runCtx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	_ = store.RegisterOrder(ctx, Receipt{Order: "79927398713", Goods: []Good{{"Чайник", 1}}})
+	p.Notify()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if o, _ := store.GetOrder(ctx, "79927398713"); o.Status == StatusProcessed {
+			if *o.Accrual != 100 {
+				t.Fatalf("accrual=%v want 100", *o.Accrual)
+			}
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("order was not calculated after Notify")
+}
+
+func TestMemory_ClaimRetakesStaleOrders(t *testing.T) {
+	store := NewMemory()
+	ctx := context.Background()
+	_ = store.RegisterOrder(ctx, Receipt{Order: "79927398713", Goods: []Good{{"Чайник", 1}}})
+
+	if got, _ := store.ClaimOrders(ctx, 10, time.Hour); len(got) != 1 {
+		t.Fatalf("first claim=%v", got)
+	}
+	if got, _ := store.ClaimOrders(ctx, 10, time.Hour); len(got) != 0 {
+		t.Fatalf("order in PROCESSING must not be claimed twice, got %v", got)
+	}
+	// процесс упал посреди расчёта — заказ берут снова
+	if got, _ := store.ClaimOrders(ctx, 10, 0); len(got) != 1 {
+		t.Fatalf("stale order must be reclaimed, got %v", got)
+	}
+}
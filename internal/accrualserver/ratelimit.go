@@ -0,0 +1,80 @@
+package accrualserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimiter — предел запросов в минуту на клиента (по IP) с фиксированным окном,
+// как описано в спецификации: сверх предела — 429 с Retry-After до конца окна.
+type RateLimiter struct {
+	perMinute int
+	now       func() time.Time
+
+	mu     sync.Mutex
+	window time.Time
+	counts map[string]int
+}
+
+// NewRateLimiter возвращает nil для perMinute <= 0: nil-ограничитель пропускает всё.
+func NewRateLimiter(perMinute int) *RateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &RateLimiter{
+		perMinute: perMinute,
+		now:       time.Now,
+		counts:    make(map[string]int),
+	}
+}
+
+// Allow засчитывает запрос клиента key. Если предел исчерпан, возвращает паузу до нового окна.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if window := now.Truncate(time.Minute); !window.Equal(l.window) {
+		l.window = window
+		clear(l.counts)
+	}
+	if l.counts[key] >= l.perMinute {
+		return false, l.window.Add(time.Minute).Sub(now)
+	}
+	l.counts[key]++
+	return true, 0
+}
+
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := l.Allow(clientIP(r))
+		if !ok {
+			// Retry-After в целых секундах, округляем вверх
+			secs := int((wait + time.Second - 1) / time.Second)
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprintf(w, "No more than %d requests per minute allowed", l.perMinute)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,54 @@
+package accrualserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_FixedWindow(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 10, 0, time.UTC)
+	l := NewRateLimiter(2)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d must pass", i)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok || wait != 50*time.Second {
+		t.Fatalf("third request: ok=%v wait=%s, want rejected until the next minute", ok, wait)
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Fatalf("other clients have their own limit")
+	}
+
+	now = now.Add(50 * time.Second)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatalf("new window must reset the limit")
+	}
+}
+
+func TestRateLimiter_Middleware(t *testing.T) {
+	l := NewRateLimiter(1)
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/orders/1", nil))
+		if rr.Code != want {
+			t.Fatalf("request %d: status=%d want %d", i, rr.Code, want)
+		}
+		if want == http.StatusTooManyRequests {
+			if rr.Header().Get("Retry-After") == "" || rr.Body.String() != "No more than 1 requests per minute allowed" {
+				t.Fatalf("429 shape: headers=%v body=%q", rr.Header(), rr.Body.String())
+			}
+		}
+	}
+
+	if NewRateLimiter(0) != nil {
+		t.Fatalf("zero limit must disable the limiter")
+	}
+}
@@ -0,0 +1,157 @@
+package accrualserver
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Store — хранилище системы расчёта. Его реализуют Postgres и Memory.
+type Store interface {
+	// SaveRule возвращает ErrExists, если правило с таким Match уже есть.
+	SaveRule(ctx context.Context, rule Rule) error
+	Rules(ctx context.Context) ([]Rule, error)
+	// RegisterOrder сохраняет чек в статусе REGISTERED; повторный номер — ErrExists.
+	RegisterOrder(ctx context.Context, receipt Receipt) error
+	// GetOrder возвращает nil, nil, если заказ не зарегистрирован.
+	GetOrder(ctx context.Context, number string) (*Order, error)
+	// ClaimOrders переводит до limit заказов в PROCESSING и возвращает их чеки.
+	// Заказ, застрявший в PROCESSING дольше stale (расчёт упал вместе с процессом), берётся снова.
+	ClaimOrders(ctx context.Context, limit int, stale time.Duration) ([]Receipt, error)
+	// FinishOrder записывает итог расчёта заказа, взятого ClaimOrders.
+	FinishOrder(ctx context.Context, number, status string, accrual *float64) error
+}
+
+var (
+	_ Store = (*Postgres)(nil)
+	_ Store = (*Memory)(nil)
+)
+
+// Memory — хранилище в памяти для локального запуска и тестов.
+type Memory struct {
+	mu     sync.Mutex
+	rules  map[string]Rule
+	orders map[string]*memOrder
+	seq    int
+}
+
+type memOrder struct {
+	Order
+	goods   []Good
+	seq     int
+	updated time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		rules:  make(map[string]Rule),
+		orders: make(map[string]*memOrder),
+	}
+}
+
+func (m *Memory) SaveRule(ctx context.Context, rule Rule) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rules[rule.Match]; ok {
+		return ErrExists
+	}
+	m.rules[rule.Match] = rule
+	return nil
+}
+
+func (m *Memory) Rules(ctx context.Context) ([]Rule, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rules := make([]Rule, 0, len(m.rules))
+	for _, r := range m.rules {
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func (m *Memory) RegisterOrder(ctx context.Context, receipt Receipt) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.orders[receipt.Order]; ok {
+		return ErrExists
+	}
+	m.seq++
+	m.orders[receipt.Order] = &memOrder{
+		Order:   Order{Order: receipt.Order, Status: StatusRegistered},
+		goods:   append([]Good(nil), receipt.Goods...),
+		seq:     m.seq,
+		updated: time.Now(),
+	}
+	return nil
+}
+
+func (m *Memory) GetOrder(ctx context.Context, number string) (*Order, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o, ok := m.orders[number]
+	if !ok {
+		return nil, nil
+	}
+	out := o.Order
+	return &out, nil
+}
+
+func (m *Memory) ClaimOrders(ctx context.Context, limit int, stale time.Duration) ([]Receipt, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	due := make([]*memOrder, 0)
+	for _, o := range m.orders {
+		if o.Status == StatusRegistered || (o.Status == StatusProcessing && now.Sub(o.updated) > stale) {
+			due = append(due, o)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].seq < due[j].seq })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	out := make([]Receipt, 0, len(due))
+	for _, o := range due {
+		o.Status = StatusProcessing
+		o.updated = now
+		out = append(out, Receipt{Order: o.Order.Order, Goods: append([]Good(nil), o.goods...)})
+	}
+	return out, nil
+}
+
+func (m *Memory) FinishOrder(ctx context.Context, number, status string, accrual *float64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if o, ok := m.orders[number]; ok && o.Status == StatusProcessing {
+		o.Status = status
+		o.Accrual = accrual
+		o.updated = time.Now()
+	}
+	return nil
+}
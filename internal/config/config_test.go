@@ -0,0 +1,165 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func load(t *testing.T, args []string, env map[string]string) (*Config, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return Load(fs, args, func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	})
+}
+
+func writeFile(t *testing.T, name, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, err := load(t, nil, nil)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	want := Default()
+	if cfg.Server != want.Server || cfg.DB != want.DB || cfg.Worker != want.Worker || cfg.Retry != want.Retry {
+		t.Fatalf("cfg=%+v want defaults %+v", cfg, want)
+	}
+}
+
+func TestLoad_LayerPrecedence(t *testing.T) {
+	path := writeFile(t, "gophermart.yaml", `
+server:
+  address: ":7000"
+  read_timeout: 7s
+worker:
+  batch_limit: 10
+  poll_every: 1s
+retry:
+  attempts: 5
+`)
+
+	cfg, err := load(t,
+		[]string{"-config", path, "-worker-batch-limit", "30"},
+		map[string]string{
+			"WORKER_BATCH_LIMIT": "20",
+			"WORKER_POLL_EVERY":  "2s",
+		},
+	)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	if cfg.Server.Address != ":7000" || cfg.Server.ReadTimeout != 7*time.Second {
+		t.Fatalf("file values not applied: %+v", cfg.Server)
+	}
+	if cfg.Retry.Attempts != 5 {
+		t.Fatalf("Retry.Attempts=%d want=5", cfg.Retry.Attempts)
+	}
+	if cfg.Worker.PollEvery != 2*time.Second {
+		t.Fatalf("env must override file: PollEvery=%s", cfg.Worker.PollEvery)
+	}
+	if cfg.Worker.BatchLimit != 30 {
+		t.Fatalf("flag must override env: BatchLimit=%d", cfg.Worker.BatchLimit)
+	}
+	if cfg.Server.WriteTimeout != Default().Server.WriteTimeout {
+		t.Fatalf("untouched field lost its default: %s", cfg.Server.WriteTimeout)
+	}
+}
+
+func TestLoad_TOMLFromEnvPath(t *testing.T) {
+	path := writeFile(t, "gophermart.toml", `
+[db]
+dsn = "postgres://u:p@db:5432/app"
+max_open_conns = 4
+max_idle_conns = 2
+
+[session]
+ttl = "1h"
+secure_cookie = true
+`)
+
+	cfg, err := load(t, nil, map[string]string{"CONFIG": path})
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.DB.DSN != "postgres://u:p@db:5432/app" || cfg.DB.MaxOpenConns != 4 || cfg.DB.MaxIdleConns != 2 {
+		t.Fatalf("db=%+v", cfg.DB)
+	}
+	if cfg.Session.TTL != time.Hour || !cfg.Session.SecureCookie {
+		t.Fatalf("session=%+v", cfg.Session)
+	}
+	if cfg.File != path {
+		t.Fatalf("File=%q want=%q", cfg.File, path)
+	}
+}
+
+func TestLoad_UnknownFileKeys(t *testing.T) {
+	for name, body := range map[string]string{
+		"c.yaml": "worker:\n  bach_limit: 1\n",
+		"c.toml": "[worker]\nbach_limit = 1\n",
+	} {
+		_, err := load(t, []string{"-config", writeFile(t, name, body)}, nil)
+		if err == nil || !strings.Contains(err.Error(), "bach_limit") {
+			t.Fatalf("%s: expected unknown key error, got %v", name, err)
+		}
+	}
+}
+
+func TestLoad_BadEnvValue(t *testing.T) {
+	_, err := load(t, nil, map[string]string{"WORKER_POLL_EVERY": "soon"})
+	if err == nil || !strings.Contains(err.Error(), "WORKER_POLL_EVERY") {
+		t.Fatalf("expected env error, got %v", err)
+	}
+}
+
+func TestValidate_ReportsAllErrors(t *testing.T) {
+	cfg := Default()
+	cfg.DB.DSN = "not a dsn"
+	cfg.Worker.BatchLimit = 0
+	cfg.Log.Level = "loud"
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	for _, field := range []string{"db.dsn", "worker.batch_limit", "log.level"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Fatalf("error %q does not mention %s", err, field)
+		}
+	}
+}
+
+func TestPrint_RedactsSecrets(t *testing.T) {
+	cfg := Default()
+	cfg.DB.DSN = "postgres://app:s3cret@db:5432/app?sslmode=disable"
+
+	var buf bytes.Buffer
+	if err := cfg.Print(&buf); err != nil {
+		t.Fatalf("print: %v", err)
+	}
+	out := buf.String()
+	if strings.Contains(out, "s3cret") {
+		t.Fatalf("password leaked:\n%s", out)
+	}
+	if !strings.Contains(out, "postgres://app:xxxxx@db:5432/app") || !strings.Contains(out, "batch_limit: 100") {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+	if cfg.DB.DSN != "postgres://app:s3cret@db:5432/app?sslmode=disable" {
+		t.Fatalf("Print must not modify the config")
+	}
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/model"
+	"github.com/g123udini/gofemart/internal/repository"
+	"github.com/g123udini/gofemart/internal/service"
+	"github.com/go-chi/chi/v5"
+)
+
+// AdminStorage — то, что нужно служебному API от repository.Repo.
+type AdminStorage interface {
+	ListStuckOrders(ctx context.Context, olderThan time.Duration, limit int) ([]model.StuckOrder, error)
+	RequeueOrder(ctx context.Context, number int64, dryRun bool) (*model.Order, error)
+}
+
+// Admin — служебный API для разбора зависших заказов. Доступ по токену
+// в заголовке Authorization: Bearer <token>.
+type Admin struct {
+	repo  AdminStorage
+	token string
+}
+
+func NewAdmin(repo AdminStorage, token string) *Admin {
+	return &Admin{repo: repo, token: token}
+}
+
+// Auth пропускает запрос только с правильным токеном. Пустой токен закрывает API целиком.
+func (a *Admin) Auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || a.token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(a.token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// StuckOrders — GET /admin/orders/stuck?older_than=1h&limit=100: незавершённые
+// заказы старше older_than и все заказы в dead letter.
+func (a *Admin) StuckOrders(w http.ResponseWriter, r *http.Request) {
+	olderThan := time.Hour
+	if v := r.URL.Query().Get("older_than"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			http.Error(w, "invalid older_than", http.StatusBadRequest)
+			return
+		}
+		olderThan = d
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	orders, err := a.repo.ListStuckOrders(r.Context(), olderThan, limit)
+	if err != nil {
+		adminError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(orders)
+}
+
+// RequeueOrder — POST /admin/orders/{number}/requeue[?dry_run=true]: возвращает
+// заказ, в том числе из dead letter, в опрос со статусом NEW.
+func (a *Admin) RequeueOrder(w http.ResponseWriter, r *http.Request) {
+	number, err := strconv.ParseInt(chi.URLParam(r, "number"), 10, 64)
+	if err != nil || number <= 0 {
+		http.Error(w, "invalid order number", http.StatusBadRequest)
+		return
+	}
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+
+	o, err := a.repo.RequeueOrder(r.Context(), number, dryRun)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		http.Error(w, "order not found", http.StatusNotFound)
+		return
+	case errors.Is(err, repository.ErrOrderProcessed):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		adminError(w, r, err)
+		return
+	}
+
+	service.LoggerFrom(r.Context()).Infow("order requeued by admin",
+		"event", "order_requeued", "order", o.Number, "dry_run", dryRun)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"number":  o.Number,
+		"status":  o.Status,
+		"user_id": o.UserID,
+		"dry_run": dryRun,
+	})
+}
+
+func adminError(w http.ResponseWriter, r *http.Request, err error) {
+	service.LoggerFrom(r.Context()).Errorw("admin request failed", "error", err)
+	http.Error(w, "internal error", http.StatusInternalServerError)
+}
@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/model"
+	"github.com/g123udini/gofemart/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+type fakeAdminStorage struct {
+	olderThan  time.Duration
+	requeued   []int64
+	requeueErr error
+}
+
+func (f *fakeAdminStorage) ListStuckOrders(_ context.Context, olderThan time.Duration, _ int) ([]model.StuckOrder, error) {
+	f.olderThan = olderThan
+	return []model.StuckOrder{{Number: "79927398713", Status: "PROCESSING", UserID: 1, Attempts: 40, Parked: "DEAD_LETTER"}}, nil
+}
+
+func (f *fakeAdminStorage) RequeueOrder(_ context.Context, number int64, _ bool) (*model.Order, error) {
+	if f.requeueErr != nil {
+		return nil, f.requeueErr
+	}
+	f.requeued = append(f.requeued, number)
+	return &model.Order{Number: "79927398713", Status: "NEW", UserID: 1}, nil
+}
+
+func adminRouter(a *Admin) http.Handler {
+	r := chi.NewRouter()
+	r.With(a.Auth).Get("/admin/orders/stuck", a.StuckOrders)
+	r.With(a.Auth).Post("/admin/orders/{number}/requeue", a.RequeueOrder)
+	return r
+}
+
+func TestAdmin_Auth(t *testing.T) {
+	cases := []struct {
+		name, token, header string
+		want                int
+	}{
+		{"no header", "secret", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "Bearer nope", http.StatusUnauthorized},
+		{"api disabled", "", "Bearer ", http.StatusUnauthorized},
+		{"ok", "secret", "Bearer secret", http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin/orders/stuck", nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			rr := httptest.NewRecorder()
+			adminRouter(NewAdmin(&fakeAdminStorage{}, tc.token)).ServeHTTP(rr, req)
+			if rr.Code != tc.want {
+				t.Fatalf("status=%d want %d", rr.Code, tc.want)
+			}
+		})
+	}
+}
+
+func TestAdmin_StuckOrders(t *testing.T) {
+	repo := &fakeAdminStorage{}
+	req := httptest.NewRequest(http.MethodGet, "/admin/orders/stuck?older_than=30m", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr := httptest.NewRecorder()
+	adminRouter(NewAdmin(repo, "secret")).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	if repo.olderThan != 30*time.Minute {
+		t.Fatalf("olderThan=%s want 30m", repo.olderThan)
+	}
+	var got []model.StuckOrder
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(got) != 1 || got[0].Parked != "DEAD_LETTER" || got[0].Attempts != 40 {
+		t.Fatalf("unexpected body %+v", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/orders/stuck?older_than=soon", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr = httptest.NewRecorder()
+	adminRouter(NewAdmin(repo, "secret")).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("bad older_than: status=%d want 400", rr.Code)
+	}
+}
+
+func TestAdmin_RequeueOrder(t *testing.T) {
+	cases := []struct {
+		name, number string
+		err          error
+		want         int
+	}{
+		{"ok", "79927398713", nil, http.StatusOK},
+		{"bad number", "abc", nil, http.StatusBadRequest},
+		{"not found", "1", repository.ErrNotFound, http.StatusNotFound},
+		{"processed", "1", repository.ErrOrderProcessed, http.StatusConflict},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &fakeAdminStorage{requeueErr: tc.err}
+			req := httptest.NewRequest(http.MethodPost, "/admin/orders/"+tc.number+"/requeue", nil)
+			req.Header.Set("Authorization", "Bearer secret")
+			rr := httptest.NewRecorder()
+			adminRouter(NewAdmin(repo, "secret")).ServeHTTP(rr, req)
+
+			if rr.Code != tc.want {
+				t.Fatalf("status=%d want %d body=%s", rr.Code, tc.want, rr.Body.String())
+			}
+			if tc.want == http.StatusOK && (len(repo.requeued) != 1 || repo.requeued[0] != 79927398713) {
+				t.Fatalf("requeued=%v", repo.requeued)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/accrual"
+	"github.com/g123udini/gofemart/internal/metrics"
+	"github.com/g123udini/gofemart/internal/repository"
+	"github.com/g123udini/gofemart/internal/service"
+)
+
+// Заголовки подписанного уведомления системы расчёта.
+const (
+	HeaderCallbackTimestamp = "X-Accrual-Timestamp" // unix-время в секундах
+	HeaderCallbackNonce     = "X-Accrual-Nonce"
+	HeaderCallbackSignature = "X-Accrual-Signature" // hex HMAC-SHA256, см. SignCallback
+)
+
+// maxCallbackBody — уведомление о заказе укладывается в сотню байт.
+const maxCallbackBody = 64 << 10
+
+// AccrualIngester применяет уведомление; его реализует accrual.AccrualWorker.
+type AccrualIngester interface {
+	Ingest(ctx context.Context, info accrual.OrderInfo) error
+}
+
+// AccrualCallback — POST /internal/accrual/callback: система расчёта сама сообщает
+// статус заказа вместо того, чтобы ждать опроса. Тело — как ответ GET /api/orders/{number}.
+//
+// Запрос подписывается общим секретом, а от повтора защищён временем и nonce:
+// запрос старше MaxSkew отклоняется, nonce принимается один раз за это окно.
+// После ответа 503 или 500 уведомление не применено, и тот же запрос можно повторить.
+// Виденные nonce хранятся в памяти экземпляра; повтор на другой экземпляр
+// безвреден, потому что финальные статусы применяются один раз.
+type AccrualCallback struct {
+	ingester AccrualIngester
+	secret   []byte
+	maxSkew  time.Duration
+	now      func() time.Time
+
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	lastSweep time.Time
+}
+
+func NewAccrualCallback(ingester AccrualIngester, secret string, maxSkew time.Duration) *AccrualCallback {
+	if maxSkew <= 0 {
+		maxSkew = 5 * time.Minute
+	}
+	return &AccrualCallback{
+		ingester: ingester,
+		secret:   []byte(secret),
+		maxSkew:  maxSkew,
+		now:      time.Now,
+		nonces:   make(map[string]time.Time),
+	}
+}
+
+// SignCallback считает подпись уведомления: HMAC-SHA256 от "timestamp\nnonce\nbody".
+func SignCallback(secret []byte, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(nonce))
+	mac.Write([]byte{'\n'})
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (c *AccrualCallback) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log := service.LoggerFrom(r.Context())
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCallbackBody))
+	if err != nil {
+		callbackError(w, "cannot read body", http.StatusBadRequest, "rejected")
+		return
+	}
+
+	if msg := c.verify(r.Header, body); msg != "" {
+		log.Warnw("accrual callback rejected", "event", "accrual_callback_rejected", "reason", msg)
+		callbackError(w, "unauthorized", http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	// nonce занимается до применения, чтобы параллельный повтор не прошёл дважды,
+	// и освобождается, если отправителя просят повторить тот же запрос
+	nonce := r.Header.Get(HeaderCallbackNonce)
+	if !c.useNonce(nonce) {
+		log.Warnw("accrual callback replayed", "event", "accrual_callback_replayed")
+		callbackError(w, "notification already received", http.StatusConflict, "replayed")
+		return
+	}
+
+	var info accrual.OrderInfo
+	if err := json.Unmarshal(body, &info); err != nil {
+		callbackError(w, "bad request", http.StatusBadRequest, "rejected")
+		return
+	}
+
+	err = c.ingester.Ingest(r.Context(), info)
+	switch {
+	case err == nil:
+	case errors.Is(err, accrual.ErrBadNotification):
+		callbackError(w, err.Error(), http.StatusBadRequest, "rejected")
+		return
+	case errors.Is(err, accrual.ErrUnknownOrder):
+		callbackError(w, err.Error(), http.StatusNotFound, "unknown")
+		return
+	case errors.Is(err, accrual.ErrOrderParked):
+		// карантин и dead letter разбирает администратор, уведомление их не обходит
+		callbackError(w, err.Error(), http.StatusConflict, "parked")
+		return
+	case errors.Is(err, accrual.ErrAnomalous):
+		// заказ уже в карантине, повторять уведомление бесполезно
+		callbackError(w, err.Error(), http.StatusUnprocessableEntity, "anomaly")
+		return
+	case errors.Is(err, repository.ErrLeaseLost):
+		// заказ сейчас опрашивает другой экземпляр; отправитель может повторить позже
+		c.releaseNonce(nonce)
+		w.Header().Set("Retry-After", "1")
+		callbackError(w, "order is being updated, retry later", http.StatusServiceUnavailable, "retry")
+		return
+	default:
+		log.Errorw("accrual callback failed", "order", info.Order, "error", err)
+		c.releaseNonce(nonce)
+		callbackError(w, "internal error", http.StatusInternalServerError, "error")
+		return
+	}
+
+	metrics.AccrualCallbacks.WithLabelValues("applied").Inc()
+	log.Debugw("accrual callback applied", "order", info.Order, "status", info.Status)
+	w.WriteHeader(http.StatusOK)
+}
+
+// verify возвращает причину отказа или "", если подпись верна и запрос свежий.
+func (c *AccrualCallback) verify(h http.Header, body []byte) string {
+	if len(c.secret) == 0 {
+		return "callback secret is not configured"
+	}
+	ts, nonce, sig := h.Get(HeaderCallbackTimestamp), h.Get(HeaderCallbackNonce), h.Get(HeaderCallbackSignature)
+	if ts == "" || nonce == "" || sig == "" {
+		return "missing signature headers"
+	}
+
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "invalid timestamp"
+	}
+	skew := c.now().Sub(time.Unix(sec, 0))
+	if skew > c.maxSkew || skew < -c.maxSkew {
+		return "timestamp outside allowed skew"
+	}
+
+	want := SignCallback(c.secret, ts, nonce, body)
+	if !hmac.Equal([]byte(sig), []byte(want)) {
+		return "signature mismatch"
+	}
+	return ""
+}
+
+// useNonce запоминает nonce и возвращает false, если он уже встречался.
+// Запись живёт два окна: к этому времени запрос с тем же nonce устареет по времени.
+func (c *AccrualCallback) useNonce(nonce string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if now.Sub(c.lastSweep) > c.maxSkew {
+		for n, exp := range c.nonces {
+			if now.After(exp) {
+				delete(c.nonces, n)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	if exp, ok := c.nonces[nonce]; ok && !now.After(exp) {
+		return false
+	}
+	c.nonces[nonce] = now.Add(2 * c.maxSkew)
+	return true
+}
+
+// releaseNonce снова разрешает nonce: запрос с ним не был применён.
+func (c *AccrualCallback) releaseNonce(nonce string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.nonces, nonce)
+}
+
+func callbackError(w http.ResponseWriter, msg string, code int, outcome string) {
+	metrics.AccrualCallbacks.WithLabelValues(outcome).Inc()
+	http.Error(w, msg, code)
+}
@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/accrual"
+	"github.com/g123udini/gofemart/internal/model"
+	"github.com/g123udini/gofemart/internal/repository"
+)
+
+type fakeIngester struct {
+	got []accrual.OrderInfo
+	err error
+}
+
+func (f *fakeIngester) Ingest(ctx context.Context, info accrual.OrderInfo) error {
+	f.got = append(f.got, info)
+	return f.err
+}
+
+var callbackSecret = []byte("s3cret")
+
+func signedCallback(t *testing.T, body string, at time.Time, nonce string) *http.Request {
+	t.Helper()
+	ts := strconv.FormatInt(at.Unix(), 10)
+	req := httptest.NewRequest(http.MethodPost, "/internal/accrual/callback", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderCallbackTimestamp, ts)
+	req.Header.Set(HeaderCallbackNonce, nonce)
+	req.Header.Set(HeaderCallbackSignature, SignCallback(callbackSecret, ts, nonce, []byte(body)))
+	return req
+}
+
+func TestAccrualCallback_Verify(t *testing.T) {
+	const body = `{"order":"12345678903","status":"PROCESSED","accrual":500}`
+	now := time.Now()
+
+	ing := &fakeIngester{}
+	c := NewAccrualCallback(ing, string(callbackSecret), time.Minute)
+	c.now = func() time.Time { return now }
+
+	rr := httptest.NewRecorder()
+	c.ServeHTTP(rr, signedCallback(t, body, now, "n1"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("signed callback status=%d body=%q", rr.Code, rr.Body.String())
+	}
+	if len(ing.got) != 1 || ing.got[0].Order != "12345678903" || ing.got[0].Status != accrual.StatusProcessed {
+		t.Fatalf("ingested=%+v", ing.got)
+	}
+
+	tampered := signedCallback(t, body, now, "n2")
+	tampered.Body = http.NoBody
+	tests := []struct {
+		name string
+		req  *http.Request
+		want int
+	}{
+		{"replayed nonce", signedCallback(t, body, now, "n1"), http.StatusConflict},
+		{"stale timestamp", signedCallback(t, body, now.Add(-2*time.Minute), "n3"), http.StatusUnauthorized},
+		{"future timestamp", signedCallback(t, body, now.Add(2*time.Minute), "n4"), http.StatusUnauthorized},
+		{"tampered body", tampered, http.StatusUnauthorized},
+		{"unsigned", httptest.NewRequest(http.MethodPost, "/internal/accrual/callback", strings.NewReader(body)), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		c.ServeHTTP(rr, tt.req)
+		if rr.Code != tt.want {
+			t.Fatalf("%s: status=%d want=%d", tt.name, rr.Code, tt.want)
+		}
+	}
+	if len(ing.got) != 1 {
+		t.Fatalf("rejected callbacks must not be ingested, got %d", len(ing.got))
+	}
+
+	// nonce с отклонённой подписью не сгорает
+	rr = httptest.NewRecorder()
+	c.ServeHTTP(rr, signedCallback(t, body, now, "n2"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("nonce of a rejected request must stay usable, status=%d", rr.Code)
+	}
+}
+
+func TestAccrualCallback_IngestErrors(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{repository.ErrLeaseLost, http.StatusServiceUnavailable},
+		{&accrual.AnomalyError{Reason: accrual.AnomalyNegativeAccrual}, http.StatusUnprocessableEntity},
+		{accrual.ErrBadNotification, http.StatusBadRequest},
+		{accrual.ErrUnknownOrder, http.StatusNotFound},
+		{accrual.ErrOrderParked, http.StatusConflict},
+	}
+	for i, tt := range tests {
+		c := NewAccrualCallback(&fakeIngester{err: tt.err}, string(callbackSecret), time.Minute)
+		rr := httptest.NewRecorder()
+		c.ServeHTTP(rr, signedCallback(t, `{"order":"1","status":"PROCESSED"}`, time.Now(), strconv.Itoa(i)))
+		if rr.Code != tt.want {
+			t.Fatalf("%v: status=%d want=%d", tt.err, rr.Code, tt.want)
+		}
+	}
+
+	// после 503 и 500 отправитель повторяет тот же подписанный запрос, и его применяют
+	for _, retryable := range []error{repository.ErrLeaseLost, errors.New("db is down")} {
+		ing := &fakeIngester{err: retryable}
+		c := NewAccrualCallback(ing, string(callbackSecret), time.Minute)
+		req := signedCallback(t, `{"order":"1","status":"PROCESSED"}`, time.Now(), "retry")
+		resend := req.Clone(context.Background())
+		resend.Body = io.NopCloser(strings.NewReader(`{"order":"1","status":"PROCESSED"}`))
+
+		rr := httptest.NewRecorder()
+		c.ServeHTTP(rr, req)
+		if rr.Code != http.StatusServiceUnavailable && rr.Code != http.StatusInternalServerError {
+			t.Fatalf("%v: status=%d want 503 or 500", retryable, rr.Code)
+		}
+		ing.err = nil
+		rr = httptest.NewRecorder()
+		c.ServeHTTP(rr, resend)
+		if rr.Code != http.StatusOK || len(ing.got) != 2 {
+			t.Fatalf("%v: resend status=%d ingested=%d, want 200 and applied", retryable, rr.Code, len(ing.got))
+		}
+	}
+
+	// без секрета приём закрыт целиком
+	c := NewAccrualCallback(&fakeIngester{}, "", time.Minute)
+	rr := httptest.NewRecorder()
+	c.ServeHTTP(rr, signedCallback(t, `{"order":"1","status":"PROCESSED"}`, time.Now(), "x"))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("empty secret must reject everything, status=%d", rr.Code)
+	}
+}
+
+// Повторное уведомление с новым nonce через настоящий воркер начисляет баллы один раз.
+func TestAccrualCallback_AppliesOnce(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemory()
+	if err := store.SaveUser(ctx, &model.User{Login: "alice"}); err != nil {
+		t.Fatalf("SaveUser: %v", err)
+	}
+	u, _ := store.GetUserByLogin(ctx, "alice")
+	if err := store.SaveOrder(ctx, &model.Order{Number: "12345678903", Status: "NEW", UploadedAt: time.Now(), UserID: u.ID}); err != nil {
+		t.Fatalf("SaveOrder: %v", err)
+	}
+
+	worker := accrual.NewAccrualWorker(store, nil, nil)
+	c := NewAccrualCallback(worker, string(callbackSecret), time.Minute)
+	for _, nonce := range []string{"a", "b"} {
+		rr := httptest.NewRecorder()
+		c.ServeHTTP(rr, signedCallback(t, `{"order":"12345678903","status":"PROCESSED","accrual":500}`, time.Now(), nonce))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("nonce %s: status=%d body=%q", nonce, rr.Code, rr.Body.String())
+		}
+	}
+
+	b, err := store.GetBalance(ctx, u)
+	if err != nil {
+		t.Fatalf("GetBalance: %v", err)
+	}
+	if b.Current != 50000 {
+		t.Fatalf("balance=%d want 50000 cents credited once", b.Current)
+	}
+	if o, _ := store.GetOrderByNumberUser(ctx, "12345678903", u); o == nil || o.Status != "PROCESSED" {
+		t.Fatalf("order=%+v want PROCESSED", o)
+	}
+}
@@ -6,12 +6,14 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"github.com/g123udini/gofemart/internal/metrics"
 	"github.com/g123udini/gofemart/internal/model"
 	"github.com/g123udini/gofemart/internal/repository"
 	"github.com/g123udini/gofemart/internal/service"
 	"golang.org/x/crypto/bcrypt"
 	"io"
 	"math"
+	"mime"
 	"net/http"
 	"strings"
 	"time"
@@ -22,16 +24,37 @@ var (
 	ErrUserNotFound = errors.New("user not found")
 )
 
+// Storage — хранилище, с которым работают обработчики API.
+type Storage interface {
+	repository.Users
+	repository.Orders
+	repository.Withdrawals
+}
+
 type Handler struct {
-	repo *repository.Repo
-	ms   *service.MemSessionStorage
+	repo         Storage
+	ms           *service.MemSessionStorage
+	secureCookie bool
+}
+
+type Option func(h *Handler)
+
+// WithSecureCookie ставит флаг Secure на cookie сессии (нужен HTTPS).
+func WithSecureCookie(secure bool) Option {
+	return func(h *Handler) {
+		h.secureCookie = secure
+	}
 }
 
-func NewHandler(repository *repository.Repo, ms *service.MemSessionStorage) *Handler {
-	return &Handler{
+func NewHandler(repository Storage, ms *service.MemSessionStorage, opts ...Option) *Handler {
+	h := &Handler{
 		repo: repository,
 		ms:   ms,
 	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
 }
 
 func (handler *Handler) Test(w http.ResponseWriter, r *http.Request) {
@@ -63,7 +86,7 @@ func (handler *Handler) Register(w http.ResponseWriter, r *http.Request) {
 		},
 	}
 
-	err := handler.repo.SaveUser(&u)
+	err := handler.repo.SaveUser(r.Context(), &u)
 
 	if err != nil {
 		if errors.Is(err, repository.ErrUniqConstrait) {
@@ -71,7 +94,7 @@ func (handler *Handler) Register(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		handler.internalError(w, r, err)
 		return
 	}
 
@@ -94,7 +117,7 @@ func (handler *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	u, err := handler.repo.GetUserByLogin(input.Login)
+	u, err := handler.repo.GetUserByLogin(r.Context(), input.Login)
 	hash, _ := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
 
 	if err != nil {
@@ -103,7 +126,12 @@ func (handler *Handler) Login(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		handler.internalError(w, r, err)
+		return
+	}
+
+	if u != nil && u.Disabled() {
+		http.Error(w, "user disabled", http.StatusForbidden)
 		return
 	}
 
@@ -114,75 +142,69 @@ func (handler *Handler) Login(w http.ResponseWriter, r *http.Request) {
 }
 
 func (handler *Handler) GetOrder(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("session_id")
-	if err != nil || cookie.Value == "" {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	user, err := handler.getUser(r)
+	if errors.Is(err, ErrUnauthorized) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, ErrUserNotFound) || (err == nil && user == nil) {
+		http.Error(w, "user not found", http.StatusBadRequest)
 		return
 	}
-
-	login, _ := handler.ms.GetSession(cookie.Value)
-	user, err := handler.repo.GetUserByLogin(login)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			http.Error(w, "user not found", http.StatusBadRequest)
-			return
-		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		handler.internalError(w, r, err)
 		return
 	}
 
-	orders, err := handler.repo.GetOrdersByUser(user)
+	orders, err := handler.repo.GetOrdersByUser(r.Context(), user)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			http.Error(w, "orders not found", http.StatusNoContent)
 			return
 		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		handler.internalError(w, r, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	if err = json.NewEncoder(w).Encode(orders); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		handler.internalError(w, r, err)
 		return
 	}
 }
 
 func (handler *Handler) GetWithdrawals(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("session_id")
-	if err != nil || cookie.Value == "" {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	user, err := handler.getUser(r)
+	if errors.Is(err, ErrUnauthorized) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, ErrUserNotFound) || (err == nil && user == nil) {
+		http.Error(w, "user not found", http.StatusBadRequest)
 		return
 	}
-
-	login, _ := handler.ms.GetSession(cookie.Value)
-	user, err := handler.repo.GetUserByLogin(login)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			http.Error(w, "user not found", http.StatusBadRequest)
-			return
-		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		handler.internalError(w, r, err)
 		return
 	}
 
-	withdrawals, err := handler.repo.GetWithdrawalsByUser(user)
+	withdrawals, err := handler.repo.GetWithdrawalsByUser(r.Context(), user)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			http.Error(w, "withdrawals not found", http.StatusNoContent)
 			return
 		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		handler.internalError(w, r, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	if err = json.NewEncoder(w).Encode(withdrawals); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		handler.internalError(w, r, err)
 		return
 	}
 }
@@ -197,11 +219,22 @@ func (handler *Handler) GetBalance(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if err != nil {
+		handler.internalError(w, r, err)
+		return
+	}
+
+	// баланс может прийти с реплики; сразу после списания Repo читает его с мастера
+	balance, err := handler.repo.GetBalance(r.Context(), user)
+	if err != nil {
+		handler.internalError(w, r, err)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	if err = json.NewEncoder(w).Encode(user.Balance); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err = json.NewEncoder(w).Encode(balance); err != nil {
+		handler.internalError(w, r, err)
 		return
 	}
 }
@@ -240,7 +273,7 @@ func (handler *Handler) Withdraw(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		handler.internalError(w, r, err)
 		return
 	}
 
@@ -261,27 +294,66 @@ func (handler *Handler) Withdraw(w http.ResponseWriter, r *http.Request) {
 		UserID: user.ID,
 	}
 
-	if err := handler.repo.SaveWithdrawal(&withdrawal); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := handler.repo.SaveWithdrawal(r.Context(), &withdrawal); err != nil {
+		handler.internalError(w, r, err)
 		return
 	}
 
-	if err := handler.repo.UpdateUser(user); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := handler.repo.UpdateUser(r.Context(), user); err != nil {
+		handler.internalError(w, r, err)
 		return
 	}
+	metrics.WithdrawnCents.Add(float64(sumCents))
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 }
 
-func (handler *Handler) AddOrder(w http.ResponseWriter, r *http.Request) {
+// orderUpload — заказ с чеком (application/json). Чек gophermart сам регистрирует
+// в системе расчёта; цены в рублях с копейками.
+type orderUpload struct {
+	Order string `json:"order"`
+	Goods []struct {
+		Description string  `json:"description"`
+		Price       float64 `json:"price"`
+	} `json:"goods"`
+}
+
+// readOrderUpload читает номер заказа из text/plain или заказ с чеком из JSON.
+// goods == nil — заказ без чека.
+func readOrderUpload(r *http.Request) (string, []model.Good, error) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "cannot read body", http.StatusBadRequest)
+		return "", nil, errors.New("cannot read body")
+	}
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+		return strings.TrimSpace(string(body)), nil, nil
+	}
+
+	var input orderUpload
+	if err := json.Unmarshal(body, &input); err != nil {
+		return "", nil, errors.New("bad request")
+	}
+	if len(input.Goods) == 0 {
+		return "", nil, errors.New("receipt has no goods")
+	}
+	goods := make([]model.Good, 0, len(input.Goods))
+	for _, g := range input.Goods {
+		price := int(math.Round(g.Price * 100))
+		if strings.TrimSpace(g.Description) == "" || price <= 0 {
+			return "", nil, errors.New("each good needs a description and a positive price")
+		}
+		goods = append(goods, model.Good{Description: g.Description, Price: price})
+	}
+	return strings.TrimSpace(input.Order), goods, nil
+}
+
+func (handler *Handler) AddOrder(w http.ResponseWriter, r *http.Request) {
+	orderNumber, goods, err := readOrderUpload(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	orderNumber := strings.TrimSpace(string(body))
 
 	if !service.ValidLun(orderNumber) {
 		http.Error(w, "order number not valid", http.StatusUnprocessableEntity)
@@ -298,12 +370,29 @@ func (handler *Handler) AddOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	existing, err := handler.repo.GetOrderByNumberUser(orderNumber, user)
+	existing, err := handler.repo.GetOrderByNumberUser(r.Context(), orderNumber, user)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		handler.internalError(w, r, err)
 		return
 	}
 	if existing != nil {
+		if goods != nil {
+			// заказ загружали без чека: прикладываем чек, чтобы его зарегистрировать
+			err = handler.repo.AddOrderReceipt(r.Context(), orderNumber, goods)
+			if err == nil {
+				w.WriteHeader(http.StatusAccepted)
+				w.Write([]byte("receipt added"))
+				return
+			}
+			if errors.Is(err, repository.ErrOrderFinal) {
+				http.Error(w, "order is already processed, receipt not accepted", http.StatusConflict)
+				return
+			}
+			if !errors.Is(err, repository.ErrUniqConstrait) {
+				handler.internalError(w, r, err)
+				return
+			}
+		}
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("exists"))
 		return
@@ -317,12 +406,17 @@ func (handler *Handler) AddOrder(w http.ResponseWriter, r *http.Request) {
 		UserID:     user.ID,
 	}
 
-	if err = handler.repo.SaveOrder(order); err != nil {
+	if goods != nil {
+		err = handler.repo.SaveOrderReceipt(r.Context(), order, goods)
+	} else {
+		err = handler.repo.SaveOrder(r.Context(), order)
+	}
+	if err != nil {
 		if errors.Is(err, repository.ErrUniqConstrait) {
 			http.Error(w, err.Error(), http.StatusConflict)
 			return
 		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		handler.internalError(w, r, err)
 		return
 	}
 
@@ -369,29 +463,44 @@ func (handler *Handler) startSession(u *model.User, w http.ResponseWriter) {
 		Name:     "session_id",
 		Value:    sessionID,
 		Path:     "/",
+		MaxAge:   int(handler.ms.TTL().Seconds()),
 		HttpOnly: true,
+		Secure:   handler.secureCookie,
 		SameSite: http.SameSiteLaxMode,
 	})
 }
 
+func (handler *Handler) internalError(w http.ResponseWriter, r *http.Request, err error) {
+	service.LoggerFrom(r.Context()).Errorw("request failed", "error", err)
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
 func (handler *Handler) getUser(r *http.Request) (*model.User, error) {
 	cookie, err := r.Cookie("session_id")
 	if err != nil || cookie.Value == "" {
 		return nil, ErrUnauthorized
 	}
 
-	login, ok := handler.ms.GetSession(cookie.Value)
+	sess, ok := handler.ms.Lookup(cookie.Value)
 	if !ok {
 		return nil, ErrUnauthorized
 	}
 
-	user, err := handler.repo.GetUserByLogin(login)
+	user, err := handler.repo.GetUserByLogin(r.Context(), sess.Login)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrUserNotFound
 		}
 		return nil, err
 	}
+	if user != nil {
+		// пользователя заблокировали или отозвали сессии через gophermart admin
+		if user.Disabled() || (user.SessionsRevokedAt != nil && !sess.CreatedAt.After(*user.SessionsRevokedAt)) {
+			handler.ms.DeleteSession(cookie.Value)
+			return nil, ErrUnauthorized
+		}
+		service.SetRequestUser(r.Context(), user.ID)
+	}
 
 	return user, nil
 }
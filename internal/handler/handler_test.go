@@ -8,8 +8,10 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/g123udini/gofemart/internal/repository"
 	"github.com/g123udini/gofemart/internal/service"
@@ -43,14 +45,41 @@ func (c *handlerTestConn) QueryContext(ctx context.Context, query string, args [
 	if strings.Contains(query, "FROM users") && strings.Contains(query, "WHERE login = $1") {
 		if c.mode == "user_ok" {
 			return &handlerTestRows{
-				cols: []string{"id", "login", "password", "current", "withdrawn"},
+				cols: []string{"id", "login", "password", "current", "withdrawn", "disabled_at", "sessions_revoked_at"},
 				data: [][]driver.Value{
-					{int64(1), "u1", "hash", int64(100), int64(7)},
+					{int64(1), "u1", "hash", int64(100), int64(7), nil, nil},
 				},
 			}, nil
 		}
 	}
 
+	if strings.Contains(query, "FROM users") && strings.Contains(query, "WHERE login = $1") {
+		revoked := time.Now().Add(time.Hour)
+		switch c.mode {
+		case "user_disabled":
+			return &handlerTestRows{
+				cols: []string{"id", "login", "password", "current", "withdrawn", "disabled_at", "sessions_revoked_at"},
+				data: [][]driver.Value{
+					{int64(1), "u1", "hash", int64(100), int64(7), revoked, revoked},
+				},
+			}, nil
+		case "user_revoked":
+			return &handlerTestRows{
+				cols: []string{"id", "login", "password", "current", "withdrawn", "disabled_at", "sessions_revoked_at"},
+				data: [][]driver.Value{
+					{int64(1), "u1", "hash", int64(100), int64(7), nil, revoked},
+				},
+			}, nil
+		}
+	}
+
+	if strings.Contains(query, "SELECT current, withdrawn FROM users WHERE id = $1") {
+		return &handlerTestRows{
+			cols: []string{"current", "withdrawn"},
+			data: [][]driver.Value{{int64(100), int64(7)}},
+		}, nil
+	}
+
 	return &handlerTestRows{cols: []string{"x"}, data: nil}, nil
 }
 
@@ -204,6 +233,31 @@ func TestGetBalance_OK(t *testing.T) {
 	}
 }
 
+func TestGetBalance_DisabledOrRevokedUser(t *testing.T) {
+	for _, mode := range []string{"user_disabled", "user_revoked"} {
+		db, _ := sql.Open("handler_test_driver", mode)
+		repo := &repository.Repo{DB: db}
+
+		ms := service.NewMemStorage()
+		ms.AddSession("sid1", "u1")
+
+		h := NewHandler(repo, ms)
+
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/user/balance/", nil)
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: "sid1"})
+
+		h.GetBalance(rr, req)
+
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: status=%d want=%d", mode, rr.Code, http.StatusUnauthorized)
+		}
+		if _, ok := ms.GetSession("sid1"); ok {
+			t.Fatalf("%s: session must be dropped", mode)
+		}
+	}
+}
+
 func TestAddOrder_InvalidLuhn(t *testing.T) {
 	h := NewHandler(&repository.Repo{}, service.NewMemStorage())
 
@@ -294,3 +348,131 @@ func TestWithdraw_InsufficientBalance(t *testing.T) {
 		t.Fatalf("status=%d want=%d body=%q", rr.Code, http.StatusPaymentRequired, rr.Body.String())
 	}
 }
+
+func TestHandler_WithMemoryStorage(t *testing.T) {
+	store := repository.NewMemory()
+	h := NewHandler(store, service.NewMemStorage())
+
+	rr := httptest.NewRecorder()
+	h.Register(rr, httptest.NewRequest(http.MethodPost, "/api/user/register", strings.NewReader(`{"login":"u1","password":"p1"}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("register status=%d body=%q", rr.Code, rr.Body.String())
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatalf("no session cookie")
+	}
+
+	for _, want := range []int{http.StatusAccepted, http.StatusOK} {
+		rr = httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/user/orders", strings.NewReader("12345678903"))
+		req.AddCookie(cookies[0])
+		h.AddOrder(rr, req)
+		if rr.Code != want {
+			t.Fatalf("add order status=%d want=%d body=%q", rr.Code, want, rr.Body.String())
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/user/orders", nil)
+	req.AddCookie(cookies[0])
+	h.GetOrder(rr, req)
+
+	var orders []map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &orders); err != nil {
+		t.Fatalf("unmarshal: %v body=%q", err, rr.Body.String())
+	}
+	if len(orders) != 1 || orders[0]["number"] != "12345678903" || orders[0]["status"] != "NEW" {
+		t.Fatalf("orders=%v", orders)
+	}
+}
+
+func TestAddOrder_WithReceipt(t *testing.T) {
+	store := repository.NewMemory()
+	h := NewHandler(store, service.NewMemStorage())
+
+	rr := httptest.NewRecorder()
+	h.Register(rr, httptest.NewRequest(http.MethodPost, "/api/user/register", strings.NewReader(`{"login":"u1","password":"p1"}`)))
+	cookies := rr.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatalf("no session cookie")
+	}
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"order":"12345678903","goods":[{"description":"Чайник Bork","price":7000.5}]}`, http.StatusAccepted},
+		{`{"order":"12345678903","goods":[{"description":"Чайник Bork","price":7000.5}]}`, http.StatusOK},
+		{`{"order":"123","goods":[{"description":"Чайник Bork","price":7000}]}`, http.StatusUnprocessableEntity},
+		{`{"order":"79927398713","goods":[{"description":"","price":7000}]}`, http.StatusBadRequest},
+		{`{"order":"79927398713","goods":[{"description":"Чайник","price":0}]}`, http.StatusBadRequest},
+		{`{"order":"79927398713","goods":[]}`, http.StatusBadRequest},
+		{`{"order":`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rr = httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/user/orders", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(cookies[0])
+		h.AddOrder(rr, req)
+		if rr.Code != tt.want {
+			t.Fatalf("%s: status=%d want=%d body=%q", tt.body, rr.Code, tt.want, rr.Body.String())
+		}
+	}
+
+	// заказ, загруженный без чека, получает чек при повторной загрузке с ним
+	for _, tt := range []struct {
+		contentType, body string
+		want              int
+	}{
+		{"text/plain", "79927398713", http.StatusAccepted},
+		{"application/json", `{"order":"79927398713","goods":[{"description":"Стакан","price":150}]}`, http.StatusAccepted},
+		{"application/json", `{"order":"79927398713","goods":[{"description":"Стакан","price":150}]}`, http.StatusOK},
+	} {
+		rr = httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/user/orders", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", tt.contentType)
+		req.AddCookie(cookies[0])
+		h.AddOrder(rr, req)
+		if rr.Code != tt.want {
+			t.Fatalf("%s: status=%d want=%d body=%q", tt.body, rr.Code, tt.want, rr.Body.String())
+		}
+	}
+
+	// к уже рассчитанному заказу чек не прикладывается
+	for _, tt := range []struct {
+		contentType, body string
+		want              int
+	}{
+		{"text/plain", "4561261212345467", http.StatusAccepted},
+		{"application/json", `{"order":"4561261212345467","goods":[{"description":"Стакан","price":150}]}`, http.StatusConflict},
+	} {
+		if tt.want == http.StatusConflict {
+			_ = store.ApplyOrderProcessedOnce(context.Background(), 4561261212345467, 10)
+		}
+		rr = httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/user/orders", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", tt.contentType)
+		req.AddCookie(cookies[0])
+		h.AddOrder(rr, req)
+		if rr.Code != tt.want {
+			t.Fatalf("%s: status=%d want=%d body=%q", tt.body, rr.Code, tt.want, rr.Body.String())
+		}
+	}
+
+	receipts, err := store.ClaimPendingRegistrations(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("ClaimPendingRegistrations: %v", err)
+	}
+	if len(receipts) != 2 {
+		t.Fatalf("receipts=%+v want two", receipts)
+	}
+	sort.Slice(receipts, func(i, j int) bool { return receipts[i].Number < receipts[j].Number })
+	if receipts[0].Number != "12345678903" || receipts[1].Number != "79927398713" {
+		t.Fatalf("receipts=%+v", receipts)
+	}
+	if g := receipts[0].Goods; len(g) != 1 || g[0].Price != 700050 {
+		t.Fatalf("goods=%+v want price 700050 cents", g)
+	}
+}
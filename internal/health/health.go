@@ -0,0 +1,124 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+type Check func(ctx context.Context) error
+
+type ComponentStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components,omitempty"`
+}
+
+type component struct {
+	name  string
+	check Check
+}
+
+// Health собирает проверки для /healthz (процесс и воркер живы)
+// и /readyz (зависимости готовы принимать трафик).
+type Health struct {
+	mu        sync.RWMutex
+	liveness  []component
+	readiness []component
+	timeout   time.Duration
+}
+
+func New(timeout time.Duration) *Health {
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	return &Health{timeout: timeout}
+}
+
+func (h *Health) AddLiveness(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.liveness = append(h.liveness, component{name: name, check: check})
+}
+
+func (h *Health) AddReadiness(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.readiness = append(h.readiness, component{name: name, check: check})
+}
+
+func (h *Health) Healthz(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	components := h.liveness
+	h.mu.RUnlock()
+
+	h.respond(w, h.run(r.Context(), components))
+}
+
+func (h *Health) Readyz(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	components := h.readiness
+	h.mu.RUnlock()
+
+	h.respond(w, h.run(r.Context(), components))
+}
+
+// run выполняет проверки параллельно, каждую со своим таймаутом.
+func (h *Health) run(ctx context.Context, components []component) Report {
+	report := Report{
+		Status:     StatusOK,
+		Components: make(map[string]ComponentStatus, len(components)),
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, c := range components {
+		wg.Add(1)
+		go func(c component) {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, h.timeout)
+			defer cancel()
+
+			st := ComponentStatus{Status: StatusOK}
+			if err := c.check(checkCtx); err != nil {
+				st = ComponentStatus{Status: StatusFail, Error: err.Error()}
+			}
+
+			mu.Lock()
+			report.Components[c.name] = st
+			if st.Status != StatusOK {
+				report.Status = StatusFail
+			}
+			mu.Unlock()
+		}(c)
+	}
+	wg.Wait()
+
+	return report
+}
+
+func (h *Health) respond(w http.ResponseWriter, report Report) {
+	code := http.StatusOK
+	if report.Status != StatusOK {
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,96 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func decodeReport(t *testing.T, rr *httptest.ResponseRecorder) Report {
+	t.Helper()
+
+	var rep Report
+	if err := json.Unmarshal(rr.Body.Bytes(), &rep); err != nil {
+		t.Fatalf("unmarshal: %v body=%q", err, rr.Body.String())
+	}
+	return rep
+}
+
+func TestHealthz_NoChecks_OK(t *testing.T) {
+	h := New(time.Second)
+
+	rr := httptest.NewRecorder()
+	h.Healthz(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status=%d want=%d", rr.Code, http.StatusOK)
+	}
+	if rep := decodeReport(t, rr); rep.Status != StatusOK {
+		t.Fatalf("status=%q want=%q", rep.Status, StatusOK)
+	}
+}
+
+func TestReadyz_ComponentBreakdown(t *testing.T) {
+	h := New(time.Second)
+	h.AddReadiness("database", func(context.Context) error { return nil })
+	h.AddReadiness("accrual", func(context.Context) error { return errors.New("connection refused") })
+
+	rr := httptest.NewRecorder()
+	h.Readyz(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status=%d want=%d", rr.Code, http.StatusServiceUnavailable)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("content-type=%q", ct)
+	}
+
+	rep := decodeReport(t, rr)
+	if rep.Status != StatusFail {
+		t.Fatalf("status=%q want=%q", rep.Status, StatusFail)
+	}
+	if rep.Components["database"].Status != StatusOK {
+		t.Fatalf("database=%+v", rep.Components["database"])
+	}
+	if rep.Components["accrual"].Status != StatusFail || rep.Components["accrual"].Error != "connection refused" {
+		t.Fatalf("accrual=%+v", rep.Components["accrual"])
+	}
+}
+
+func TestReadyz_DoesNotRunLivenessChecks(t *testing.T) {
+	h := New(time.Second)
+	h.AddLiveness("accrual_worker", func(context.Context) error { return errors.New("stuck") })
+
+	rr := httptest.NewRecorder()
+	h.Readyz(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status=%d want=%d", rr.Code, http.StatusOK)
+	}
+
+	rr = httptest.NewRecorder()
+	h.Healthz(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status=%d want=%d", rr.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestCheck_Timeout(t *testing.T) {
+	h := New(20 * time.Millisecond)
+	h.AddReadiness("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	rr := httptest.NewRecorder()
+	h.Readyz(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status=%d want=%d", rr.Code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var pendingOrdersDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "accrual", "pending_orders"),
+	"Non-final orders still waiting for the accrual system, excluding quarantined and dead-lettered ones.",
+	nil, nil,
+)
+
+// backlogCollector считает очередь при каждом сборе метрик: размер батча воркера
+// ограничен batch_limit и не показывает, сколько заказов ждёт на самом деле.
+type backlogCollector struct {
+	count   func(ctx context.Context) (int, error)
+	timeout time.Duration
+}
+
+func (c *backlogCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pendingOrdersDesc
+}
+
+func (c *backlogCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	n, err := c.count(ctx)
+	if err != nil {
+		// без значения, но остальные метрики отдаём
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(pendingOrdersDesc, prometheus.GaugeValue, float64(n))
+}
+
+// RegisterPendingOrders подключает gauge pending_orders; count вызывается на каждый сбор.
+func RegisterPendingOrders(count func(ctx context.Context) (int, error)) error {
+	return Registry.Register(&backlogCollector{count: count, timeout: 2 * time.Second})
+}
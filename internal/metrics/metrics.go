@@ -0,0 +1,193 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/service"
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gophermart"
+
+// Outcome-метки вызовов системы расчёта.
+const (
+	AccrualOutcomeOK          = "200"
+	AccrualOutcomeNoContent   = "204"
+	AccrualOutcomeRateLimited = "429"
+	AccrualOutcomeAnomaly     = "anomaly"
+	AccrualOutcomeError       = "error"
+)
+
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests by route, method and status.",
+	}, []string{"route", "method", "status"})
+
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency by route, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	AccrualRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "accrual",
+		Name:      "requests_total",
+		Help:      "Accrual system calls by outcome (200, 204, 429, anomaly, error).",
+	}, []string{"outcome"})
+
+	RateLimitPause = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "accrual",
+		Name:      "rate_limit_pause_seconds",
+		Help:      "Pauses taken after 429 responses from the accrual system.",
+		Buckets:   []float64{1, 5, 10, 30, 60, 120, 300},
+	})
+
+	AccrualBreakerState = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "accrual",
+		Name:      "breaker_state",
+		Help:      "Accrual circuit breaker state: 0 closed, 1 half-open, 2 open.",
+	})
+
+	AccrualRateLimit = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "accrual",
+		Name:      "rate_limit_per_minute",
+		Help:      "Accrual request limit learned from 429 responses, 0 while unknown.",
+	})
+
+	QuarantinedOrders = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "accrual",
+		Name:      "quarantined_orders_total",
+		Help:      "Orders quarantined because of anomalous accrual responses, by reason.",
+	}, []string{"reason"})
+
+	DeadLetteredOrders = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "accrual",
+		Name:      "dead_lettered_orders_total",
+		Help:      "Orders no longer polled because the accrual system did not resolve them in time, by reason.",
+	}, []string{"reason"})
+
+	ReceiptRegistrations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "accrual",
+		Name:      "receipt_registrations_total",
+		Help:      "Receipts sent to the accrual system by outcome (registered, conflict, rejected, retry, failed).",
+	}, []string{"outcome"})
+
+	AccrualCallbacks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "accrual",
+		Name:      "callbacks_total",
+		Help:      "Status notifications pushed by the accrual system, by outcome (applied, unauthorized, replayed, rejected, unknown, parked, anomaly, retry, error).",
+	}, []string{"outcome"})
+
+	AccruedCents = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "accrued_cents_total",
+		Help:      "Loyalty points credited to users, in cents.",
+	})
+
+	WithdrawnCents = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "withdrawn_cents_total",
+		Help:      "Loyalty points withdrawn by users, in cents.",
+	})
+
+	Retries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "retry",
+		Name:      "attempts_total",
+		Help:      "Repeated attempts of failed operations by operation.",
+	}, []string{"op"})
+
+	RetryGiveUps = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "retry",
+		Name:      "give_ups_total",
+		Help:      "Operations abandoned after retries ran out, by operation.",
+	}, []string{"op"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		AccrualRequests,
+		RateLimitPause,
+		AccrualBreakerState,
+		AccrualRateLimit,
+		QuarantinedOrders,
+		DeadLetteredOrders,
+		ReceiptRegistrations,
+		AccrualCallbacks,
+		AccruedCents,
+		WithdrawnCents,
+		Retries,
+		RetryGiveUps,
+	)
+}
+
+// RetryHooks считает повторы и отказы service.RetryPolicy по имени операции.
+func RetryHooks() service.RetryHooks {
+	return service.RetryHooks{
+		OnRetry: func(op string, _ int, _ time.Duration, _ error) {
+			Retries.WithLabelValues(op).Inc()
+		},
+		OnGiveUp: func(op string, _ int, _ error) {
+			RetryGiveUps.WithLabelValues(op).Inc()
+		},
+	}
+}
+
+// RegisterDB публикует статистику пула соединений (DB.Stats()).
+func RegisterDB(db *sql.DB) error {
+	return Registry.Register(collectors.NewDBStatsCollector(db, namespace))
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Middleware считает запросы и латентность по шаблону маршрута chi,
+// чтобы номера заказов и прочие параметры не раздували кардинальность.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		lrw := service.NewLoggingResponseWriter(w)
+
+		next.ServeHTTP(lrw, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := lrw.ResponseData.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		code := strconv.Itoa(status)
+
+		HTTPRequests.WithLabelValues(route, r.Method, code).Inc()
+		HTTPDuration.WithLabelValues(route, r.Method, code).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMiddleware_UsesRoutePattern(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/api/orders/{number}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	before := testutil.ToFloat64(HTTPRequests.WithLabelValues("/api/orders/{number}", http.MethodGet, "204"))
+
+	for _, n := range []string{"1", "2", "3"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/orders/"+n, nil))
+	}
+
+	after := testutil.ToFloat64(HTTPRequests.WithLabelValues("/api/orders/{number}", http.MethodGet, "204"))
+	if after-before != 3 {
+		t.Fatalf("requests delta=%v want=3", after-before)
+	}
+}
+
+func TestMiddleware_DefaultStatusAndUnmatched(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	okBefore := testutil.ToFloat64(HTTPRequests.WithLabelValues("/ok", http.MethodGet, "200"))
+	missBefore := testutil.ToFloat64(HTTPRequests.WithLabelValues("unmatched", http.MethodGet, "404"))
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+
+	if d := testutil.ToFloat64(HTTPRequests.WithLabelValues("/ok", http.MethodGet, "200")) - okBefore; d != 1 {
+		t.Fatalf("ok delta=%v want=1", d)
+	}
+	if d := testutil.ToFloat64(HTTPRequests.WithLabelValues("unmatched", http.MethodGet, "404")) - missBefore; d != 1 {
+		t.Fatalf("unmatched delta=%v want=1", d)
+	}
+}
+
+func TestHandler_ExposesMetrics(t *testing.T) {
+	AccrualRequests.WithLabelValues(AccrualOutcomeRateLimited).Inc()
+	WithdrawnCents.Add(150)
+	// очередь считает хранилище; main подключает его при старте
+	err := RegisterPendingOrders(func(context.Context) (int, error) { return 0, nil })
+	if err != nil && !errors.As(err, new(prometheus.AlreadyRegisteredError)) {
+		t.Fatalf("RegisterPendingOrders: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status=%d want=%d", rr.Code, http.StatusOK)
+	}
+
+	body := rr.Body.String()
+	for _, want := range []string{
+		`gophermart_accrual_requests_total{outcome="429"}`,
+		"gophermart_withdrawn_cents_total",
+		"gophermart_accrued_cents_total",
+		"gophermart_accrual_pending_orders",
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics output does not contain %q", want)
+		}
+	}
+}
+
+func TestBacklogCollector(t *testing.T) {
+	n := 0
+	var err error
+	c := &backlogCollector{
+		count:   func(ctx context.Context) (int, error) { return n, err },
+		timeout: time.Second,
+	}
+
+	n = 1234
+	if got := testutil.ToFloat64(c); got != 1234 {
+		t.Fatalf("pending_orders=%v want 1234: the gauge must report the whole backlog", got)
+	}
+
+	err = errors.New("db is down")
+	if got := testutil.CollectAndCount(c); got != 0 {
+		t.Fatalf("collected %d metrics on error, want none", got)
+	}
+}
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Виды записей в истории движения баллов.
+const (
+	LedgerAccrual    = "accrual"
+	LedgerWithdrawal = "withdrawal"
+	LedgerCredit     = "credit"
+	LedgerDebit      = "debit"
+)
+
+// LedgerEntry — одна операция по балансу пользователя. Amount в копейках,
+// со знаком: начисления положительные, списания отрицательные.
+type LedgerEntry struct {
+	Kind      string
+	Reference string // номер заказа или причина ручной корректировки
+	Amount    int
+	At        time.Time
+}
+
+func (e LedgerEntry) MarshalJSON() ([]byte, error) {
+	type dto struct {
+		Kind      string  `json:"kind"`
+		Reference string  `json:"reference"`
+		Amount    float64 `json:"amount"`
+		At        string  `json:"at"`
+	}
+
+	return json.Marshal(dto{
+		Kind:      e.Kind,
+		Reference: e.Reference,
+		Amount:    float64(e.Amount) / 100,
+		At:        e.At.Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,29 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestLedgerEntry_MarshalJSON(t *testing.T) {
+	e := LedgerEntry{
+		Kind:      LedgerDebit,
+		Reference: "chargeback",
+		Amount:    -1050,
+		At:        time.Date(2025, 12, 21, 9, 0, 0, 0, time.UTC),
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	var m map[string]any
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if m["kind"] != "debit" || m["reference"] != "chargeback" || m["amount"] != -10.5 || m["at"] != "2025-12-21T09:00:00Z" {
+		t.Fatalf("unexpected json: %s", b)
+	}
+}
@@ -48,3 +48,27 @@ func (o Order) MarshalJSON() ([]byte, error) {
 		UploadedAt: o.UploadedAt.Format(time.RFC3339),
 	})
 }
+
+// StuckOrder — незавершённый заказ глазами администратора: сколько раз его
+// опрашивали, когда опросят снова и снят ли он с опроса (Parked).
+type StuckOrder struct {
+	Number     string    `json:"number"`
+	Status     string    `json:"status"`
+	UserID     int       `json:"user_id"`
+	Attempts   int       `json:"attempts"`
+	UploadedAt time.Time `json:"uploaded_at"`
+	NextPollAt time.Time `json:"next_poll_at"`
+	Parked     string    `json:"parked,omitempty"`
+}
+
+func (o *StuckOrder) ScanFields() []any {
+	return []any{
+		&o.Number,
+		&o.Status,
+		&o.UserID,
+		&o.Attempts,
+		&o.UploadedAt,
+		&o.NextPollAt,
+		&o.Parked,
+	}
+}
@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+// Решения администратора по заказу в карантине.
+const (
+	QuarantineReleased = "released"
+	QuarantineRejected = "rejected"
+)
+
+// QuarantineEntry — аномальный ответ системы расчёта, который не был применён.
+// Payload хранит тело ответа как есть.
+type QuarantineEntry struct {
+	ID          int64      `json:"id"`
+	OrderNumber string     `json:"order_number"`
+	Reason      string     `json:"reason"`
+	Detail      string     `json:"detail"`
+	Payload     string     `json:"payload"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"`
+	Resolution  string     `json:"resolution,omitempty"`
+}
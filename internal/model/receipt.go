@@ -0,0 +1,22 @@
+package model
+
+// Состояние регистрации чека в системе расчёта.
+const (
+	RegistrationPending    = "PENDING"
+	RegistrationRegistered = "REGISTERED"
+	RegistrationFailed     = "FAILED"
+)
+
+// Good — позиция чека. Price в копейках, как и остальные суммы.
+type Good struct {
+	Description string
+	Price       int
+}
+
+// Receipt — чек заказа, который gophermart регистрирует в системе расчёта
+// от имени пользователя. Attempts — сколько попыток регистрации уже не удалось.
+type Receipt struct {
+	Number   string
+	Goods    []Good
+	Attempts int
+}
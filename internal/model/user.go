@@ -1,12 +1,23 @@
 package model
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
+)
 
 type User struct {
 	ID       int     `json:"id"`
 	Login    string  `json:"name"`
 	Password string  `json:"password"`
 	Balance  Balance `json:"balance"`
+
+	// выставляются администратором, см. gophermart admin
+	DisabledAt        *time.Time `json:"-"`
+	SessionsRevokedAt *time.Time `json:"-"`
+}
+
+func (u *User) Disabled() bool {
+	return u.DisabledAt != nil
 }
 
 type Balance struct {
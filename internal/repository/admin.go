@@ -0,0 +1,286 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/model"
+	"github.com/g123udini/gofemart/internal/tracing"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrOrderProcessed    = errors.New("order already processed")
+)
+
+// Методы для gophermart admin. Все изменения идут в транзакции; при dryRun
+// транзакция откатывается, а вызывающий получает результат, который был бы записан.
+
+func (repo *Repo) inTx(ctx context.Context, dryRun bool, fn func(tx *sql.Tx) error) error {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Exec)
+	defer cancel()
+
+	tx, err := repo.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if dryRun {
+		return tx.Rollback()
+	}
+	return tx.Commit()
+}
+
+// CreateUser заводит пользователя с уже захешированным паролем.
+func (repo *Repo) CreateUser(ctx context.Context, login, passwordHash string, dryRun bool) (_ *model.User, err error) {
+	ctx, span := tracing.StartQuery(ctx, "CreateUser")
+	defer func() { tracing.Finish(span, err) }()
+
+	u := model.User{Login: login, Password: passwordHash}
+	err = repo.inTx(ctx, dryRun, func(tx *sql.Tx) error {
+		return tx.QueryRowContext(
+			ctx,
+			`INSERT INTO users (login, password) VALUES ($1, $2) RETURNING id`,
+			login, passwordHash,
+		).Scan(&u.ID)
+	})
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrUniqConstrait
+		}
+		return nil, err
+	}
+	return &u, nil
+}
+
+// DisableUser блокирует пользователя и отзывает все его сессии.
+func (repo *Repo) DisableUser(ctx context.Context, login string, dryRun bool) (_ *model.User, err error) {
+	ctx, span := tracing.StartQuery(ctx, "DisableUser")
+	defer func() { tracing.Finish(span, err) }()
+
+	u := model.User{}
+	err = repo.inTx(ctx, dryRun, func(tx *sql.Tx) error {
+		return tx.QueryRowContext(
+			ctx,
+			`UPDATE users
+			    SET disabled_at = COALESCE(disabled_at, NOW()),
+			        sessions_revoked_at = NOW()
+			  WHERE login = $1
+			RETURNING id, login, password, current, withdrawn, disabled_at, sessions_revoked_at`,
+			login,
+		).Scan(append(u.ScanFields(), &u.DisabledAt, &u.SessionsRevokedAt)...)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// RevokeSessions делает недействительными все сессии пользователя, открытые до этого момента.
+func (repo *Repo) RevokeSessions(ctx context.Context, login string, dryRun bool) (_ time.Time, err error) {
+	ctx, span := tracing.StartQuery(ctx, "RevokeSessions")
+	defer func() { tracing.Finish(span, err) }()
+
+	var at time.Time
+	err = repo.inTx(ctx, dryRun, func(tx *sql.Tx) error {
+		return tx.QueryRowContext(
+			ctx,
+			`UPDATE users SET sessions_revoked_at = NOW() WHERE login = $1 RETURNING sessions_revoked_at`,
+			login,
+		).Scan(&at)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, ErrNotFound
+	}
+	return at, err
+}
+
+// AdjustBalance вручную начисляет (amount > 0) или списывает (amount < 0) баллы
+// и пишет корректировку в balance_adjustments. Уход баланса в минус запрещён.
+func (repo *Repo) AdjustBalance(ctx context.Context, login string, amount int, reason string, dryRun bool) (_ model.Balance, err error) {
+	ctx, span := tracing.StartQuery(ctx, "AdjustBalance")
+	defer func() { tracing.Finish(span, err) }()
+
+	if amount == 0 {
+		return model.Balance{}, errors.New("amount must not be zero")
+	}
+	if strings.TrimSpace(reason) == "" {
+		return model.Balance{}, errors.New("reason is required")
+	}
+
+	var b model.Balance
+	err = repo.inTx(ctx, dryRun, func(tx *sql.Tx) error {
+		var userID int
+		err := tx.QueryRowContext(
+			ctx,
+			`SELECT id, current, withdrawn FROM users WHERE login = $1 FOR UPDATE`,
+			login,
+		).Scan(&userID, &b.Current, &b.Withdrawn)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if b.Current+amount < 0 {
+			return ErrInsufficientFunds
+		}
+		b.Current += amount
+
+		if _, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO balance_adjustments (user_id, amount, reason) VALUES ($1, $2, $3)`,
+			userID, amount, reason,
+		); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE users SET current = $1 WHERE id = $2`, b.Current, userID)
+		return err
+	})
+	if err != nil {
+		return model.Balance{}, err
+	}
+	return b, nil
+}
+
+// RequeueOrder возвращает заказ в статус NEW, чтобы воркер опросил его заново,
+// в том числе из dead letter. Карантин так не снимается: для него есть ResolveQuarantine.
+// Обработанные заказы не трогаем: повторное начисление задвоило бы баланс.
+func (repo *Repo) RequeueOrder(ctx context.Context, number int64, dryRun bool) (_ *model.Order, err error) {
+	ctx, span := tracing.StartQuery(ctx, "RequeueOrder")
+	defer func() { tracing.Finish(span, err) }()
+
+	var order model.Order
+	err = repo.inTx(ctx, dryRun, func(tx *sql.Tx) error {
+		var status string
+		err := tx.QueryRowContext(ctx, `SELECT status FROM orders WHERE number = $1 FOR UPDATE`, number).Scan(&status)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if status == "PROCESSED" {
+			return ErrOrderProcessed
+		}
+
+		return tx.QueryRowContext(
+			ctx,
+			`UPDATE orders
+			    SET status = 'NEW',
+			        attempts = 0,
+			        next_poll_at = now(),
+			        parked = CASE WHEN parked = $2 THEN NULL ELSE parked END,
+			        locked_by = NULL,
+			        locked_until = NULL
+			  WHERE number = $1
+			RETURNING number, status, COALESCE(accural, 0), uploaded_at, user_id`,
+			number, parkedDeadLetter,
+		).Scan(order.ScanFields()...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// ListStuckOrders возвращает незавершённые заказы, загруженные раньше olderThan назад,
+// и все заказы в dead letter независимо от возраста.
+func (repo *Repo) ListStuckOrders(ctx context.Context, olderThan time.Duration, limit int) (_ []model.StuckOrder, err error) {
+	ctx, span := tracing.StartQuery(ctx, "ListStuckOrders")
+	defer func() { tracing.Finish(span, err) }()
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
+	if limit <= 0 {
+		limit = 100
+	}
+
+	rows, err := repo.DB.QueryContext(
+		ctx,
+		`SELECT number, status, user_id, attempts, uploaded_at, next_poll_at, COALESCE(parked, '')
+		   FROM orders
+		  WHERE status NOT IN ('PROCESSED', 'INVALID')
+		    AND (uploaded_at < $1 OR parked = $3)
+		  ORDER BY uploaded_at ASC
+		  LIMIT $2`,
+		time.Now().Add(-olderThan), limit, parkedDeadLetter,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := make([]model.StuckOrder, 0)
+	for rows.Next() {
+		var o model.StuckOrder
+		if err := rows.Scan(o.ScanFields()...); err != nil {
+			return nil, err
+		}
+		orders = append(orders, o)
+	}
+	return orders, rows.Err()
+}
+
+// GetLedger собирает историю движения баллов: начисления по заказам,
+// списания и ручные корректировки, от новых к старым.
+func (repo *Repo) GetLedger(ctx context.Context, userID int, limit int) (_ []model.LedgerEntry, err error) {
+	ctx, span := tracing.StartQuery(ctx, "GetLedger")
+	defer func() { tracing.Finish(span, err) }()
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
+	if limit <= 0 {
+		limit = 100
+	}
+
+	rows, err := repo.queryRead(
+		ctx,
+		userID,
+		fmt.Sprintf(`SELECT kind, reference, amount, at FROM (
+		    SELECT '%s' AS kind, number::text AS reference, accural AS amount, uploaded_at AS at
+		      FROM orders
+		     WHERE user_id = $1 AND status = 'PROCESSED' AND accural > 0
+		    UNION ALL
+		    SELECT '%s', number::text, -sum, processed_at
+		      FROM withdrawals
+		     WHERE user_id = $1
+		    UNION ALL
+		    SELECT CASE WHEN amount > 0 THEN '%s' ELSE '%s' END, reason, amount, created_at
+		      FROM balance_adjustments
+		     WHERE user_id = $1
+		) ledger
+		ORDER BY at DESC
+		LIMIT $2`, model.LedgerAccrual, model.LedgerWithdrawal, model.LedgerCredit, model.LedgerDebit),
+		userID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]model.LedgerEntry, 0)
+	for rows.Next() {
+		var e model.LedgerEntry
+		if err := rows.Scan(&e.Kind, &e.Reference, &e.Amount, &e.At); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/model"
+	"github.com/g123udini/gofemart/internal/tracing"
+)
+
+// parkedDeadLetter — значение orders.parked для заказа, который система расчёта
+// так и не обработала за отведённое время или число опросов.
+const parkedDeadLetter = "DEAD_LETTER"
+
+// Заказы старше $1 мс или опрошенные не меньше $2 раз; нулевой предел не проверяется.
+// Чужие живые аренды не трогаем: тот экземпляр сам решит судьбу заказа.
+const deadLetterOrders = `WITH stuck AS (
+	SELECT number
+	  FROM orders
+	 WHERE status NOT IN ('PROCESSED', 'INVALID')
+	   AND parked IS NULL
+	   AND (($1::float8 > 0 AND uploaded_at < now() - $1::float8 * interval '1 millisecond')
+	     OR ($2::int > 0 AND attempts >= $2::int))
+	   AND (locked_by IS NULL OR locked_by = $3 OR locked_until < now())
+	 ORDER BY uploaded_at ASC
+	 LIMIT $4
+	   FOR UPDATE SKIP LOCKED
+)
+UPDATE orders o
+   SET parked = $5,
+       locked_by = NULL,
+       locked_until = NULL
+  FROM stuck
+ WHERE o.number = stuck.number
+RETURNING o.number, o.status, o.user_id, o.attempts, o.uploaded_at, o.next_poll_at, o.parked`
+
+// DeadLetterOrders снимает с опроса до limit заказов, которые ждут результата дольше
+// maxAge или опрошены maxAttempts раз, и возвращает их. Вернуть заказ в опрос
+// можно через RequeueOrder.
+func (repo *Repo) DeadLetterOrders(ctx context.Context, maxAge time.Duration, maxAttempts, limit int) (_ []model.StuckOrder, err error) {
+	ctx, span := tracing.StartQuery(ctx, "DeadLetterOrders")
+	defer func() { tracing.Finish(span, err) }()
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Exec)
+	defer cancel()
+
+	if limit <= 0 {
+		limit = 100
+	}
+
+	rows, err := repo.DB.QueryContext(ctx, deadLetterOrders,
+		float64(maxAge.Milliseconds()), maxAttempts, repo.Lease.Owner, limit, parkedDeadLetter)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := make([]model.StuckOrder, 0)
+	for rows.Next() {
+		var o model.StuckOrder
+		if err := rows.Scan(o.ScanFields()...); err != nil {
+			return nil, err
+		}
+		orders = append(orders, o)
+	}
+	return orders, rows.Err()
+}
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrLeaseLost — заказ за время опроса перехватил другой экземпляр, результат не записан.
+var ErrLeaseLost = errors.New("order is leased by another instance")
+
+// Lease — аренда незавершённых заказов воркером. Пока аренда жива, другие
+// экземпляры не получают заказ из ListPendingOrders и не могут менять его статус.
+// Если экземпляр упал, заказ освобождается по истечении TTL.
+type Lease struct {
+	// Owner — идентификатор экземпляра. Пустой отключает аренду: один экземпляр на базу.
+	Owner string
+	TTL   time.Duration
+}
+
+// Каждый экземпляр забирает непересекающийся батч: строки, которые уже
+// захватывает параллельная транзакция, SKIP LOCKED просто пропускает.
+const claimPendingOrders = `WITH claimed AS (
+	SELECT number
+	  FROM orders
+	 WHERE status NOT IN ('PROCESSED', 'INVALID')
+	   AND parked IS NULL
+	   AND next_poll_at <= now()
+	   AND (locked_until IS NULL OR locked_until < now())
+	 ORDER BY next_poll_at ASC, uploaded_at ASC
+	 LIMIT $1
+	   FOR UPDATE SKIP LOCKED
+), leased AS (
+	UPDATE orders o
+	   SET locked_by = $2,
+	       locked_until = now() + $3::float8 * interval '1 millisecond'
+	  FROM claimed c
+	 WHERE o.number = c.number
+	RETURNING o.number, o.next_poll_at, o.uploaded_at
+)
+SELECT number FROM leased ORDER BY next_poll_at ASC, uploaded_at ASC`
+
+func (repo *Repo) leaseTTL() time.Duration {
+	if repo.Lease.TTL > 0 {
+		return repo.Lease.TTL
+	}
+	return 30 * time.Second
+}
+
+func (repo *Repo) claimPendingOrders(ctx context.Context, limit int) ([]int64, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Exec)
+	defer cancel()
+
+	args := []any{limit, repo.Lease.Owner, float64(repo.leaseTTL().Milliseconds())}
+	out := make([]int64, 0, limit)
+	if repo.Pool != nil {
+		err := repo.queryPrepared(ctx, stmtClaimPendingOrders, func(rows pgx.Rows) error {
+			for rows.Next() {
+				var n int64
+				if err := rows.Scan(&n); err != nil {
+					return err
+				}
+				out = append(out, n)
+			}
+			return nil
+		}, args...)
+		if err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+
+	rows, err := repo.DB.QueryContext(ctx, claimPendingOrders, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var n int64
+		if err := rows.Scan(&n); err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+	return out, rows.Err()
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// checkLease вызывается, когда обновление статуса не затронуло ни одной строки:
+// отличает уже финальный или несуществующий заказ (не ошибка) от чужой аренды.
+func (repo *Repo) checkLease(ctx context.Context, q queryRower, number int64) error {
+	var leased bool
+	err := q.QueryRowContext(
+		ctx,
+		`SELECT EXISTS (
+			SELECT 1
+			  FROM orders
+			 WHERE number = $1
+			   AND status NOT IN ('PROCESSED', 'INVALID')
+			   AND locked_by <> $2
+			   AND locked_until >= now())`,
+		number, repo.Lease.Owner,
+	).Scan(&leased)
+	if err != nil {
+		return err
+	}
+	if leased {
+		return ErrLeaseLost
+	}
+	return nil
+}
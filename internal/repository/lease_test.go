@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func init() {
+	sql.Register("lease_test_driver", leaseTestDriver{})
+}
+
+// leaseTestDriver запоминает последний запрос; UPDATE не затрагивает строк,
+// а проверка аренды отвечает так, будто заказ держит другой экземпляр.
+type leaseTestDriver struct{}
+
+var leaseTest struct {
+	mu    sync.Mutex
+	query string
+	args  []driver.NamedValue
+}
+
+func (leaseTestDriver) Open(string) (driver.Conn, error) { return &leaseTestConn{}, nil }
+
+type leaseTestConn struct{}
+
+func (c *leaseTestConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *leaseTestConn) Close() error                        { return nil }
+func (c *leaseTestConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c *leaseTestConn) ExecContext(_ context.Context, _ string, _ []driver.NamedValue) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+
+func (c *leaseTestConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	leaseTest.mu.Lock()
+	leaseTest.query, leaseTest.args = query, args
+	leaseTest.mu.Unlock()
+
+	if strings.Contains(query, "SELECT EXISTS") {
+		return &leaseTestRows{cols: []string{"exists"}, vals: [][]driver.Value{{true}}}, nil
+	}
+	return &leaseTestRows{cols: []string{"number"}, vals: [][]driver.Value{{int64(1)}, {int64(2)}}}, nil
+}
+
+type leaseTestRows struct {
+	cols []string
+	vals [][]driver.Value
+}
+
+func (r *leaseTestRows) Columns() []string { return r.cols }
+func (r *leaseTestRows) Close() error      { return nil }
+func (r *leaseTestRows) Next(dest []driver.Value) error {
+	if len(r.vals) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.vals[0])
+	r.vals = r.vals[1:]
+	return nil
+}
+
+func newLeasedRepo(t *testing.T) *Repo {
+	t.Helper()
+	db, err := sql.Open("lease_test_driver", "")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return &Repo{DB: db, Lease: Lease{Owner: "gm-1", TTL: 15 * time.Second}}
+}
+
+func TestLease_ListPendingOrdersClaims(t *testing.T) {
+	repo := newLeasedRepo(t)
+
+	got, err := repo.ListPendingOrders(context.Background(), 50)
+	if err != nil {
+		t.Fatalf("ListPendingOrders: %v", err)
+	}
+	if len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Fatalf("got %v want [1 2]", got)
+	}
+
+	leaseTest.mu.Lock()
+	defer leaseTest.mu.Unlock()
+	if !strings.Contains(leaseTest.query, "FOR UPDATE SKIP LOCKED") {
+		t.Fatalf("claim must skip rows locked by other instances, query:\n%s", leaseTest.query)
+	}
+	if len(leaseTest.args) != 3 || leaseTest.args[1].Value != "gm-1" || leaseTest.args[2].Value != float64(15000) {
+		t.Fatalf("unexpected claim args: %+v", leaseTest.args)
+	}
+}
+
+func TestLease_ForeignLeaseRejectsUpdate(t *testing.T) {
+	repo := newLeasedRepo(t)
+	ctx := context.Background()
+
+	if err := repo.UpdateOrderStatusNonFinal(ctx, 1, "PROCESSING"); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("UpdateOrderStatusNonFinal: want ErrLeaseLost, got %v", err)
+	}
+	if err := repo.MarkOrderInvalidOnce(ctx, 1); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("MarkOrderInvalidOnce: want ErrLeaseLost, got %v", err)
+	}
+}
@@ -0,0 +1,633 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/model"
+)
+
+// Memory — потокобезопасное хранилище в памяти для локальной разработки и тестов.
+// Повторяет ограничения схемы Postgres: уникальные логины и номера заказов/списаний,
+// ссылки на существующего пользователя, идемпотентные финальные статусы.
+type Memory struct {
+	mu sync.RWMutex
+
+	nextUserID  int
+	users       map[int]*model.User
+	loginIndex  map[string]int
+	orders      map[int64]*model.Order
+	withdrawals map[int64]*model.Withdrawal
+	schedule    map[int64]*pollState
+	quarantine  []model.QuarantineEntry
+	receipts    map[int64]*receiptState
+
+	// Backoff — расписание повторных опросов, как у Repo.
+	Backoff PollBackoff
+}
+
+// pollState — аналог колонок attempts, next_poll_at и parked.
+type pollState struct {
+	attempts int
+	next     time.Time
+	parked   string
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		nextUserID:  1,
+		users:       make(map[int]*model.User),
+		loginIndex:  make(map[string]int),
+		orders:      make(map[int64]*model.Order),
+		withdrawals: make(map[int64]*model.Withdrawal),
+		schedule:    make(map[int64]*pollState),
+		receipts:    make(map[int64]*receiptState),
+	}
+}
+
+func (m *Memory) GetUserByLogin(ctx context.Context, login string) (*model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	id, ok := m.loginIndex[login]
+	if !ok {
+		return nil, nil
+	}
+	u := *m.users[id]
+	return &u, nil
+}
+
+func (m *Memory) SaveUser(ctx context.Context, user *model.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.loginIndex[user.Login]; ok {
+		return ErrUniqConstrait
+	}
+
+	// как и INSERT в Repo: баланс и статус берутся из значений по умолчанию
+	u := &model.User{ID: m.nextUserID, Login: user.Login, Password: user.Password}
+	m.nextUserID++
+	m.users[u.ID] = u
+	m.loginIndex[u.Login] = u.ID
+	return nil
+}
+
+func (m *Memory) UpdateUser(ctx context.Context, user *model.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[user.ID]
+	if !ok {
+		return nil
+	}
+	if id, taken := m.loginIndex[user.Login]; taken && id != user.ID {
+		return ErrUniqConstrait
+	}
+
+	delete(m.loginIndex, u.Login)
+	u.Login = user.Login
+	u.Password = user.Password
+	u.Balance = user.Balance
+	m.loginIndex[u.Login] = u.ID
+	return nil
+}
+
+func (m *Memory) GetBalance(ctx context.Context, user *model.User) (model.Balance, error) {
+	if err := ctx.Err(); err != nil {
+		return model.Balance{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.users[user.ID]
+	if !ok {
+		return model.Balance{}, ErrNotFound
+	}
+	return u.Balance, nil
+}
+
+func (m *Memory) GetOrderByNumberUser(ctx context.Context, number string, user *model.User) (*model.Order, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	n, err := parseNumber(number)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	o, ok := m.orders[n]
+	if !ok || o.UserID != user.ID {
+		return nil, nil
+	}
+	cp := *o
+	return &cp, nil
+}
+
+func (m *Memory) GetOrdersByUser(ctx context.Context, user *model.User) ([]model.Order, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	orders := make([]model.Order, 0)
+	for _, o := range m.orders {
+		if o.UserID == user.ID {
+			orders = append(orders, *o)
+		}
+	}
+	sort.SliceStable(orders, func(i, j int) bool {
+		return orders[i].UploadedAt.Before(orders[j].UploadedAt)
+	})
+	return orders, nil
+}
+
+func (m *Memory) SaveOrder(ctx context.Context, order *model.Order) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	n, err := parseNumber(order.Number)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[order.UserID]; !ok {
+		return fmt.Errorf("order %s: user %d does not exist", order.Number, order.UserID)
+	}
+	if _, ok := m.orders[n]; ok {
+		return ErrUniqConstrait
+	}
+
+	o := *order
+	o.Number = strconv.FormatInt(n, 10)
+	if o.UploadedAt.IsZero() {
+		o.UploadedAt = time.Now()
+	}
+	m.orders[n] = &o
+	m.schedule[n] = &pollState{next: time.Now()}
+	return nil
+}
+
+func (m *Memory) GetWithdrawalsByUser(ctx context.Context, user *model.User) ([]model.Withdrawal, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	withdrawals := make([]model.Withdrawal, 0)
+	for _, w := range m.withdrawals {
+		if w.UserID == user.ID {
+			withdrawals = append(withdrawals, *w)
+		}
+	}
+	sort.SliceStable(withdrawals, func(i, j int) bool {
+		return withdrawals[i].ProcessedAt.Before(withdrawals[j].ProcessedAt)
+	})
+	return withdrawals, nil
+}
+
+func (m *Memory) SaveWithdrawal(ctx context.Context, w *model.Withdrawal) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	n, err := parseNumber(w.Number)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[w.UserID]; !ok {
+		return fmt.Errorf("withdrawal %s: user %d does not exist", w.Number, w.UserID)
+	}
+	if _, ok := m.withdrawals[n]; ok {
+		return ErrUniqConstrait
+	}
+
+	// processed_at в Postgres проставляет DEFAULT
+	m.withdrawals[n] = &model.Withdrawal{
+		UserID:      w.UserID,
+		Number:      strconv.FormatInt(n, 10),
+		Sum:         w.Sum,
+		ProcessedAt: time.Now(),
+	}
+	return nil
+}
+
+func (m *Memory) ListPendingOrders(ctx context.Context, limit int) ([]int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = 100
+	}
+
+	type due struct {
+		number   int64
+		next     time.Time
+		uploaded time.Time
+	}
+
+	now := time.Now()
+	m.mu.RLock()
+	pending := make([]due, 0)
+	for n, o := range m.orders {
+		if isFinalStatus(o.Status) {
+			continue
+		}
+		if st := m.schedule[n]; st.parked == "" && !st.next.After(now) {
+			pending = append(pending, due{n, st.next, o.UploadedAt})
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(pending, func(i, j int) bool {
+		if !pending[i].next.Equal(pending[j].next) {
+			return pending[i].next.Before(pending[j].next)
+		}
+		return pending[i].uploaded.Before(pending[j].uploaded)
+	})
+	if len(pending) > limit {
+		pending = pending[:limit]
+	}
+
+	out := make([]int64, 0, len(pending))
+	for _, d := range pending {
+		out = append(out, d.number)
+	}
+	return out, nil
+}
+
+func (m *Memory) CountPendingOrders(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	n := 0
+	for num, o := range m.orders {
+		if !isFinalStatus(o.Status) && m.schedule[num].parked == "" {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *Memory) ApplyOrderProcessedOnce(ctx context.Context, number int64, accural int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o, ok := m.orders[number]
+	if !ok || isFinalStatus(o.Status) || m.schedule[number].parked != "" {
+		return nil
+	}
+	o.Status = "PROCESSED"
+	o.Accrual = int(accural)
+	if u, ok := m.users[o.UserID]; ok {
+		u.Balance.Current += int(accural)
+	}
+	return nil
+}
+
+func (m *Memory) MarkOrderInvalidOnce(ctx context.Context, number int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if o, ok := m.orders[number]; ok && !isFinalStatus(o.Status) && m.schedule[number].parked == "" {
+		o.Status = "INVALID"
+	}
+	return nil
+}
+
+func (m *Memory) UpdateOrderStatusNonFinal(ctx context.Context, number int64, status string) error {
+	if status == "" {
+		return fmt.Errorf("empty status")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if o, ok := m.orders[number]; ok && !isFinalStatus(o.Status) {
+		o.Status = status
+		m.postpone(number)
+	}
+	return nil
+}
+
+func (m *Memory) SetOrderStatusNonFinal(ctx context.Context, number int64, status string) error {
+	if status == "" {
+		return fmt.Errorf("empty status")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o, ok := m.orders[number]
+	if !ok || isFinalStatus(o.Status) || m.schedule[number].parked != "" {
+		return nil
+	}
+	if o.Status == "PROCESSING" && status == "REGISTERED" {
+		return nil
+	}
+	o.Status = status
+	return nil
+}
+
+func (m *Memory) GetOrderParked(ctx context.Context, number int64) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.orders[number]; !ok {
+		return "", ErrNotFound
+	}
+	return m.schedule[number].parked, nil
+}
+
+func (m *Memory) PostponeOrder(ctx context.Context, number int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if o, ok := m.orders[number]; ok && !isFinalStatus(o.Status) {
+		m.postpone(number)
+	}
+	return nil
+}
+
+func (m *Memory) QuarantineOrder(ctx context.Context, number int64, reason, detail, payload string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o, ok := m.orders[number]
+	if !ok || isFinalStatus(o.Status) || m.schedule[number].parked != "" {
+		return nil
+	}
+	m.schedule[number].parked = parkedQuarantine
+	m.quarantine = append(m.quarantine, model.QuarantineEntry{
+		ID:          int64(len(m.quarantine) + 1),
+		OrderNumber: o.Number,
+		Reason:      reason,
+		Detail:      detail,
+		Payload:     payload,
+		CreatedAt:   time.Now(),
+	})
+	return nil
+}
+
+func (m *Memory) DeadLetterOrders(ctx context.Context, maxAge time.Duration, maxAttempts, limit int) ([]model.StuckOrder, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = 100
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	stuck := make([]model.StuckOrder, 0)
+	for n, o := range m.orders {
+		st := m.schedule[n]
+		if isFinalStatus(o.Status) || st.parked != "" {
+			continue
+		}
+		tooOld := maxAge > 0 && o.UploadedAt.Before(now.Add(-maxAge))
+		tooMany := maxAttempts > 0 && st.attempts >= maxAttempts
+		if !tooOld && !tooMany {
+			continue
+		}
+		stuck = append(stuck, model.StuckOrder{
+			Number:     o.Number,
+			Status:     o.Status,
+			UserID:     o.UserID,
+			Attempts:   st.attempts,
+			UploadedAt: o.UploadedAt,
+			NextPollAt: st.next,
+			Parked:     parkedDeadLetter,
+		})
+	}
+
+	sort.Slice(stuck, func(i, j int) bool {
+		return stuck[i].UploadedAt.Before(stuck[j].UploadedAt)
+	})
+	if len(stuck) > limit {
+		stuck = stuck[:limit]
+	}
+	for _, o := range stuck {
+		n, _ := parseNumber(o.Number)
+		m.schedule[n].parked = parkedDeadLetter
+	}
+	return stuck, nil
+}
+
+// receiptState — аналог строки order_receipts с позициями из order_goods.
+type receiptState struct {
+	goods    []model.Good
+	status   string
+	attempts int
+	next     time.Time
+	lastErr  string
+}
+
+// registrationHold — на сколько выданный чек скрывается от следующих выборок.
+const registrationHold = 30 * time.Second
+
+func (m *Memory) SaveOrderReceipt(ctx context.Context, order *model.Order, goods []model.Good) error {
+	if err := m.SaveOrder(ctx, order); err != nil {
+		return err
+	}
+	return m.AddOrderReceipt(ctx, order.Number, goods)
+}
+
+func (m *Memory) AddOrderReceipt(ctx context.Context, number string, goods []model.Good) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	n, err := parseNumber(number)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.orders[n]
+	if !ok {
+		return ErrNotFound
+	}
+	if _, ok := m.receipts[n]; ok {
+		return ErrUniqConstrait
+	}
+	if isFinalStatus(o.Status) {
+		return ErrOrderFinal
+	}
+	m.receipts[n] = &receiptState{
+		goods:  append([]model.Good(nil), goods...),
+		status: model.RegistrationPending,
+		next:   time.Now(),
+	}
+	m.repoll(n)
+	return nil
+}
+
+func (m *Memory) ClaimPendingRegistrations(ctx context.Context, limit int) ([]model.Receipt, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = 100
+	}
+
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	due := make([]int64, 0)
+	for n, r := range m.receipts {
+		if r.status == model.RegistrationPending && !r.next.After(now) {
+			due = append(due, n)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return m.receipts[due[i]].next.Before(m.receipts[due[j]].next)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	out := make([]model.Receipt, 0, len(due))
+	for _, n := range due {
+		r := m.receipts[n]
+		r.next = now.Add(registrationHold)
+		out = append(out, model.Receipt{
+			Number:   strconv.FormatInt(n, 10),
+			Goods:    append([]model.Good(nil), r.goods...),
+			Attempts: r.attempts,
+		})
+	}
+	return out, nil
+}
+
+func (m *Memory) MarkRegistered(ctx context.Context, number int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if r, ok := m.receipts[number]; ok && r.status == model.RegistrationPending {
+		r.status = model.RegistrationRegistered
+		r.lastErr = ""
+		m.repoll(number)
+	}
+	return nil
+}
+
+func (m *Memory) MarkRegistrationFailed(ctx context.Context, number int64, reason string, final bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.receipts[number]
+	if !ok || r.status != model.RegistrationPending {
+		return nil
+	}
+	if final {
+		r.status = model.RegistrationFailed
+	}
+	r.next = time.Now().Add(m.Backoff.delay(r.attempts))
+	r.attempts++
+	r.lastErr = reason
+	return nil
+}
+
+// postpone вызывается под m.mu.
+func (m *Memory) postpone(number int64) {
+	st := m.schedule[number]
+	st.next = time.Now().Add(m.Backoff.delay(st.attempts))
+	st.attempts++
+}
+
+// repoll — аналог repollSet, вызывается под m.mu.
+func (m *Memory) repoll(number int64) {
+	o, ok := m.orders[number]
+	if !ok || isFinalStatus(o.Status) {
+		return
+	}
+	st := m.schedule[number]
+	st.attempts = 0
+	st.next = time.Now()
+	if st.parked == parkedDeadLetter {
+		st.parked = ""
+	}
+}
+
+func isFinalStatus(status string) bool {
+	return status == "PROCESSED" || status == "INVALID"
+}
+
+// parseNumber повторяет приведение номера к BIGINT в Postgres.
+func parseNumber(number string) (int64, error) {
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q: %w", number, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,14 @@
+package repository_test
+
+import (
+	"testing"
+
+	"github.com/g123udini/gofemart/internal/repository"
+	"github.com/g123udini/gofemart/internal/repository/storagetest"
+)
+
+func TestMemory_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) repository.Storage {
+		return repository.NewMemory()
+	})
+}
@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/service"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"go.uber.org/zap"
+)
+
+// PoolConfig — настройки pgxpool. Нулевые поля оставляют значения pgx по умолчанию.
+type PoolConfig struct {
+	MaxConns          int32
+	MinConns          int32
+	MaxConnLifetime   time.Duration
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
+
+	// ConnectAttempts — сколько раз пинговать базу при старте, ConnectRetryDelay — пауза между попытками.
+	ConnectAttempts   int
+	ConnectRetryDelay time.Duration
+}
+
+// Горячие запросы готовятся один раз на соединение под постоянным именем,
+// дальше pgx шлёт только Bind/Execute без повторного разбора SQL.
+type preparedQuery struct {
+	name string
+	sql  string
+}
+
+var (
+	stmtGetUserByLogin = preparedQuery{
+		name: "get_user_by_login",
+		sql: `SELECT id, login, password, current, withdrawn, disabled_at, sessions_revoked_at
+		 FROM users
+		 WHERE login = $1`,
+	}
+	stmtClaimPendingOrders = preparedQuery{
+		name: "claim_pending_orders",
+		sql:  claimPendingOrders,
+	}
+)
+
+// NewPoolRepository открывает pgxpool и дожидается ответа базы.
+// Repo.DB работает поверх того же пула, так что миграции, транзакции и метрики
+// database/sql продолжают работать, а горячие запросы идут напрямую через pgx.
+func NewPoolRepository(ctx context.Context, dsn string, cfg PoolConfig, logger *zap.SugaredLogger) (*Repo, error) {
+	if !isValidDSN(dsn) {
+		return nil, errors.New("invalid DSN")
+	}
+
+	pcfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxConns > 0 {
+		pcfg.MaxConns = cfg.MaxConns
+	}
+	if cfg.MinConns > 0 {
+		pcfg.MinConns = cfg.MinConns
+	}
+	if cfg.MaxConnLifetime > 0 {
+		pcfg.MaxConnLifetime = cfg.MaxConnLifetime
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		pcfg.MaxConnIdleTime = cfg.MaxConnIdleTime
+	}
+	if cfg.HealthCheckPeriod > 0 {
+		pcfg.HealthCheckPeriod = cfg.HealthCheckPeriod
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, pcfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := pingWithRetry(ctx, pool, cfg.ConnectAttempts, cfg.ConnectRetryDelay, logger); err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	db := stdlib.OpenDBFromPool(pool)
+	if cfg.MaxConns > 0 {
+		db.SetMaxOpenConns(int(cfg.MaxConns))
+	}
+
+	return &Repo{DB: db, Pool: pool, Logger: logger}, nil
+}
+
+func pingWithRetry(ctx context.Context, pool *pgxpool.Pool, attempts int, delay time.Duration, logger *zap.SugaredLogger) error {
+	p := service.RetryPolicy{
+		Op:          "db.ping",
+		MaxAttempts: max(attempts, 1),
+		BaseDelay:   delay,
+		Multiplier:  1,
+		Jitter:      service.NoJitter,
+		// база при старте ещё поднимается: повторяем любую ошибку
+		Classifiers: []service.Classifier{func(error) (bool, time.Duration) { return true, 0 }},
+		Hooks: service.RetryHooks{OnRetry: func(_ string, attempt int, _ time.Duration, err error) {
+			if logger != nil {
+				logger.Warnw("database is not ready, retrying", "attempt", attempt, "attempts", attempts, "error", err)
+			}
+		}},
+	}
+	if err := p.Do(ctx, pool.Ping); err != nil {
+		return fmt.Errorf("ping database: %w", err)
+	}
+	return nil
+}
+
+// Close закрывает database/sql, пул pgx и реплику, если они есть.
+func (repo *Repo) Close() error {
+	err := repo.DB.Close()
+	if repo.Pool != nil {
+		repo.Pool.Close()
+	}
+	if repo.Replica != nil {
+		err = errors.Join(err, repo.Replica.DB.Close())
+	}
+	return err
+}
+
+// queryPrepared выполняет подготовленный на соединении запрос. Prepare с тем же
+// именем и текстом pgx отдаёт из кэша соединения без обращения к серверу.
+func (repo *Repo) queryPrepared(ctx context.Context, q preparedQuery, fn func(pgx.Rows) error, args ...any) error {
+	return repo.Pool.AcquireFunc(ctx, func(c *pgxpool.Conn) error {
+		if _, err := c.Conn().Prepare(ctx, q.name, q.sql); err != nil {
+			return err
+		}
+		rows, err := c.Query(ctx, q.name, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		if err := fn(rows); err != nil {
+			return err
+		}
+		return rows.Err()
+	})
+}
@@ -0,0 +1,132 @@
+package repository_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/model"
+	"github.com/g123udini/gofemart/internal/repository"
+)
+
+func TestNewPoolRepository_InvalidDSN(t *testing.T) {
+	_, err := repository.NewPoolRepository(context.Background(), "not a dsn", repository.PoolConfig{}, nil)
+	if err == nil {
+		t.Fatalf("expected error for invalid DSN")
+	}
+}
+
+func TestNewPoolRepository_PingRetries(t *testing.T) {
+	// на порту 1 никто не слушает — каждая попытка сразу получает отказ
+	_, err := repository.NewPoolRepository(context.Background(), "postgres://u:p@127.0.0.1:1/db?connect_timeout=1", repository.PoolConfig{
+		ConnectAttempts:   3,
+		ConnectRetryDelay: time.Millisecond,
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Fatalf("err=%v want ping failure after 3 attempts", err)
+	}
+}
+
+func TestNewPoolRepository_PingStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := repository.NewPoolRepository(ctx, "postgres://u:p@127.0.0.1:1/db?connect_timeout=1", repository.PoolConfig{
+		ConnectAttempts:   100,
+		ConnectRetryDelay: time.Second,
+	}, nil)
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("ping retry ignored ctx cancellation, took %v", d)
+	}
+}
+
+// Бенчмарки сравнивают прежний database/sql и pgxpool на горячих запросах:
+//
+//	TEST_DATABASE_URI=postgres://... go test -run '^$' -bench . ./internal/repository/
+func BenchmarkGetUserByLogin(b *testing.B) {
+	for name, repo := range benchRepos(b) {
+		b.Run(name, func(b *testing.B) {
+			ctx := context.Background()
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					u, err := repo.GetUserByLogin(ctx, "bench-user-7")
+					if err != nil || u == nil {
+						b.Fatalf("GetUserByLogin: %v, %v", u, err)
+					}
+				}
+			})
+		})
+	}
+}
+
+// В проде у экземпляра всегда есть владелец аренды, поэтому меряем выборку с арендой.
+// Короткий TTL возвращает заказы в очередь к следующей итерации.
+func BenchmarkListPendingOrders(b *testing.B) {
+	for name, repo := range benchRepos(b) {
+		repo.Lease = repository.Lease{Owner: "bench-" + name, TTL: time.Millisecond}
+		b.Run(name, func(b *testing.B) {
+			ctx := context.Background()
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := repo.ListPendingOrders(ctx, 100); err != nil {
+						b.Fatalf("ListPendingOrders: %v", err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func benchRepos(b *testing.B) map[string]*repository.Repo {
+	b.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URI")
+	if dsn == "" {
+		b.Skip("TEST_DATABASE_URI is not set")
+	}
+
+	ctx := context.Background()
+	migrateUp(b, dsn)
+
+	stdlibRepo, err := repository.NewRepository(dsn)
+	if err != nil {
+		b.Fatalf("open repository: %v", err)
+	}
+	stdlibRepo.DB.SetMaxOpenConns(16)
+	b.Cleanup(func() { _ = stdlibRepo.Close() })
+
+	poolRepo, err := repository.NewPoolRepository(ctx, dsn, repository.PoolConfig{MaxConns: 16}, nil)
+	if err != nil {
+		b.Fatalf("open pool repository: %v", err)
+	}
+	b.Cleanup(func() { _ = poolRepo.Close() })
+
+	if _, err := stdlibRepo.DB.Exec(`TRUNCATE balance_adjustments, withdrawals, orders, users RESTART IDENTITY CASCADE`); err != nil {
+		b.Fatalf("truncate: %v", err)
+	}
+	for i := 0; i < 50; i++ {
+		if err := stdlibRepo.SaveUser(ctx, &model.User{Login: fmt.Sprintf("bench-user-%d", i), Password: "hash"}); err != nil {
+			b.Fatalf("save user: %v", err)
+		}
+	}
+	u, err := stdlibRepo.GetUserByLogin(ctx, "bench-user-0")
+	if err != nil || u == nil {
+		b.Fatalf("get user: %v", err)
+	}
+	for i := 0; i < 200; i++ {
+		o := &model.Order{Number: fmt.Sprint(1000 + i), Status: "NEW", UserID: u.ID, UploadedAt: time.Now()}
+		if err := stdlibRepo.SaveOrder(ctx, o); err != nil {
+			b.Fatalf("save order: %v", err)
+		}
+	}
+
+	return map[string]*repository.Repo{"stdlib": stdlibRepo, "pgxpool": poolRepo}
+}
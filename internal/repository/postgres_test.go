@@ -0,0 +1,324 @@
+package repository_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/model"
+	"github.com/g123udini/gofemart/internal/repository"
+	"github.com/g123udini/gofemart/internal/repository/storagetest"
+	"github.com/g123udini/gofemart/migrations"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// Набор тестов против настоящего Postgres запускается, только если задан
+// TEST_DATABASE_URI. Тесты накатывают миграции и очищают таблицы — не направляйте их на рабочую базу.
+func TestPostgres_Conformance(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URI")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+
+	repo, err := repository.NewRepository(dsn)
+	if err != nil {
+		t.Fatalf("open repository: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.DB.Close() })
+
+	migrateUp(t, dsn)
+
+	storagetest.Run(t, func(t *testing.T) repository.Storage {
+		_, err := repo.DB.Exec(`TRUNCATE balance_adjustments, withdrawals, orders, users RESTART IDENTITY CASCADE`)
+		if err != nil {
+			t.Fatalf("truncate: %v", err)
+		}
+		return repo
+	})
+}
+
+func TestPostgresPool_Conformance(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URI")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+
+	migrateUp(t, dsn)
+
+	repo, err := repository.NewPoolRepository(context.Background(), dsn, repository.PoolConfig{MaxConns: 8}, nil)
+	if err != nil {
+		t.Fatalf("open pool repository: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+
+	storagetest.Run(t, func(t *testing.T) repository.Storage {
+		_, err := repo.DB.Exec(`TRUNCATE balance_adjustments, withdrawals, orders, users RESTART IDENTITY CASCADE`)
+		if err != nil {
+			t.Fatalf("truncate: %v", err)
+		}
+		return repo
+	})
+}
+
+// Два экземпляра на одной базе забирают непересекающиеся батчи, а статус
+// меняет только владелец живой аренды.
+func TestPostgres_Leases(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URI")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+	migrateUp(t, dsn)
+
+	open := func(owner string, ttl time.Duration) *repository.Repo {
+		repo, err := repository.NewRepository(dsn)
+		if err != nil {
+			t.Fatalf("open repository: %v", err)
+		}
+		t.Cleanup(func() { _ = repo.DB.Close() })
+		repo.Lease = repository.Lease{Owner: owner, TTL: ttl}
+		return repo
+	}
+	a := open("a", time.Minute)
+	b := open("b", time.Minute)
+
+	if _, err := a.DB.Exec(`TRUNCATE balance_adjustments, withdrawals, orders, users RESTART IDENTITY CASCADE`); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	ctx := context.Background()
+	if err := a.SaveUser(ctx, &model.User{Login: "alice", Password: "hash"}); err != nil {
+		t.Fatalf("SaveUser: %v", err)
+	}
+	u, _ := a.GetUserByLogin(ctx, "alice")
+	base := time.Now().Add(-time.Hour)
+	for i := 1; i <= 10; i++ {
+		o := &model.Order{Number: strconv.Itoa(i), Status: "NEW", UploadedAt: base.Add(time.Duration(i) * time.Minute), UserID: u.ID}
+		if err := a.SaveOrder(ctx, o); err != nil {
+			t.Fatalf("SaveOrder: %v", err)
+		}
+	}
+
+	var (
+		wg     sync.WaitGroup
+		ba, bb []int64
+		ea, eb error
+	)
+	wg.Add(2)
+	go func() { defer wg.Done(); ba, ea = a.ListPendingOrders(ctx, 6) }()
+	go func() { defer wg.Done(); bb, eb = b.ListPendingOrders(ctx, 6) }()
+	wg.Wait()
+	if ea != nil || eb != nil {
+		t.Fatalf("claim: %v, %v", ea, eb)
+	}
+	seen := make(map[int64]bool)
+	for _, n := range append(ba, bb...) {
+		if seen[n] {
+			t.Fatalf("order %d claimed by both instances: a=%v b=%v", n, ba, bb)
+		}
+		seen[n] = true
+	}
+	if len(seen) != 10 {
+		t.Fatalf("expected all 10 orders claimed, a=%v b=%v", ba, bb)
+	}
+
+	// заказ из батча a может обновить только a
+	n := ba[0]
+	if err := b.UpdateOrderStatusNonFinal(ctx, n, "PROCESSING"); !errors.Is(err, repository.ErrLeaseLost) {
+		t.Fatalf("foreign update: want ErrLeaseLost, got %v", err)
+	}
+	if err := b.ApplyOrderProcessedOnce(ctx, n, 100); !errors.Is(err, repository.ErrLeaseLost) {
+		t.Fatalf("foreign apply: want ErrLeaseLost, got %v", err)
+	}
+	if err := a.ApplyOrderProcessedOnce(ctx, n, 100); err != nil {
+		t.Fatalf("owner apply: %v", err)
+	}
+	if bal, _ := a.GetBalance(ctx, u); bal.Current != 100 {
+		t.Fatalf("current=%d want 100", bal.Current)
+	}
+
+	// аренда упавшего экземпляра истекает, и заказы достаются другому
+	if _, err := a.DB.Exec(`UPDATE orders SET locked_until = now() - interval '1 second' WHERE locked_by = 'b'`); err != nil {
+		t.Fatalf("expire leases: %v", err)
+	}
+	again, err := a.ListPendingOrders(ctx, 10)
+	if err != nil {
+		t.Fatalf("reclaim: %v", err)
+	}
+	if len(again) != len(bb) {
+		t.Fatalf("expected %d expired orders reclaimed, got %v", len(bb), again)
+	}
+}
+
+func TestPostgres_QuarantineResolve(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URI")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+	migrateUp(t, dsn)
+
+	repo, err := repository.NewRepository(dsn)
+	if err != nil {
+		t.Fatalf("open repository: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.DB.Close() })
+	if _, err := repo.DB.Exec(`TRUNCATE accrual_quarantine, balance_adjustments, withdrawals, orders, users RESTART IDENTITY CASCADE`); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+
+	ctx := context.Background()
+	_ = repo.SaveUser(ctx, &model.User{Login: "alice", Password: "hash"})
+	u, _ := repo.GetUserByLogin(ctx, "alice")
+	for _, n := range []int64{1, 2} {
+		o := &model.Order{Number: strconv.FormatInt(n, 10), Status: "NEW", UploadedAt: time.Now(), UserID: u.ID}
+		if err := repo.SaveOrder(ctx, o); err != nil {
+			t.Fatalf("SaveOrder: %v", err)
+		}
+		// NUL в теле ответа не должен ломать запись
+		if err := repo.QuarantineOrder(ctx, n, "unknown_status", "", "{\"status\":\"X\x00\"}"); err != nil {
+			t.Fatalf("QuarantineOrder: %v", err)
+		}
+	}
+
+	entries, err := repo.ListQuarantine(ctx, 10)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("ListQuarantine=%v, %v", entries, err)
+	}
+
+	if _, err := repo.ResolveQuarantine(ctx, 1, model.QuarantineReleased, true); err != nil {
+		t.Fatalf("dry-run release: %v", err)
+	}
+	if pending, _ := repo.ListPendingOrders(ctx, 10); len(pending) != 0 {
+		t.Fatalf("dry run must not release, pending=%v", pending)
+	}
+
+	o, err := repo.ResolveQuarantine(ctx, 1, model.QuarantineReleased, false)
+	if err != nil || o.Status != "NEW" {
+		t.Fatalf("release=%+v, %v", o, err)
+	}
+	o, err = repo.ResolveQuarantine(ctx, 2, model.QuarantineRejected, false)
+	if err != nil || o.Status != "INVALID" {
+		t.Fatalf("reject=%+v, %v", o, err)
+	}
+	if pending, _ := repo.ListPendingOrders(ctx, 10); len(pending) != 1 || pending[0] != 1 {
+		t.Fatalf("pending=%v want [1]", pending)
+	}
+	if _, err := repo.ResolveQuarantine(ctx, 1, model.QuarantineRejected, false); !errors.Is(err, repository.ErrNotQuarantined) {
+		t.Fatalf("second resolve: want ErrNotQuarantined, got %v", err)
+	}
+}
+
+func TestPostgres_DeadLetterRequeue(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URI")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+	migrateUp(t, dsn)
+
+	repo, err := repository.NewRepository(dsn)
+	if err != nil {
+		t.Fatalf("open repository: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.DB.Close() })
+	if _, err := repo.DB.Exec(`TRUNCATE accrual_quarantine, balance_adjustments, withdrawals, orders, users RESTART IDENTITY CASCADE`); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+
+	ctx := context.Background()
+	_ = repo.SaveUser(ctx, &model.User{Login: "alice", Password: "hash"})
+	u, _ := repo.GetUserByLogin(ctx, "alice")
+	o := &model.Order{Number: "1", Status: "NEW", UploadedAt: time.Now().Add(-2 * time.Hour), UserID: u.ID}
+	if err := repo.SaveOrder(ctx, o); err != nil {
+		t.Fatalf("SaveOrder: %v", err)
+	}
+
+	dead, err := repo.DeadLetterOrders(ctx, time.Hour, 0, 10)
+	if err != nil || len(dead) != 1 || dead[0].Parked != "DEAD_LETTER" {
+		t.Fatalf("DeadLetterOrders=%+v, %v", dead, err)
+	}
+
+	// dead letter виден в списке зависших при любом пороге возраста
+	stuck, err := repo.ListStuckOrders(ctx, 24*time.Hour, 10)
+	if err != nil || len(stuck) != 1 || stuck[0].Parked != "DEAD_LETTER" {
+		t.Fatalf("ListStuckOrders=%+v, %v", stuck, err)
+	}
+
+	if _, err := repo.RequeueOrder(ctx, 1, false); err != nil {
+		t.Fatalf("RequeueOrder: %v", err)
+	}
+	if pending, _ := repo.ListPendingOrders(ctx, 10); len(pending) != 1 || pending[0] != 1 {
+		t.Fatalf("pending=%v want [1]: requeue must take the order out of dead letter", pending)
+	}
+}
+
+// Время отзыва сессий не зависит от TimeZone базы: его сравнивают с временем из Go.
+func TestPostgres_RevokeSessionsTimeZone(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URI")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+	migrateUp(t, dsn)
+
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	repo, err := repository.NewRepository(dsn + sep + "timezone=Asia/Tokyo")
+	if err != nil {
+		t.Fatalf("open repository: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.DB.Close() })
+	if _, err := repo.DB.Exec(`TRUNCATE balance_adjustments, withdrawals, orders, users RESTART IDENTITY CASCADE`); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := repo.SaveUser(ctx, &model.User{Login: "alice", Password: "x"}); err != nil {
+		t.Fatalf("SaveUser: %v", err)
+	}
+	before := time.Now()
+	if _, err := repo.RevokeSessions(ctx, "alice", false); err != nil {
+		t.Fatalf("RevokeSessions: %v", err)
+	}
+
+	u, err := repo.GetUserByLogin(ctx, "alice")
+	if err != nil || u == nil || u.SessionsRevokedAt == nil {
+		t.Fatalf("GetUserByLogin: %+v, %v", u, err)
+	}
+	if d := u.SessionsRevokedAt.Sub(before); d < -time.Minute || d > time.Minute {
+		t.Fatalf("sessions_revoked_at=%s is %s away from now", u.SessionsRevokedAt, d)
+	}
+}
+
+func migrateUp(t testing.TB, dsn string) {
+	t.Helper()
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	src, err := iofs.New(migrations.FS, ".")
+	if err != nil {
+		t.Fatalf("migrate source: %v", err)
+	}
+	driver, err := postgres.WithInstance(db, &postgres.Config{})
+	if err != nil {
+		t.Fatalf("postgres driver: %v", err)
+	}
+	m, err := migrate.NewWithInstance("iofs", src, "postgres", driver)
+	if err != nil {
+		t.Fatalf("migrate init: %v", err)
+	}
+	defer func() { _, _ = m.Close() }()
+
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		t.Fatalf("migrate up: %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/g123udini/gofemart/internal/model"
+	"github.com/g123udini/gofemart/internal/tracing"
+)
+
+// ErrNotQuarantined — у заказа нет неразобранной записи в карантине.
+var ErrNotQuarantined = errors.New("order is not quarantined")
+
+// parkedQuarantine — значение orders.parked для заказа в карантине.
+const parkedQuarantine = "QUARANTINED"
+
+// QuarantineOrder снимает заказ с опроса и сохраняет аномальный ответ для разбора.
+func (repo *Repo) QuarantineOrder(ctx context.Context, number int64, reason, detail, payload string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "QuarantineOrder")
+	defer func() { tracing.Finish(span, err) }()
+
+	return repo.inTx(ctx, false, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(
+			ctx,
+			`UPDATE orders
+			    SET parked = $3,
+			        locked_by = NULL,
+			        locked_until = NULL
+			  WHERE number = $1
+			    AND status NOT IN ('PROCESSED', 'INVALID')
+			    AND parked IS NULL
+			    AND (locked_by IS NULL OR locked_by = $2 OR locked_until < now())`,
+			number, repo.Lease.Owner, parkedQuarantine,
+		)
+		if err != nil {
+			return err
+		}
+		if affected, _ := res.RowsAffected(); affected == 0 {
+			return repo.checkLease(ctx, tx, number)
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			`INSERT INTO accrual_quarantine (order_number, reason, detail, payload) VALUES ($1, $2, $3, $4)`,
+			number, reason, detail, sanitizePayload(payload),
+		)
+		return err
+	})
+}
+
+// ListQuarantine возвращает неразобранные записи карантина, старые первыми.
+func (repo *Repo) ListQuarantine(ctx context.Context, limit int) (_ []model.QuarantineEntry, err error) {
+	ctx, span := tracing.StartQuery(ctx, "ListQuarantine")
+	defer func() { tracing.Finish(span, err) }()
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
+	if limit <= 0 {
+		limit = 100
+	}
+
+	rows, err := repo.DB.QueryContext(
+		ctx,
+		`SELECT id, order_number, reason, detail, payload, created_at
+		   FROM accrual_quarantine
+		  WHERE resolved_at IS NULL
+		  ORDER BY created_at ASC
+		  LIMIT $1`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]model.QuarantineEntry, 0)
+	for rows.Next() {
+		var (
+			e      model.QuarantineEntry
+			number int64
+		)
+		if err := rows.Scan(&e.ID, &number, &e.Reason, &e.Detail, &e.Payload, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.OrderNumber = strconv.FormatInt(number, 10)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// ResolveQuarantine закрывает карантин заказа. released возвращает заказ в опрос,
+// rejected переводит его в INVALID без начисления.
+func (repo *Repo) ResolveQuarantine(ctx context.Context, number int64, resolution string, dryRun bool) (_ *model.Order, err error) {
+	ctx, span := tracing.StartQuery(ctx, "ResolveQuarantine")
+	defer func() { tracing.Finish(span, err) }()
+
+	var update string
+	switch resolution {
+	case model.QuarantineReleased:
+		update = `UPDATE orders
+		    SET parked = NULL,
+		        attempts = 0,
+		        next_poll_at = now()
+		  WHERE number = $1
+		RETURNING number, status, COALESCE(accural, 0), uploaded_at, user_id`
+	case model.QuarantineRejected:
+		update = `UPDATE orders
+		    SET parked = NULL,
+		        status = CASE WHEN status IN ('PROCESSED', 'INVALID') THEN status ELSE 'INVALID' END
+		  WHERE number = $1
+		RETURNING number, status, COALESCE(accural, 0), uploaded_at, user_id`
+	default:
+		return nil, fmt.Errorf("unknown quarantine resolution %q", resolution)
+	}
+
+	var order model.Order
+	err = repo.inTx(ctx, dryRun, func(tx *sql.Tx) error {
+		var id int64
+		err := tx.QueryRowContext(
+			ctx,
+			`SELECT id FROM accrual_quarantine WHERE order_number = $1 AND resolved_at IS NULL FOR UPDATE`,
+			number,
+		).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotQuarantined
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			`UPDATE accrual_quarantine SET resolved_at = now(), resolution = $2 WHERE id = $1`,
+			id, resolution,
+		)
+		if err != nil {
+			return err
+		}
+		return tx.QueryRowContext(ctx, update, number).Scan(order.ScanFields()...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// sanitizePayload: в TEXT не пишутся NUL и невалидный UTF-8, а тело ответа может содержать что угодно.
+func sanitizePayload(s string) string {
+	return strings.ReplaceAll(strings.ToValidUTF8(s, "\uFFFD"), "\x00", "\uFFFD")
+}
@@ -0,0 +1,218 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+
+	"github.com/g123udini/gofemart/internal/model"
+	"github.com/g123udini/gofemart/internal/tracing"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// SaveOrderReceipt сохраняет заказ вместе с чеком; чек ждёт регистрации в системе расчёта.
+// Повторный номер заказа — ErrUniqConstrait, как у SaveOrder.
+func (repo *Repo) SaveOrderReceipt(ctx context.Context, order *model.Order, goods []model.Good) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "SaveOrderReceipt")
+	defer func() { tracing.Finish(span, err) }()
+	defer repo.noteWrite(order.UserID)
+
+	err = repo.inTx(ctx, false, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO orders (number, status, accural, uploaded_at, user_id) VALUES ($1, $2, $3, $4, $5)`,
+			order.Number, order.Status, order.Accrual, order.UploadedAt, order.UserID,
+		); err != nil {
+			return err
+		}
+		return insertReceipt(ctx, tx, order.Number, goods)
+	})
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrUniqConstrait
+	}
+	return err
+}
+
+// AddOrderReceipt прикладывает чек к уже загруженному заказу, у которого чека нет,
+// и возвращает заказ в опрос с нуля. Если чек уже есть — ErrUniqConstrait,
+// если заказ уже рассчитан — ErrOrderFinal.
+func (repo *Repo) AddOrderReceipt(ctx context.Context, number string, goods []model.Good) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "AddOrderReceipt")
+	defer func() { tracing.Finish(span, err) }()
+
+	var userID int
+	err = repo.inTx(ctx, false, func(tx *sql.Tx) error {
+		var (
+			status     string
+			hasReceipt bool
+		)
+		err := tx.QueryRowContext(ctx,
+			`SELECT o.user_id, o.status, EXISTS (SELECT 1 FROM order_receipts r WHERE r.order_number = o.number)
+			   FROM orders o
+			  WHERE o.number = $1
+			    FOR UPDATE`,
+			number,
+		).Scan(&userID, &status, &hasReceipt)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrNotFound
+		case err != nil:
+			return err
+		case hasReceipt:
+			return ErrUniqConstrait
+		case isFinalStatus(status):
+			return ErrOrderFinal
+		}
+
+		if err := insertReceipt(ctx, tx, number, goods); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE orders SET `+repollSet+` WHERE number = $1`, number, parkedDeadLetter)
+		return err
+	})
+	if err == nil {
+		repo.noteWrite(userID)
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrUniqConstrait
+	}
+	return err
+}
+
+// repollSet возвращает заказ в опрос с нуля, когда чек дошёл до системы расчёта:
+// backoff, накопленный на ответах 204, и dead letter без чека больше не нужны.
+// Карантин остаётся за администратором. $2 — parkedDeadLetter.
+const repollSet = `attempts = 0,
+	        next_poll_at = now(),
+	        parked = CASE WHEN parked = $2 THEN NULL ELSE parked END`
+
+func insertReceipt(ctx context.Context, tx *sql.Tx, number string, goods []model.Good) error {
+	if _, err := tx.ExecContext(ctx, `INSERT INTO order_receipts (order_number) VALUES ($1)`, number); err != nil {
+		return err
+	}
+	for _, g := range goods {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO order_goods (order_number, description, price) VALUES ($1, $2, $3)`,
+			number, g.Description, g.Price,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Чеки, ждущие регистрации, откладываются на время аренды: параллельный экземпляр
+// их не возьмёт, а упавший экземпляр отпустит их сам по истечении срока.
+const claimRegistrations = `WITH claimed AS (
+	SELECT order_number
+	  FROM order_receipts
+	 WHERE status = 'PENDING'
+	   AND next_attempt_at <= now()
+	 ORDER BY next_attempt_at ASC
+	 LIMIT $1
+	   FOR UPDATE SKIP LOCKED
+)
+UPDATE order_receipts r
+   SET next_attempt_at = now() + $2::float8 * interval '1 millisecond'
+  FROM claimed c
+ WHERE r.order_number = c.order_number
+RETURNING r.order_number, r.attempts`
+
+// ClaimPendingRegistrations выдаёт до limit чеков, которые пора зарегистрировать.
+func (repo *Repo) ClaimPendingRegistrations(ctx context.Context, limit int) (_ []model.Receipt, err error) {
+	ctx, span := tracing.StartQuery(ctx, "ClaimPendingRegistrations")
+	defer func() { tracing.Finish(span, err) }()
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Exec)
+	defer cancel()
+
+	if limit <= 0 {
+		limit = 100
+	}
+
+	rows, err := repo.DB.QueryContext(ctx, claimRegistrations, limit, float64(repo.leaseTTL().Milliseconds()))
+	if err != nil {
+		return nil, err
+	}
+	receipts := make([]model.Receipt, 0)
+	index := make(map[int64]int)
+	numbers := make([]int64, 0)
+	for rows.Next() {
+		var (
+			n        int64
+			attempts int
+		)
+		if err := rows.Scan(&n, &attempts); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		index[n] = len(receipts)
+		numbers = append(numbers, n)
+		receipts = append(receipts, model.Receipt{Number: strconv.FormatInt(n, 10), Attempts: attempts})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(receipts) == 0 {
+		return receipts, err
+	}
+
+	rows, err = repo.DB.QueryContext(ctx,
+		`SELECT order_number, description, price
+		   FROM order_goods
+		  WHERE order_number = ANY($1)
+		  ORDER BY id`,
+		numbers,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			n int64
+			g model.Good
+		)
+		if err := rows.Scan(&n, &g.Description, &g.Price); err != nil {
+			return nil, err
+		}
+		r := &receipts[index[n]]
+		r.Goods = append(r.Goods, g)
+	}
+	return receipts, rows.Err()
+}
+
+// MarkRegistered отмечает чек принятым системой расчёта и тем же запросом
+// возвращает заказ в опрос с нуля.
+func (repo *Repo) MarkRegistered(ctx context.Context, number int64) error {
+	return repo.saveDB(ctx, "MarkRegistered",
+		`WITH registered AS (
+			UPDATE order_receipts
+			   SET status = 'REGISTERED', registered_at = now(), last_error = NULL
+			 WHERE order_number = $1 AND status = 'PENDING'
+			RETURNING order_number
+		)
+		UPDATE orders
+		   SET `+repollSet+`
+		 WHERE number IN (SELECT order_number FROM registered)
+		   AND status NOT IN ('PROCESSED', 'INVALID')`,
+		number, parkedDeadLetter,
+	)
+}
+
+// MarkRegistrationFailed записывает неудачную попытку регистрации. Следующая попытка —
+// по PollBackoff; final переводит чек в FAILED, и больше его не регистрируют.
+func (repo *Repo) MarkRegistrationFailed(ctx context.Context, number int64, reason string, final bool) error {
+	base, maxDelay := repo.Backoff.args()
+	return repo.saveDB(ctx, "MarkRegistrationFailed",
+		`UPDATE order_receipts
+		    SET status = CASE WHEN $3 THEN 'FAILED' ELSE status END,
+		        attempts = attempts + 1,
+		        next_attempt_at = `+nextPollSQL(4, 5)+`,
+		        last_error = $2
+		  WHERE order_number = $1 AND status = 'PENDING'`,
+		number, reason, final, base, maxDelay,
+	)
+}
@@ -0,0 +1,166 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Отставание реплики: 0, если всё полученное WAL уже применено (на простаивающем
+// мастере pg_last_xact_replay_timestamp стареет, хотя догонять нечего), NULL → 0 вне recovery.
+const replicaLagQuery = `SELECT COALESCE(
+	CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	     ELSE EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())
+	END, 0)`
+
+// Replica — реплика для чтения. Пока она недоступна или отстаёт больше MaxLag,
+// Repo читает с мастера.
+type Replica struct {
+	DB     *sql.DB
+	MaxLag time.Duration
+
+	healthy atomic.Bool
+	lag     atomic.Int64
+}
+
+func NewReplica(dsn string, maxLag time.Duration) (*Replica, error) {
+	if !isValidDSN(dsn) {
+		return nil, errors.New("invalid replica DSN")
+	}
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &Replica{DB: db, MaxLag: maxLag}, nil
+}
+
+// Healthy сообщает, можно ли сейчас читать с реплики.
+func (r *Replica) Healthy() bool {
+	return r.healthy.Load()
+}
+
+// Lag — отставание по последней проверке.
+func (r *Replica) Lag() time.Duration {
+	return time.Duration(r.lag.Load())
+}
+
+// Check пингует реплику и измеряет отставание. Ошибка означает, что читать с неё нельзя.
+func (r *Replica) Check(ctx context.Context) error {
+	var seconds float64
+	err := r.DB.QueryRowContext(ctx, replicaLagQuery).Scan(&seconds)
+	if err != nil {
+		r.healthy.Store(false)
+		return err
+	}
+
+	lag := time.Duration(seconds * float64(time.Second))
+	r.lag.Store(int64(lag))
+	if r.MaxLag > 0 && lag > r.MaxLag {
+		r.healthy.Store(false)
+		return fmt.Errorf("replica lag %s exceeds %s", lag, r.MaxLag)
+	}
+	r.healthy.Store(true)
+	return nil
+}
+
+// Run проверяет реплику каждые every до отмены ctx и пишет в лог только смену состояния.
+func (r *Replica) Run(ctx context.Context, every time.Duration, logger *zap.SugaredLogger) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+
+	for {
+		was := r.Healthy()
+		cctx, cancel := context.WithTimeout(ctx, every)
+		err := r.Check(cctx)
+		cancel()
+
+		if now := r.Healthy(); now != was && logger != nil {
+			if now {
+				logger.Infow("read replica is back, routing reads to it", "lag", r.Lag())
+			} else {
+				logger.Warnw("read replica unavailable, reading from primary", "error", err, "lag", r.Lag())
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// markDown снимает реплику с чтения до следующей успешной проверки.
+func (r *Replica) markDown() {
+	r.healthy.Store(false)
+}
+
+// readDB выбирает базу для чтения данных пользователя. Сразу после записи
+// пользователь читает с мастера, иначе не увидел бы свой же заказ или списание.
+func (repo *Repo) readDB(userID int) *sql.DB {
+	if repo.Replica == nil || !repo.Replica.Healthy() || repo.wroteRecently(userID) {
+		return repo.DB
+	}
+	return repo.Replica.DB
+}
+
+// queryRead выполняет чтение на readDB; при ошибке реплики повторяет его на мастере.
+func (repo *Repo) queryRead(ctx context.Context, userID int, query string, args ...any) (*sql.Rows, error) {
+	db := repo.readDB(userID)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil && db != repo.DB && ctx.Err() == nil {
+		repo.Replica.markDown()
+		repo.logger(ctx).Warnw("replica read failed, retrying on primary", "error", err)
+		return repo.DB.QueryContext(ctx, query, args...)
+	}
+	return rows, err
+}
+
+// noteWrite запоминает запись пользователя: в течение MaxLag его чтения идут на мастер.
+// Отметка живёт в памяти экземпляра, другие экземпляры о ней не знают.
+func (repo *Repo) noteWrite(userID int) {
+	if repo.Replica == nil {
+		return
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if repo.lastWrite == nil {
+		repo.lastWrite = make(map[int]time.Time)
+	}
+	now := time.Now()
+	repo.lastWrite[userID] = now
+
+	// старые отметки больше не влияют на маршрутизацию; чистим их не чаще раза за окно,
+	// чтобы запись не обходила всю карту под блокировкой
+	window := repo.stickiness()
+	if now.Sub(repo.lastSweep) <= window {
+		return
+	}
+	for id, at := range repo.lastWrite {
+		if now.Sub(at) > window {
+			delete(repo.lastWrite, id)
+		}
+	}
+	repo.lastSweep = now
+}
+
+func (repo *Repo) wroteRecently(userID int) bool {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	at, ok := repo.lastWrite[userID]
+	return ok && time.Since(at) <= repo.stickiness()
+}
+
+func (repo *Repo) stickiness() time.Duration {
+	if repo.Replica.MaxLag > 0 {
+		return repo.Replica.MaxLag
+	}
+	return 5 * time.Second
+}
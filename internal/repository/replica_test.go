@@ -0,0 +1,182 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/model"
+)
+
+func init() {
+	sql.Register("route_test_driver", routeTestDriver{})
+}
+
+// routeTestDriver считает запросы по имени базы: primary, replica, replica_err, replica_lag.
+type routeTestDriver struct{}
+
+var routeHits = struct {
+	sync.Mutex
+	n map[string]int
+}{n: map[string]int{}}
+
+func hits(name string) int {
+	routeHits.Lock()
+	defer routeHits.Unlock()
+	return routeHits.n[name]
+}
+
+func (routeTestDriver) Open(name string) (driver.Conn, error) {
+	return &routeTestConn{name: name}, nil
+}
+
+type routeTestConn struct {
+	name string
+}
+
+func (c *routeTestConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *routeTestConn) Close() error                        { return nil }
+func (c *routeTestConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c *routeTestConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	return driver.RowsAffected(1), nil
+}
+
+func (c *routeTestConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	if query == replicaLagQuery {
+		lag := 0.0
+		if c.name == "replica_lag" {
+			lag = 30
+		}
+		return &routeTestRows{cols: []string{"lag"}, data: []driver.Value{lag}}, nil
+	}
+
+	routeHits.Lock()
+	routeHits.n[c.name]++
+	routeHits.Unlock()
+
+	if c.name == "replica_err" {
+		return nil, errors.New("connection reset by peer")
+	}
+	return &routeTestRows{cols: []string{"number", "status", "accural", "uploaded_at", "user_id"}}, nil
+}
+
+type routeTestRows struct {
+	cols []string
+	data []driver.Value
+	done bool
+}
+
+func (r *routeTestRows) Columns() []string { return r.cols }
+func (r *routeTestRows) Close() error      { return nil }
+func (r *routeTestRows) Next(dest []driver.Value) error {
+	if r.done || r.data == nil {
+		return io.EOF
+	}
+	r.done = true
+	copy(dest, r.data)
+	return nil
+}
+
+func newRoutedRepo(t *testing.T, replica string) *Repo {
+	t.Helper()
+	routeHits.Lock()
+	routeHits.n = map[string]int{}
+	routeHits.Unlock()
+
+	primaryDB, _ := sql.Open("route_test_driver", "primary")
+	replicaDB, _ := sql.Open("route_test_driver", replica)
+	r := &Replica{DB: replicaDB, MaxLag: 5 * time.Second}
+	_ = r.Check(context.Background())
+	return &Repo{DB: primaryDB, Replica: r}
+}
+
+func TestReplica_ReadsGoToReplica(t *testing.T) {
+	repo := newRoutedRepo(t, "replica")
+	user := &model.User{ID: 1}
+
+	if _, err := repo.GetOrdersByUser(context.Background(), user); err != nil {
+		t.Fatalf("GetOrdersByUser: %v", err)
+	}
+	if hits("replica") != 1 || hits("primary") != 0 {
+		t.Fatalf("replica=%d primary=%d, want read on replica", hits("replica"), hits("primary"))
+	}
+}
+
+func TestReplica_ReadYourWritesUsesPrimary(t *testing.T) {
+	repo := newRoutedRepo(t, "replica")
+	user := &model.User{ID: 1}
+
+	if err := repo.SaveWithdrawal(context.Background(), &model.Withdrawal{Number: "79927398713", Sum: 100, UserID: 1}); err != nil {
+		t.Fatalf("SaveWithdrawal: %v", err)
+	}
+	if _, err := repo.GetWithdrawalsByUser(context.Background(), user); err != nil {
+		t.Fatalf("GetWithdrawalsByUser: %v", err)
+	}
+	if hits("primary") != 1 || hits("replica") != 0 {
+		t.Fatalf("primary=%d replica=%d, want read on primary right after write", hits("primary"), hits("replica"))
+	}
+
+	// другие пользователи по-прежнему читают с реплики
+	if _, err := repo.GetOrdersByUser(context.Background(), &model.User{ID: 2}); err != nil {
+		t.Fatalf("GetOrdersByUser: %v", err)
+	}
+	if hits("replica") != 1 {
+		t.Fatalf("replica=%d want=1", hits("replica"))
+	}
+}
+
+func TestReplica_LaggingReplicaIsSkipped(t *testing.T) {
+	repo := newRoutedRepo(t, "replica_lag")
+
+	if repo.Replica.Healthy() {
+		t.Fatalf("replica with 30s lag must be unhealthy")
+	}
+	if _, err := repo.GetOrdersByUser(context.Background(), &model.User{ID: 1}); err != nil {
+		t.Fatalf("GetOrdersByUser: %v", err)
+	}
+	if hits("primary") != 1 || hits("replica_lag") != 0 {
+		t.Fatalf("primary=%d replica=%d", hits("primary"), hits("replica_lag"))
+	}
+}
+
+func TestReplica_ErrorFallsBackToPrimary(t *testing.T) {
+	repo := newRoutedRepo(t, "replica_err")
+	if !repo.Replica.Healthy() {
+		t.Fatalf("replica must start healthy")
+	}
+
+	if _, err := repo.GetOrdersByUser(context.Background(), &model.User{ID: 1}); err != nil {
+		t.Fatalf("GetOrdersByUser: %v", err)
+	}
+	if hits("replica_err") != 1 || hits("primary") != 1 {
+		t.Fatalf("replica=%d primary=%d, want one failed replica read and a retry on primary", hits("replica_err"), hits("primary"))
+	}
+	if repo.Replica.Healthy() {
+		t.Fatalf("failed replica must be marked down")
+	}
+}
+
+func TestReplica_NoteWriteSweepsOncePerWindow(t *testing.T) {
+	repo := &Repo{Replica: &Replica{MaxLag: time.Minute}}
+	stale := time.Now().Add(-time.Hour)
+	repo.lastWrite = map[int]time.Time{1: stale, 2: stale}
+	repo.lastSweep = time.Now()
+
+	// в пределах окна запись не обходит карту
+	repo.noteWrite(3)
+	if len(repo.lastWrite) != 3 {
+		t.Fatalf("lastWrite=%v, want no sweep within the window", repo.lastWrite)
+	}
+
+	repo.lastSweep = stale
+	repo.noteWrite(4)
+	if len(repo.lastWrite) != 2 || repo.wroteRecently(1) || !repo.wroteRecently(3) || !repo.wroteRecently(4) {
+		t.Fatalf("lastWrite=%v, want stale marks swept", repo.lastWrite)
+	}
+}
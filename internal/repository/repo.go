@@ -7,8 +7,11 @@ import (
 	"fmt"
 	"github.com/g123udini/gofemart/internal/model"
 	"github.com/g123udini/gofemart/internal/service"
+	"github.com/g123udini/gofemart/internal/tracing"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
-	"log"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
 	"net/url"
 	"strings"
 	"sync"
@@ -18,27 +21,91 @@ import (
 var (
 	ErrUniqConstrait = errors.New("already exists")
 	ErrNotFound      = errors.New("user not found")
+	// ErrOrderFinal — заказ уже рассчитан, менять его нельзя.
+	ErrOrderFinal = errors.New("order already has a final status")
 )
 
 type Repo struct {
 	DB *sql.DB
-	mu sync.RWMutex
+	// Pool задан для бэкенда pgxpool; через него идут горячие запросы.
+	Pool   *pgxpool.Pool
+	Logger *zap.SugaredLogger
+	// Retry — повторы записей при временных ошибках, см. retryPolicy.
+	Retry service.RetryPolicy
+	// Timeouts — таймауты запросов по умолчанию, если у ctx нет более раннего дедлайна.
+	Timeouts Timeouts
+	// Replica — необязательная реплика для чтения истории и баланса.
+	Replica *Replica
+	// Lease — аренда заказов воркером, когда экземпляров несколько.
+	Lease Lease
+	// Backoff — расписание повторных опросов незавершённых заказов.
+	Backoff PollBackoff
+
+	mu        sync.RWMutex
+	lastWrite map[int]time.Time
+	lastSweep time.Time
 }
 
-func (repo *Repo) ListPendingOrders(ctx context.Context, limit int) ([]int64, error) {
+// Timeouts ограничивают чтения (Query) и записи/транзакции (Exec). 0 — без ограничения.
+type Timeouts struct {
+	Query time.Duration
+	Exec  time.Duration
+}
+
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// retryPolicy — политика повторов записи op. Нулевые поля Repo.Retry заменяются
+// значениями по умолчанию: 3 попытки, задержка от 100ms до 2s, только временные ошибки Postgres.
+func (repo *Repo) retryPolicy(op string) service.RetryPolicy {
+	p := repo.Retry
+	p.Op = op
+	if p.MaxAttempts <= 0 && p.MaxElapsed <= 0 {
+		p.MaxAttempts = 3
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = 100 * time.Millisecond
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = 2 * time.Second
+	}
+	if len(p.Classifiers) == 0 {
+		p.Classifiers = []service.Classifier{service.RetryPgTransient}
+	}
+	return p
+}
+
+// listPendingOrders — выборка без аренды, когда экземпляр на базе один.
+const listPendingOrders = `SELECT number
+   FROM orders
+  WHERE status NOT IN ('PROCESSED', 'INVALID')
+    AND parked IS NULL
+    AND next_poll_at <= now()
+  ORDER BY next_poll_at ASC, uploaded_at ASC
+  LIMIT $1`
+
+// ListPendingOrders возвращает незавершённые заказы, чей next_poll_at уже наступил,
+// начиная с самых давно ждущих. Если задан Lease.Owner, заказы заодно
+// арендуются этим экземпляром на Lease.TTL.
+func (repo *Repo) ListPendingOrders(ctx context.Context, limit int) (_ []int64, err error) {
+	ctx, span := tracing.StartQuery(ctx, "ListPendingOrders")
+	defer func() { tracing.Finish(span, err) }()
+
 	if limit <= 0 {
 		limit = 100
 	}
+	if repo.Lease.Owner != "" {
+		return repo.claimPendingOrders(ctx, limit)
+	}
 
-	rows, err := repo.DB.QueryContext(
-		ctx,
-		`SELECT number
-		   FROM orders
-		  WHERE status NOT IN ('PROCESSED', 'INVALID')
-		  ORDER BY uploaded_at ASC
-		  LIMIT $1`,
-		limit,
-	)
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
+	rows, err := repo.DB.QueryContext(ctx, listPendingOrders, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -55,24 +122,56 @@ func (repo *Repo) ListPendingOrders(ctx context.Context, limit int) ([]int64, er
 	return out, rows.Err()
 }
 
-func (repo *Repo) MarkOrderInvalidOnce(ctx context.Context, number int64) error {
+func (repo *Repo) CountPendingOrders(ctx context.Context) (_ int, err error) {
+	ctx, span := tracing.StartQuery(ctx, "CountPendingOrders")
+	defer func() { tracing.Finish(span, err) }()
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
+	var n int
+	err = repo.DB.QueryRowContext(ctx,
+		`SELECT count(*)
+		   FROM orders
+		  WHERE status NOT IN ('PROCESSED', 'INVALID')
+		    AND parked IS NULL`,
+	).Scan(&n)
+	return n, err
+}
+
+func (repo *Repo) MarkOrderInvalidOnce(ctx context.Context, number int64) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "MarkOrderInvalidOnce")
+	defer func() { tracing.Finish(span, err) }()
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Exec)
+	defer cancel()
+
 	res, err := repo.DB.ExecContext(
 		ctx,
 		`UPDATE orders
-		    SET status = 'INVALID'
+		    SET status = 'INVALID',
+		        locked_by = NULL,
+		        locked_until = NULL
 		  WHERE number = $1
-		    AND status NOT IN ('PROCESSED', 'INVALID')`,
-		number,
+		    AND status NOT IN ('PROCESSED', 'INVALID')
+		    AND parked IS NULL
+		    AND (locked_by IS NULL OR locked_by = $2 OR locked_until < now())`,
+		number, repo.Lease.Owner,
 	)
 	if err != nil {
 		return err
 	}
 
-	_, _ = res.RowsAffected()
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return repo.checkLease(ctx, repo.DB, number)
+	}
 	return nil
 }
 
-func (repo *Repo) ApplyOrderProcessedOnce(ctx context.Context, number int64, accural int64) error {
+func (repo *Repo) ApplyOrderProcessedOnce(ctx context.Context, number int64, accural int64) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "ApplyOrderProcessedOnce")
+	defer func() { tracing.Finish(span, err) }()
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Exec)
+	defer cancel()
+
 	tx, err := repo.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
 	if err != nil {
 		return err
@@ -83,10 +182,14 @@ func (repo *Repo) ApplyOrderProcessedOnce(ctx context.Context, number int64, acc
 		ctx,
 		`UPDATE orders
 		    SET status = 'PROCESSED',
-		        accural = $2
+		        accural = $2,
+		        locked_by = NULL,
+		        locked_until = NULL
 		  WHERE number = $1
-		    AND status NOT IN ('PROCESSED', 'INVALID')`,
-		number, accural,
+		    AND status NOT IN ('PROCESSED', 'INVALID')
+		    AND parked IS NULL
+		    AND (locked_by IS NULL OR locked_by = $3 OR locked_until < now())`,
+		number, accural, repo.Lease.Owner,
 	)
 	if err != nil {
 		return err
@@ -97,6 +200,9 @@ func (repo *Repo) ApplyOrderProcessedOnce(ctx context.Context, number int64, acc
 		return err
 	}
 	if affected == 0 {
+		if err := repo.checkLease(ctx, tx, number); err != nil {
+			return err
+		}
 		return tx.Commit()
 	}
 
@@ -114,20 +220,139 @@ func (repo *Repo) ApplyOrderProcessedOnce(ctx context.Context, number int64, acc
 	return tx.Commit()
 }
 
-func (repo *Repo) UpdateOrderStatusNonFinal(ctx context.Context, number int64, status string) error {
+func (repo *Repo) UpdateOrderStatusNonFinal(ctx context.Context, number int64, status string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "UpdateOrderStatusNonFinal")
+	defer func() { tracing.Finish(span, err) }()
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Exec)
+	defer cancel()
+
 	if status == "" {
 		return fmt.Errorf("empty status")
 	}
 
-	_, err := repo.DB.ExecContext(
+	base, maxDelay := repo.Backoff.args()
+	// аренда снимается: следующий опрос может достаться любому экземпляру
+	res, err := repo.DB.ExecContext(
+		ctx,
+		`UPDATE orders
+		    SET status = $2,
+		        attempts = attempts + 1,
+		        next_poll_at = `+nextPollSQL(4, 5)+`,
+		        locked_by = NULL,
+		        locked_until = NULL
+		  WHERE number = $1
+		    AND status NOT IN ('PROCESSED', 'INVALID')
+		    AND (locked_by IS NULL OR locked_by = $3 OR locked_until < now())`,
+		number, status, repo.Lease.Owner, base, maxDelay,
+	)
+	if err != nil {
+		return err
+	}
+
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return repo.checkLease(ctx, repo.DB, number)
+	}
+	return nil
+}
+
+// SetOrderStatusNonFinal меняет только статус: для уведомлений системы расчёта,
+// которые не считаются опросом и не сдвигают следующий опрос. Уведомления могут
+// прийти повторно и не по порядку, поэтому PROCESSING не откатывается в REGISTERED.
+func (repo *Repo) SetOrderStatusNonFinal(ctx context.Context, number int64, status string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "SetOrderStatusNonFinal")
+	defer func() { tracing.Finish(span, err) }()
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Exec)
+	defer cancel()
+
+	if status == "" {
+		return fmt.Errorf("empty status")
+	}
+
+	res, err := repo.DB.ExecContext(
 		ctx,
 		`UPDATE orders
 		    SET status = $2
 		  WHERE number = $1
-		    AND status NOT IN ('PROCESSED', 'INVALID')`,
-		number, status,
+		    AND status NOT IN ('PROCESSED', 'INVALID')
+		    AND NOT (status = 'PROCESSING' AND $2 = 'REGISTERED')
+		    AND parked IS NULL
+		    AND (locked_by IS NULL OR locked_by = $3 OR locked_until < now())`,
+		number, status, repo.Lease.Owner,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return repo.checkLease(ctx, repo.DB, number)
+	}
+	return nil
+}
+
+// GetOrderParked возвращает, почему заказ снят с опроса: "" — не снят.
+func (repo *Repo) GetOrderParked(ctx context.Context, number int64) (_ string, err error) {
+	ctx, span := tracing.StartQuery(ctx, "GetOrderParked")
+	defer func() { tracing.Finish(span, err) }()
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
+	var parked sql.NullString
+	err = repo.DB.QueryRowContext(ctx, `SELECT parked FROM orders WHERE number = $1`, number).Scan(&parked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	return parked.String, err
+}
+
+// PostponeOrder откладывает опрос заказа, о котором система расчёта пока не знает (204).
+func (repo *Repo) PostponeOrder(ctx context.Context, number int64) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "PostponeOrder")
+	defer func() { tracing.Finish(span, err) }()
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Exec)
+	defer cancel()
+
+	base, maxDelay := repo.Backoff.args()
+	res, err := repo.DB.ExecContext(
+		ctx,
+		`UPDATE orders
+		    SET attempts = attempts + 1,
+		        next_poll_at = `+nextPollSQL(3, 4)+`,
+		        locked_by = NULL,
+		        locked_until = NULL
+		  WHERE number = $1
+		    AND status NOT IN ('PROCESSED', 'INVALID')
+		    AND (locked_by IS NULL OR locked_by = $2 OR locked_until < now())`,
+		number, repo.Lease.Owner, base, maxDelay,
+	)
+	if err != nil {
+		return err
+	}
+
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return repo.checkLease(ctx, repo.DB, number)
+	}
+	return nil
+}
+
+// SchemaVersion возвращает применённую версию миграций из таблицы golang-migrate.
+func (repo *Repo) SchemaVersion(ctx context.Context) (_ uint, _ bool, err error) {
+	ctx, span := tracing.StartQuery(ctx, "SchemaVersion")
+	defer func() { tracing.Finish(span, err) }()
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
+	var (
+		version int64
+		dirty   bool
+	)
+	err = repo.DB.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	return uint(version), dirty, nil
 }
 
 func NewRepository(DSN string) (*Repo, error) {
@@ -137,16 +362,37 @@ func NewRepository(DSN string) (*Repo, error) {
 	db, err := sql.Open("pgx", DSN)
 
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	return &Repo{DB: db}, nil
 }
 
-func (repo *Repo) GetUserByLogin(login string) (*model.User, error) {
-	u := model.User{}
+func (repo *Repo) GetUserByLogin(ctx context.Context, login string) (_ *model.User, err error) {
+	ctx, span := tracing.StartQuery(ctx, "GetUserByLogin")
+	defer func() { tracing.Finish(span, err) }()
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
 
-	err := repo.getModel(&u, "SELECT id, login, password, current, withdrawn FROM users WHERE login = $1", login)
+	u := model.User{}
+	fields := append(u.ScanFields(), &u.DisabledAt, &u.SessionsRevokedAt)
+
+	if repo.Pool != nil {
+		err = repo.queryPrepared(ctx, stmtGetUserByLogin, func(rows pgx.Rows) error {
+			if !rows.Next() {
+				if err := rows.Err(); err != nil {
+					return err
+				}
+				return sql.ErrNoRows
+			}
+			return rows.Scan(fields...)
+		}, login)
+	} else {
+		err = repo.DB.QueryRowContext(ctx, stmtGetUserByLogin.sql, login).Scan(fields...)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		err = ErrNotFound
+	}
 
 	if err != nil {
 		if errors.Is(err, ErrNotFound) {
@@ -158,10 +404,16 @@ func (repo *Repo) GetUserByLogin(login string) (*model.User, error) {
 	return &u, nil
 }
 
-func (repo *Repo) GetOrderByNumberUser(number string, user *model.User) (*model.Order, error) {
+func (repo *Repo) GetOrderByNumberUser(ctx context.Context, number string, user *model.User) (_ *model.Order, err error) {
+	ctx, span := tracing.StartQuery(ctx, "GetOrderByNumberUser")
+	defer func() { tracing.Finish(span, err) }()
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
 	var order model.Order
 
-	err := repo.getModel(
+	err = repo.getModel(
+		ctx,
 		&order,
 		`SELECT number, status, accural, uploaded_at, user_id
 		 FROM orders
@@ -180,8 +432,15 @@ func (repo *Repo) GetOrderByNumberUser(number string, user *model.User) (*model.
 	return &order, nil
 }
 
-func (repo *Repo) GetOrdersByUser(user *model.User) ([]model.Order, error) {
-	rows, err := repo.DB.Query(
+func (repo *Repo) GetOrdersByUser(ctx context.Context, user *model.User) (_ []model.Order, err error) {
+	ctx, span := tracing.StartQuery(ctx, "GetOrdersByUser")
+	defer func() { tracing.Finish(span, err) }()
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
+	rows, err := repo.queryRead(
+		ctx,
+		user.ID,
 		`SELECT number, status, accural, uploaded_at, user_id
 		 FROM orders
 		 WHERE user_id = $1
@@ -212,8 +471,15 @@ func (repo *Repo) GetOrdersByUser(user *model.User) ([]model.Order, error) {
 	return orders, nil
 }
 
-func (repo *Repo) GetWithdrawalsByUser(user *model.User) ([]model.Withdrawal, error) {
-	rows, err := repo.DB.Query(
+func (repo *Repo) GetWithdrawalsByUser(ctx context.Context, user *model.User) (_ []model.Withdrawal, err error) {
+	ctx, span := tracing.StartQuery(ctx, "GetWithdrawalsByUser")
+	defer func() { tracing.Finish(span, err) }()
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
+	rows, err := repo.queryRead(
+		ctx,
+		user.ID,
 		`SELECT user_id, number, sum, processed_at
 		 FROM withdrawals
 		 WHERE user_id = $1
@@ -238,14 +504,40 @@ func (repo *Repo) GetWithdrawalsByUser(user *model.User) ([]model.Withdrawal, er
 	return withdrawals, rows.Err()
 }
 
+func (repo *Repo) GetBalance(ctx context.Context, user *model.User) (_ model.Balance, err error) {
+	ctx, span := tracing.StartQuery(ctx, "GetBalance")
+	defer func() { tracing.Finish(span, err) }()
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
+	rows, err := repo.queryRead(ctx, user.ID, `SELECT current, withdrawn FROM users WHERE id = $1`, user.ID)
+	if err != nil {
+		return model.Balance{}, err
+	}
+	defer rows.Close()
+
+	var b model.Balance
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return model.Balance{}, err
+		}
+		return model.Balance{}, ErrNotFound
+	}
+	if err := rows.Scan(&b.Current, &b.Withdrawn); err != nil {
+		return model.Balance{}, err
+	}
+	return b, rows.Err()
+}
+
 func (repo *Repo) getModel(
+	ctx context.Context,
 	model model.Model,
 	sqlString string,
 	args ...any,
 ) error {
 
 	err := repo.DB.
-		QueryRow(sqlString, args...).
+		QueryRowContext(ctx, sqlString, args...).
 		Scan(model.ScanFields()...)
 
 	if err != nil {
@@ -258,35 +550,49 @@ func (repo *Repo) getModel(
 	return nil
 }
 
-func (repo *Repo) SaveUser(user *model.User) error {
-	return repo.SaveDB("INSERT INTO users (login, password) VALUES ($1, $2)", user.Login, user.Password)
+func (repo *Repo) SaveUser(ctx context.Context, user *model.User) error {
+	return repo.saveDB(ctx, "SaveUser", "INSERT INTO users (login, password) VALUES ($1, $2)", user.Login, user.Password)
 }
 
-func (repo *Repo) UpdateUser(user *model.User) error {
-	return repo.SaveDB("UPDATE users SET login = $1, password = $2, current = $3, withdrawn = $4 WHERE id = $5", user.Login, user.Password, user.Balance.Current, user.Balance.Withdrawn, user.ID)
+func (repo *Repo) UpdateUser(ctx context.Context, user *model.User) error {
+	defer repo.noteWrite(user.ID)
+	return repo.saveDB(ctx, "UpdateUser", "UPDATE users SET login = $1, password = $2, current = $3, withdrawn = $4 WHERE id = $5", user.Login, user.Password, user.Balance.Current, user.Balance.Withdrawn, user.ID)
 }
 
-func (repo *Repo) SaveWithdrawal(w *model.Withdrawal) error {
-	return repo.SaveDB("INSERT INTO withdrawals (number, sum, user_id) VALUES ($1, $2, $3)", w.Number, w.Sum, w.UserID)
+func (repo *Repo) SaveWithdrawal(ctx context.Context, w *model.Withdrawal) error {
+	defer repo.noteWrite(w.UserID)
+	return repo.saveDB(ctx, "SaveWithdrawal", "INSERT INTO withdrawals (number, sum, user_id) VALUES ($1, $2, $3)", w.Number, w.Sum, w.UserID)
 }
 
-func (repo *Repo) SaveOrder(order *model.Order) error {
+func (repo *Repo) SaveOrder(ctx context.Context, order *model.Order) error {
+	defer repo.noteWrite(order.UserID)
 	return repo.
-		SaveDB(
+		saveDB(
+			ctx,
+			"SaveOrder",
 			"INSERT INTO orders (number, status, accural, uploaded_at, user_id) VALUES ($1, $2, $3, $4, $5)",
 			order.Number, order.Status, order.Accrual, order.UploadedAt, order.UserID,
 		)
 }
 
-func (repo *Repo) SaveDB(sqlString string, args ...any) error {
-	_, err := service.RetryDB(
-		3,
-		1*time.Second,
-		2*time.Second,
-		func() (sql.Result, error) {
-			return repo.DB.Exec(sqlString, args...)
-		},
-	)
+func (repo *Repo) SaveDB(ctx context.Context, sqlString string, args ...any) error {
+	return repo.saveDB(ctx, "SaveDB", sqlString, args...)
+}
+
+func (repo *Repo) saveDB(ctx context.Context, name string, sqlString string, args ...any) (err error) {
+	ctx, span := tracing.StartQuery(ctx, name)
+	defer func() { tracing.Finish(span, err) }()
+
+	err = repo.retryPolicy(name).Do(ctx, func(ctx context.Context) error {
+		// таймаут на каждую попытку, а не на все повторы вместе
+		ectx, cancel := withTimeout(ctx, repo.Timeouts.Exec)
+		defer cancel()
+		_, err := repo.DB.ExecContext(ectx, sqlString, args...)
+		if err != nil {
+			repo.logger(ctx).Debugw("exec attempt failed", "query", name, "error", err)
+		}
+		return err
+	})
 
 	if err != nil {
 		var pgErr *pgconn.PgError
@@ -299,6 +605,10 @@ func (repo *Repo) SaveDB(sqlString string, args ...any) error {
 	return nil
 }
 
+func (repo *Repo) logger(ctx context.Context) *zap.SugaredLogger {
+	return service.LoggerFromOr(ctx, repo.Logger)
+}
+
 func isValidDSN(dsn string) bool {
 	dsn = strings.TrimSpace(dsn)
 	if dsn == "" {
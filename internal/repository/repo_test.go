@@ -92,7 +92,7 @@ func TestSaveDB_UniqueConstraint(t *testing.T) {
 
 	repo := &Repo{DB: db}
 
-	err = repo.SaveDB("INSERT INTO users(login,password) VALUES($1,$2)", "a", "b")
+	err = repo.SaveDB(context.Background(), "INSERT INTO users(login,password) VALUES($1,$2)", "a", "b")
 	if !errors.Is(err, ErrUniqConstrait) {
 		t.Fatalf("err=%v want ErrUniqConstrait", err)
 	}
@@ -107,7 +107,7 @@ func TestSaveDB_GenericError(t *testing.T) {
 
 	repo := &Repo{DB: db}
 
-	err = repo.SaveDB("INSERT INTO users(login,password) VALUES($1,$2)", "a", "b")
+	err = repo.SaveDB(context.Background(), "INSERT INTO users(login,password) VALUES($1,$2)", "a", "b")
 	if err == nil || errors.Is(err, ErrUniqConstrait) {
 		t.Fatalf("err=%v want generic error (not ErrUniqConstrait)", err)
 	}
@@ -126,7 +126,7 @@ func TestGetModel_NoRows_ReturnsErrNotFound(t *testing.T) {
 	repo := &Repo{DB: db}
 
 	u := model.User{}
-	err = repo.getModel(&u, "SELECT ... WHERE login=$1", "nope")
+	err = repo.getModel(context.Background(), &u, "SELECT ... WHERE login=$1", "nope")
 	if !errors.Is(err, ErrNotFound) {
 		t.Fatalf("err=%v want ErrNotFound", err)
 	}
@@ -141,7 +141,7 @@ func TestGetUserByLogin_NoRows_ReturnsNilNil(t *testing.T) {
 
 	repo := &Repo{DB: db}
 
-	u, err := repo.GetUserByLogin("nope")
+	u, err := repo.GetUserByLogin(context.Background(), "nope")
 	if err != nil {
 		t.Fatalf("err=%v want nil", err)
 	}
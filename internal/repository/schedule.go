@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+)
+
+// PollBackoff — расписание повторных опросов заказа, который система расчёта
+// ещё не обработала (204, REGISTERED, PROCESSING): после n-го такого ответа
+// заказ снова попадает в ListPendingOrders через min(Max, Base*2^(n-1)).
+// Нулевые поля оставляют значения по умолчанию.
+type PollBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+func (b PollBackoff) withDefaults() PollBackoff {
+	if b.Base <= 0 {
+		b.Base = time.Second
+	}
+	if b.Max <= 0 {
+		b.Max = 10 * time.Minute
+	}
+	return b
+}
+
+// delay — пауза после очередного ответа; attempts — сколько их было до него.
+func (b PollBackoff) delay(attempts int) time.Duration {
+	b = b.withDefaults()
+	d := b.Base
+	for i := 0; i < attempts && d < b.Max; i++ {
+		d *= 2
+	}
+	return min(d, b.Max)
+}
+
+// nextPollSQL — то же, что delay, для UPDATE: attempts в выражении — значение до обновления.
+// baseArg и maxArg — номера параметров с задержками в миллисекундах, см. args.
+func nextPollSQL(baseArg, maxArg int) string {
+	return fmt.Sprintf(
+		"now() + LEAST($%d::float8, $%d::float8 * power(2, LEAST(attempts, 30))) * interval '1 millisecond'",
+		maxArg, baseArg,
+	)
+}
+
+func (b PollBackoff) args() (baseMs, maxMs float64) {
+	b = b.withDefaults()
+	return float64(b.Base.Milliseconds()), float64(b.Max.Milliseconds())
+}
@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/model"
+)
+
+func TestPollBackoff_Delay(t *testing.T) {
+	b := PollBackoff{Base: time.Second, Max: 10 * time.Second}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for attempts, w := range want {
+		if got := b.delay(attempts); got != w {
+			t.Fatalf("delay(%d)=%s want %s", attempts, got, w)
+		}
+	}
+	if got := (PollBackoff{}).delay(0); got != time.Second {
+		t.Fatalf("default base=%s want 1s", got)
+	}
+}
+
+func TestMemory_PostponedOrderComesBackWithBackoff(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	m.Backoff = PollBackoff{Base: 50 * time.Millisecond, Max: time.Second}
+
+	_ = m.SaveUser(ctx, &model.User{Login: "alice", Password: "hash"})
+	u, _ := m.GetUserByLogin(ctx, "alice")
+	_ = m.SaveOrder(ctx, &model.Order{Number: "1", Status: "NEW", UserID: u.ID})
+	_ = m.SaveOrder(ctx, &model.Order{Number: "2", Status: "NEW", UserID: u.ID})
+
+	// 1 опрашивали дважды: следующий опрос через 100мс; 2 — один раз, через 50мс
+	_ = m.PostponeOrder(ctx, 1)
+	_ = m.UpdateOrderStatusNonFinal(ctx, 1, "PROCESSING")
+	_ = m.UpdateOrderStatusNonFinal(ctx, 2, "REGISTERED")
+
+	if got, _ := m.ListPendingOrders(ctx, 10); len(got) != 0 {
+		t.Fatalf("pending=%v want none right after postpone", got)
+	}
+
+	time.Sleep(70 * time.Millisecond)
+	if got, _ := m.ListPendingOrders(ctx, 10); len(got) != 1 || got[0] != 2 {
+		t.Fatalf("pending=%v want [2]", got)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if got, _ := m.ListPendingOrders(ctx, 10); len(got) != 2 || got[0] != 2 || got[1] != 1 {
+		t.Fatalf("pending=%v want [2 1] ordered by next poll", got)
+	}
+}
@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/model"
+)
+
+// Интерфейсы хранилища. Их реализуют Repo (Postgres) и Memory; поведение
+// обеих проверяет общий набор тестов из пакета storagetest.
+
+type Users interface {
+	// GetUserByLogin возвращает nil, nil, если пользователя нет.
+	GetUserByLogin(ctx context.Context, login string) (*model.User, error)
+	// SaveUser возвращает ErrUniqConstrait, если логин занят.
+	SaveUser(ctx context.Context, user *model.User) error
+	UpdateUser(ctx context.Context, user *model.User) error
+	// GetBalance читает текущий баланс; Repo может обслужить его с реплики.
+	GetBalance(ctx context.Context, user *model.User) (model.Balance, error)
+}
+
+type Orders interface {
+	// GetOrderByNumberUser возвращает nil, nil, если у пользователя нет такого заказа.
+	GetOrderByNumberUser(ctx context.Context, number string, user *model.User) (*model.Order, error)
+	GetOrdersByUser(ctx context.Context, user *model.User) ([]model.Order, error)
+	// SaveOrder возвращает ErrUniqConstrait, если номер уже загружен кем угодно.
+	SaveOrder(ctx context.Context, order *model.Order) error
+	// SaveOrderReceipt сохраняет заказ вместе с чеком, который ждёт регистрации
+	// в системе расчёта. Ошибки — как у SaveOrder.
+	SaveOrderReceipt(ctx context.Context, order *model.Order, goods []model.Good) error
+	// AddOrderReceipt прикладывает чек к загруженному без него заказу и возвращает заказ
+	// в опрос с нуля; ErrUniqConstrait, если чек у заказа уже есть, ErrOrderFinal —
+	// если заказ уже рассчитан.
+	AddOrderReceipt(ctx context.Context, number string, goods []model.Good) error
+}
+
+type Withdrawals interface {
+	GetWithdrawalsByUser(ctx context.Context, user *model.User) ([]model.Withdrawal, error)
+	// SaveWithdrawal возвращает ErrUniqConstrait на повторный номер заказа.
+	SaveWithdrawal(ctx context.Context, w *model.Withdrawal) error
+}
+
+// PendingOrders — очередь незавершённых заказов для воркера начислений.
+// Переходы в финальные статусы идемпотентны: повторный вызов ничего не меняет.
+// Repo с заданным Lease арендует выданные заказы; обновление заказа, который
+// арендовал другой экземпляр, возвращает ErrLeaseLost.
+type PendingOrders interface {
+	ListPendingOrders(ctx context.Context, limit int) ([]int64, error)
+	// CountPendingOrders — сколько незавершённых заказов ещё опрашивается, без учёта
+	// аренды и расписания: размер очереди для метрики.
+	CountPendingOrders(ctx context.Context) (int, error)
+	ApplyOrderProcessedOnce(ctx context.Context, number int64, accural int64) error
+	MarkOrderInvalidOnce(ctx context.Context, number int64) error
+	// UpdateOrderStatusNonFinal и PostponeOrder откладывают следующий опрос по PollBackoff.
+	UpdateOrderStatusNonFinal(ctx context.Context, number int64, status string) error
+	PostponeOrder(ctx context.Context, number int64) error
+	// SetOrderStatusNonFinal меняет только статус, не трогая попытки и расписание опроса,
+	// и не откатывает PROCESSING в REGISTERED.
+	SetOrderStatusNonFinal(ctx context.Context, number int64, status string) error
+	// GetOrderParked возвращает orders.parked: "" — заказ опрашивается; ErrNotFound — заказа нет.
+	// Заказ, снятый с опроса, меняет только администратор.
+	GetOrderParked(ctx context.Context, number int64) (string, error)
+	// QuarantineOrder снимает заказ с опроса и сохраняет аномальный ответ системы расчёта.
+	QuarantineOrder(ctx context.Context, number int64, reason, detail, payload string) error
+	// DeadLetterOrders снимает с опроса заказы старше maxAge или опрошенные maxAttempts раз
+	// и возвращает их; нулевой предел не проверяется.
+	DeadLetterOrders(ctx context.Context, maxAge time.Duration, maxAttempts, limit int) ([]model.StuckOrder, error)
+}
+
+// Registrations — очередь чеков, которые gophermart регистрирует в системе расчёта.
+// Выданный чек скрывается от следующих выборок на время аренды; если его не отметили,
+// он вернётся в очередь сам. Отметки меняют только чеки в статусе PENDING;
+// MarkRegistered заодно возвращает заказ в опрос с нуля.
+type Registrations interface {
+	ClaimPendingRegistrations(ctx context.Context, limit int) ([]model.Receipt, error)
+	MarkRegistered(ctx context.Context, number int64) error
+	// MarkRegistrationFailed откладывает следующую попытку по PollBackoff;
+	// final переводит чек в FAILED.
+	MarkRegistrationFailed(ctx context.Context, number int64, reason string, final bool) error
+}
+
+type Storage interface {
+	Users
+	Orders
+	Withdrawals
+	PendingOrders
+	Registrations
+}
+
+var (
+	_ Storage = (*Repo)(nil)
+	_ Storage = (*Memory)(nil)
+)
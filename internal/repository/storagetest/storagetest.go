@@ -0,0 +1,623 @@
+// Package storagetest — общий набор тестов для реализаций repository.Storage.
+// Каждая реализация вызывает Run из своих тестов со своей фабрикой хранилища.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/g123udini/gofemart/internal/model"
+	"github.com/g123udini/gofemart/internal/repository"
+)
+
+// Factory возвращает пустое хранилище для одного подтеста.
+type Factory func(t *testing.T) repository.Storage
+
+func Run(t *testing.T, newStorage Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s repository.Storage)
+	}{
+		{"UserUniqueLogin", testUserUniqueLogin},
+		{"UpdateUser", testUpdateUser},
+		{"OrderUniqueAcrossUsers", testOrderUniqueAcrossUsers},
+		{"OrdersSortedByUpload", testOrdersSortedByUpload},
+		{"WithdrawalUnique", testWithdrawalUnique},
+		{"PendingQueue", testPendingQueue},
+		{"PostponeOrder", testPostponeOrder},
+		{"SetOrderStatusNonFinal", testSetOrderStatusNonFinal},
+		{"QuarantineOrder", testQuarantineOrder},
+		{"ParkedOrdersAreFrozen", testParkedOrdersAreFrozen},
+		{"DeadLetterOrders", testDeadLetterOrders},
+		{"ReceiptRegistration", testReceiptRegistration},
+		{"ReceiptRepollsOrder", testReceiptRepollsOrder},
+		{"ApplyProcessedOnce", testApplyProcessedOnce},
+		{"FinalStatusesAreSticky", testFinalStatusesAreSticky},
+		{"ConcurrentApplyCreditsOnce", testConcurrentApplyCreditsOnce},
+		{"CancelledContext", testCancelledContext},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStorage(t))
+		})
+	}
+}
+
+func mustUser(t *testing.T, s repository.Storage, login string) *model.User {
+	t.Helper()
+	if err := s.SaveUser(context.Background(), &model.User{Login: login, Password: "hash"}); err != nil {
+		t.Fatalf("SaveUser(%s): %v", login, err)
+	}
+	u, err := s.GetUserByLogin(context.Background(), login)
+	if err != nil || u == nil {
+		t.Fatalf("GetUserByLogin(%s)=%v,%v", login, u, err)
+	}
+	return u
+}
+
+func mustOrder(t *testing.T, s repository.Storage, u *model.User, number string, at time.Time) {
+	t.Helper()
+	err := s.SaveOrder(context.Background(), &model.Order{Number: number, Status: "NEW", UploadedAt: at, UserID: u.ID})
+	if err != nil {
+		t.Fatalf("SaveOrder(%s): %v", number, err)
+	}
+}
+
+func orderStatus(t *testing.T, s repository.Storage, u *model.User, number string) *model.Order {
+	t.Helper()
+	o, err := s.GetOrderByNumberUser(context.Background(), number, u)
+	if err != nil || o == nil {
+		t.Fatalf("GetOrderByNumberUser(%s)=%v,%v", number, o, err)
+	}
+	return o
+}
+
+func testUserUniqueLogin(t *testing.T, s repository.Storage) {
+	u := mustUser(t, s, "alice")
+	if u.ID <= 0 || u.Balance.Current != 0 || u.Balance.Withdrawn != 0 {
+		t.Fatalf("new user=%+v", u)
+	}
+
+	err := s.SaveUser(context.Background(), &model.User{Login: "alice", Password: "other"})
+	if !errors.Is(err, repository.ErrUniqConstrait) {
+		t.Fatalf("duplicate login err=%v want ErrUniqConstrait", err)
+	}
+
+	missing, err := s.GetUserByLogin(context.Background(), "nobody")
+	if err != nil || missing != nil {
+		t.Fatalf("unknown login=%v,%v want nil,nil", missing, err)
+	}
+}
+
+func testUpdateUser(t *testing.T, s repository.Storage) {
+	u := mustUser(t, s, "alice")
+	u.Balance = model.Balance{Current: 700, Withdrawn: 300}
+	if err := s.UpdateUser(context.Background(), u); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+
+	got, _ := s.GetUserByLogin(context.Background(), "alice")
+	if got.Balance != u.Balance {
+		t.Fatalf("balance=%+v want=%+v", got.Balance, u.Balance)
+	}
+
+	b, err := s.GetBalance(context.Background(), u)
+	if err != nil || b != u.Balance {
+		t.Fatalf("GetBalance=%+v,%v want=%+v", b, err, u.Balance)
+	}
+	if _, err := s.GetBalance(context.Background(), &model.User{ID: u.ID + 100}); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("GetBalance of unknown user err=%v want ErrNotFound", err)
+	}
+
+	// изменение возвращённой копии не должно протекать в хранилище
+	got.Balance.Current = 1
+	again, _ := s.GetUserByLogin(context.Background(), "alice")
+	if again.Balance.Current != 700 {
+		t.Fatalf("storage returned a shared pointer")
+	}
+}
+
+func testOrderUniqueAcrossUsers(t *testing.T, s repository.Storage) {
+	alice := mustUser(t, s, "alice")
+	bob := mustUser(t, s, "bob")
+	mustOrder(t, s, alice, "12345678903", time.Now())
+
+	err := s.SaveOrder(context.Background(), &model.Order{Number: "12345678903", Status: "NEW", UploadedAt: time.Now(), UserID: bob.ID})
+	if !errors.Is(err, repository.ErrUniqConstrait) {
+		t.Fatalf("foreign duplicate err=%v want ErrUniqConstrait", err)
+	}
+	err = s.SaveOrder(context.Background(), &model.Order{Number: "12345678903", Status: "NEW", UploadedAt: time.Now(), UserID: alice.ID})
+	if !errors.Is(err, repository.ErrUniqConstrait) {
+		t.Fatalf("own duplicate err=%v want ErrUniqConstrait", err)
+	}
+
+	if o, err := s.GetOrderByNumberUser(context.Background(), "12345678903", bob); err != nil || o != nil {
+		t.Fatalf("bob sees alice's order: %v,%v", o, err)
+	}
+	if o := orderStatus(t, s, alice, "12345678903"); o.Status != "NEW" || o.UserID != alice.ID {
+		t.Fatalf("order=%+v", o)
+	}
+
+	err = s.SaveOrder(context.Background(), &model.Order{Number: "42", Status: "NEW", UploadedAt: time.Now(), UserID: 999999})
+	if err == nil {
+		t.Fatalf("order for a missing user must fail")
+	}
+}
+
+func testOrdersSortedByUpload(t *testing.T, s repository.Storage) {
+	u := mustUser(t, s, "alice")
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	mustOrder(t, s, u, "30", base.Add(2*time.Minute))
+	mustOrder(t, s, u, "10", base)
+	mustOrder(t, s, u, "20", base.Add(time.Minute))
+
+	orders, err := s.GetOrdersByUser(context.Background(), u)
+	if err != nil {
+		t.Fatalf("GetOrdersByUser: %v", err)
+	}
+	if len(orders) != 3 || orders[0].Number != "10" || orders[1].Number != "20" || orders[2].Number != "30" {
+		t.Fatalf("orders=%+v", orders)
+	}
+
+	empty, err := s.GetOrdersByUser(context.Background(), mustUser(t, s, "bob"))
+	if err != nil || empty == nil || len(empty) != 0 {
+		t.Fatalf("empty orders=%v,%v want non-nil empty slice", empty, err)
+	}
+}
+
+func testWithdrawalUnique(t *testing.T, s repository.Storage) {
+	u := mustUser(t, s, "alice")
+
+	if err := s.SaveWithdrawal(context.Background(), &model.Withdrawal{Number: "2377225624", Sum: 500, UserID: u.ID}); err != nil {
+		t.Fatalf("SaveWithdrawal: %v", err)
+	}
+	err := s.SaveWithdrawal(context.Background(), &model.Withdrawal{Number: "2377225624", Sum: 100, UserID: u.ID})
+	if !errors.Is(err, repository.ErrUniqConstrait) {
+		t.Fatalf("duplicate withdrawal err=%v want ErrUniqConstrait", err)
+	}
+
+	ws, err := s.GetWithdrawalsByUser(context.Background(), u)
+	if err != nil {
+		t.Fatalf("GetWithdrawalsByUser: %v", err)
+	}
+	if len(ws) != 1 || ws[0].Number != "2377225624" || ws[0].Sum != 500 || ws[0].ProcessedAt.IsZero() {
+		t.Fatalf("withdrawals=%+v", ws)
+	}
+}
+
+func testPendingQueue(t *testing.T, s repository.Storage) {
+	ctx := context.Background()
+	u := mustUser(t, s, "alice")
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for i, n := range []string{"1", "2", "3", "4", "5"} {
+		mustOrder(t, s, u, n, base.Add(time.Duration(i)*time.Minute))
+	}
+
+	if err := s.ApplyOrderProcessedOnce(ctx, 1, 100); err != nil {
+		t.Fatalf("ApplyOrderProcessedOnce: %v", err)
+	}
+	if err := s.MarkOrderInvalidOnce(ctx, 2); err != nil {
+		t.Fatalf("MarkOrderInvalidOnce: %v", err)
+	}
+	if err := s.UpdateOrderStatusNonFinal(ctx, 3, "PROCESSING"); err != nil {
+		t.Fatalf("UpdateOrderStatusNonFinal: %v", err)
+	}
+
+	// заказ 3 после ответа PROCESSING отложен до следующего опроса по расписанию
+	pending, err := s.ListPendingOrders(ctx, 10)
+	if err != nil {
+		t.Fatalf("ListPendingOrders: %v", err)
+	}
+	if len(pending) != 2 || pending[0] != 4 || pending[1] != 5 {
+		t.Fatalf("pending=%v want [4 5]", pending)
+	}
+
+	limited, _ := s.ListPendingOrders(ctx, 1)
+	if len(limited) != 1 || limited[0] != 4 {
+		t.Fatalf("limited=%v want [4]", limited)
+	}
+
+	if err := s.UpdateOrderStatusNonFinal(ctx, 3, ""); err == nil {
+		t.Fatalf("empty status must be rejected")
+	}
+
+	// в очереди и отложенные, и уже выданные заказы; снятые с опроса — нет
+	if n, err := s.CountPendingOrders(ctx); err != nil || n != 3 {
+		t.Fatalf("CountPendingOrders=%d, %v want 3", n, err)
+	}
+	if err := s.QuarantineOrder(ctx, 5, "test", "", ""); err != nil {
+		t.Fatalf("QuarantineOrder: %v", err)
+	}
+	if n, _ := s.CountPendingOrders(ctx); n != 2 {
+		t.Fatalf("CountPendingOrders=%d want 2 after quarantine", n)
+	}
+}
+
+func testPostponeOrder(t *testing.T, s repository.Storage) {
+	ctx := context.Background()
+	u := mustUser(t, s, "alice")
+	base := time.Now().Add(-time.Hour)
+	mustOrder(t, s, u, "1", base)
+	mustOrder(t, s, u, "2", base.Add(time.Minute))
+
+	if err := s.PostponeOrder(ctx, 1); err != nil {
+		t.Fatalf("PostponeOrder: %v", err)
+	}
+	pending, _ := s.ListPendingOrders(ctx, 10)
+	if len(pending) != 1 || pending[0] != 2 {
+		t.Fatalf("pending=%v want [2]: postponed order must wait for its next poll", pending)
+	}
+	if o := orderStatus(t, s, u, "1"); o.Status != "NEW" {
+		t.Fatalf("postpone must not change status, got %s", o.Status)
+	}
+
+	// финальные и несуществующие заказы не трогаем
+	_ = s.MarkOrderInvalidOnce(ctx, 2)
+	if err := s.PostponeOrder(ctx, 2); err != nil {
+		t.Fatalf("PostponeOrder(final): %v", err)
+	}
+	if err := s.PostponeOrder(ctx, 404); err != nil {
+		t.Fatalf("PostponeOrder(missing): %v", err)
+	}
+	if o := orderStatus(t, s, u, "2"); o.Status != "INVALID" {
+		t.Fatalf("order 2 status=%s want INVALID", o.Status)
+	}
+}
+
+func testSetOrderStatusNonFinal(t *testing.T, s repository.Storage) {
+	ctx := context.Background()
+	u := mustUser(t, s, "alice")
+	mustOrder(t, s, u, "1", time.Now().Add(-time.Hour))
+
+	if err := s.SetOrderStatusNonFinal(ctx, 1, "PROCESSING"); err != nil {
+		t.Fatalf("SetOrderStatusNonFinal: %v", err)
+	}
+	if o := orderStatus(t, s, u, "1"); o.Status != "PROCESSING" {
+		t.Fatalf("status=%s want PROCESSING", o.Status)
+	}
+	// опрос не откладывается
+	pending, _ := s.ListPendingOrders(ctx, 10)
+	if len(pending) != 1 || pending[0] != 1 {
+		t.Fatalf("pending=%v want [1]: status update must keep the poll schedule", pending)
+	}
+
+	// повтор или опоздавшее уведомление не откатывает PROCESSING в REGISTERED
+	if err := s.SetOrderStatusNonFinal(ctx, 1, "REGISTERED"); err != nil {
+		t.Fatalf("SetOrderStatusNonFinal(REGISTERED): %v", err)
+	}
+	if o := orderStatus(t, s, u, "1"); o.Status != "PROCESSING" {
+		t.Fatalf("status=%s want PROCESSING: status must not move backwards", o.Status)
+	}
+
+	_ = s.MarkOrderInvalidOnce(ctx, 1)
+	if err := s.SetOrderStatusNonFinal(ctx, 1, "PROCESSING"); err != nil {
+		t.Fatalf("SetOrderStatusNonFinal(final): %v", err)
+	}
+	if o := orderStatus(t, s, u, "1"); o.Status != "INVALID" {
+		t.Fatalf("final status overwritten: %s", o.Status)
+	}
+}
+
+func testParkedOrdersAreFrozen(t *testing.T, s repository.Storage) {
+	ctx := context.Background()
+	u := mustUser(t, s, "alice")
+	mustOrder(t, s, u, "1", time.Now().Add(-time.Hour))
+	mustOrder(t, s, u, "2", time.Now().Add(-time.Hour))
+
+	if parked, err := s.GetOrderParked(ctx, 1); err != nil || parked != "" {
+		t.Fatalf("GetOrderParked=%q, %v want polled order", parked, err)
+	}
+	if _, err := s.GetOrderParked(ctx, 404); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("GetOrderParked(missing) err=%v want ErrNotFound", err)
+	}
+
+	_ = s.QuarantineOrder(ctx, 1, "test", "", "{}")
+	if parked, err := s.GetOrderParked(ctx, 1); err != nil || parked != "QUARANTINED" {
+		t.Fatalf("GetOrderParked=%q, %v want QUARANTINED", parked, err)
+	}
+
+	// заказ в карантине меняет только администратор
+	_ = s.ApplyOrderProcessedOnce(ctx, 1, 100)
+	_ = s.MarkOrderInvalidOnce(ctx, 1)
+	_ = s.SetOrderStatusNonFinal(ctx, 1, "PROCESSING")
+	if o := orderStatus(t, s, u, "1"); o.Status != "NEW" {
+		t.Fatalf("parked order status=%s want NEW", o.Status)
+	}
+	got, _ := s.GetUserByLogin(ctx, "alice")
+	if got.Balance.Current != 0 {
+		t.Fatalf("parked order credited: current=%d", got.Balance.Current)
+	}
+}
+
+func testQuarantineOrder(t *testing.T, s repository.Storage) {
+	ctx := context.Background()
+	u := mustUser(t, s, "alice")
+	base := time.Now().Add(-time.Hour)
+	mustOrder(t, s, u, "1", base)
+	mustOrder(t, s, u, "2", base.Add(time.Minute))
+
+	payload := `{"order":"1","status":"REFUNDED"}`
+	if err := s.QuarantineOrder(ctx, 1, "unknown_status", `status "REFUNDED"`, payload); err != nil {
+		t.Fatalf("QuarantineOrder: %v", err)
+	}
+	// повторный карантин того же заказа ничего не меняет
+	if err := s.QuarantineOrder(ctx, 1, "unknown_status", "", payload); err != nil {
+		t.Fatalf("QuarantineOrder again: %v", err)
+	}
+
+	pending, _ := s.ListPendingOrders(ctx, 10)
+	if len(pending) != 1 || pending[0] != 2 {
+		t.Fatalf("pending=%v want [2]: quarantined order must not be polled", pending)
+	}
+	if o := orderStatus(t, s, u, "1"); o.Status != "NEW" {
+		t.Fatalf("quarantine must not change the visible status, got %s", o.Status)
+	}
+
+	_ = s.ApplyOrderProcessedOnce(ctx, 2, 10)
+	if err := s.QuarantineOrder(ctx, 2, "order_mismatch", "", "{}"); err != nil {
+		t.Fatalf("QuarantineOrder(final): %v", err)
+	}
+	if o := orderStatus(t, s, u, "2"); o.Status != "PROCESSED" {
+		t.Fatalf("final order changed: %s", o.Status)
+	}
+}
+
+func testDeadLetterOrders(t *testing.T, s repository.Storage) {
+	ctx := context.Background()
+	u := mustUser(t, s, "alice")
+	now := time.Now()
+	mustOrder(t, s, u, "1", now.Add(-3*time.Hour))
+	mustOrder(t, s, u, "2", now.Add(-time.Minute))
+	mustOrder(t, s, u, "3", now.Add(-time.Minute))
+	mustOrder(t, s, u, "4", now.Add(-4*time.Hour))
+	_ = s.ApplyOrderProcessedOnce(ctx, 4, 10)
+	for range 3 {
+		_ = s.PostponeOrder(ctx, 2)
+	}
+
+	if got, err := s.DeadLetterOrders(ctx, 0, 0, 10); err != nil || len(got) != 0 {
+		t.Fatalf("DeadLetterOrders without limits = %v, %v; want nothing", got, err)
+	}
+
+	got, err := s.DeadLetterOrders(ctx, 2*time.Hour, 3, 10)
+	if err != nil {
+		t.Fatalf("DeadLetterOrders: %v", err)
+	}
+	attempts := make(map[string]int, len(got))
+	for _, o := range got {
+		attempts[o.Number] = o.Attempts
+	}
+	if _, ok := attempts["1"]; !ok || len(attempts) != 2 || attempts["2"] != 3 {
+		t.Fatalf("dead-lettered %v want orders 1 and 2 (with 3 attempts)", attempts)
+	}
+
+	pending, _ := s.ListPendingOrders(ctx, 10)
+	if len(pending) != 1 || pending[0] != 3 {
+		t.Fatalf("pending=%v want [3]: dead-lettered orders must not be polled", pending)
+	}
+	if o := orderStatus(t, s, u, "1"); o.Status != "NEW" {
+		t.Fatalf("dead letter must not change the visible status, got %s", o.Status)
+	}
+
+	// уже снятые с опроса заказы второй раз не возвращаются
+	if again, _ := s.DeadLetterOrders(ctx, 2*time.Hour, 3, 10); len(again) != 0 {
+		t.Fatalf("second DeadLetterOrders = %v, want nothing", again)
+	}
+}
+
+func testReceiptRegistration(t *testing.T, s repository.Storage) {
+	ctx := context.Background()
+	u := mustUser(t, s, "alice")
+	goods := []model.Good{{Description: "Чайник Bork", Price: 700000}, {Description: "Стакан", Price: 15050}}
+
+	for _, n := range []string{"1", "2", "3"} {
+		o := &model.Order{Number: n, Status: "NEW", UploadedAt: time.Now(), UserID: u.ID}
+		if err := s.SaveOrderReceipt(ctx, o, goods); err != nil {
+			t.Fatalf("SaveOrderReceipt(%s): %v", n, err)
+		}
+	}
+	err := s.SaveOrderReceipt(ctx, &model.Order{Number: "1", Status: "NEW", UploadedAt: time.Now(), UserID: u.ID}, goods)
+	if !errors.Is(err, repository.ErrUniqConstrait) {
+		t.Fatalf("duplicate receipt err=%v want ErrUniqConstrait", err)
+	}
+	if o := orderStatus(t, s, u, "1"); o.Status != "NEW" {
+		t.Fatalf("order with receipt status=%s want NEW", o.Status)
+	}
+	if err := s.AddOrderReceipt(ctx, "1", goods); !errors.Is(err, repository.ErrUniqConstrait) {
+		t.Fatalf("second receipt err=%v want ErrUniqConstrait", err)
+	}
+	// заказ без чека получает чек позже
+	mustOrder(t, s, u, "4", time.Now())
+	if err := s.AddOrderReceipt(ctx, "4", goods[:1]); err != nil {
+		t.Fatalf("AddOrderReceipt: %v", err)
+	}
+
+	claimed, err := s.ClaimPendingRegistrations(ctx, 10)
+	if err != nil {
+		t.Fatalf("ClaimPendingRegistrations: %v", err)
+	}
+	if len(claimed) != 4 {
+		t.Fatalf("claimed %d receipts, want 4", len(claimed))
+	}
+	for _, r := range claimed {
+		want := goods
+		if r.Number == "4" {
+			want = goods[:1]
+		}
+		if len(r.Goods) != len(want) || r.Goods[0] != want[0] {
+			t.Fatalf("receipt %s goods=%+v want %+v", r.Number, r.Goods, want)
+		}
+	}
+	// выданные чеки до истечения аренды повторно не выдаются
+	if again, _ := s.ClaimPendingRegistrations(ctx, 10); len(again) != 0 {
+		t.Fatalf("claimed receipts handed out twice: %+v", again)
+	}
+
+	if err := s.MarkRegistered(ctx, 1); err != nil {
+		t.Fatalf("MarkRegistered: %v", err)
+	}
+	if err := s.MarkRegistrationFailed(ctx, 2, "accrual unavailable", false); err != nil {
+		t.Fatalf("MarkRegistrationFailed: %v", err)
+	}
+	if err := s.MarkRegistrationFailed(ctx, 3, "rejected", true); err != nil {
+		t.Fatalf("MarkRegistrationFailed(final): %v", err)
+	}
+	// отметки финальных и несуществующих чеков ничего не меняют
+	if err := s.MarkRegistrationFailed(ctx, 1, "late", true); err != nil {
+		t.Fatalf("MarkRegistrationFailed(registered): %v", err)
+	}
+	if err := s.MarkRegistered(ctx, 404); err != nil {
+		t.Fatalf("MarkRegistered(missing): %v", err)
+	}
+	if again, _ := s.ClaimPendingRegistrations(ctx, 10); len(again) != 0 {
+		t.Fatalf("retry must wait for backoff, got %+v", again)
+	}
+}
+
+// Заказ, отложенный на ответах 204 или ушедший в dead letter без чека, после
+// прикладывания чека и его регистрации опрашивается снова сразу.
+func testReceiptRepollsOrder(t *testing.T, s repository.Storage) {
+	ctx := context.Background()
+	u := mustUser(t, s, "alice")
+	goods := []model.Good{{Description: "Чайник Bork", Price: 700000}}
+	base := time.Now().Add(-time.Hour)
+	for i, n := range []string{"1", "2", "3"} {
+		mustOrder(t, s, u, n, base.Add(time.Duration(i)*time.Minute))
+	}
+	for range 3 {
+		_ = s.PostponeOrder(ctx, 1)
+	}
+	if got, _ := s.DeadLetterOrders(ctx, 0, 3, 10); len(got) != 1 {
+		t.Fatalf("dead lettered %+v, want order 1", got)
+	}
+	_ = s.QuarantineOrder(ctx, 2, "test", "", "{}")
+	_ = s.ApplyOrderProcessedOnce(ctx, 3, 10)
+
+	if err := s.AddOrderReceipt(ctx, "1", goods); err != nil {
+		t.Fatalf("AddOrderReceipt: %v", err)
+	}
+	if pending, _ := s.ListPendingOrders(ctx, 10); len(pending) != 1 || pending[0] != 1 {
+		t.Fatalf("pending=%v want [1]: receipt must return the order to polling", pending)
+	}
+
+	// до регистрации система расчёта отвечает 204, и опрос снова откладывается
+	for range 3 {
+		_ = s.PostponeOrder(ctx, 1)
+	}
+	if pending, _ := s.ListPendingOrders(ctx, 10); len(pending) != 0 {
+		t.Fatalf("pending=%v want none while backing off", pending)
+	}
+	if err := s.MarkRegistered(ctx, 1); err != nil {
+		t.Fatalf("MarkRegistered: %v", err)
+	}
+	if pending, _ := s.ListPendingOrders(ctx, 10); len(pending) != 1 || pending[0] != 1 {
+		t.Fatalf("pending=%v want [1]: registration must reset the backoff", pending)
+	}
+
+	// карантин снимает только администратор
+	if err := s.AddOrderReceipt(ctx, "2", goods); err != nil {
+		t.Fatalf("AddOrderReceipt(quarantined): %v", err)
+	}
+	if parked, _ := s.GetOrderParked(ctx, 2); parked != "QUARANTINED" {
+		t.Fatalf("parked=%q want QUARANTINED", parked)
+	}
+
+	if err := s.AddOrderReceipt(ctx, "3", goods); !errors.Is(err, repository.ErrOrderFinal) {
+		t.Fatalf("receipt for processed order err=%v want ErrOrderFinal", err)
+	}
+	if err := s.AddOrderReceipt(ctx, "404", goods); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("receipt for missing order err=%v want ErrNotFound", err)
+	}
+}
+
+func testApplyProcessedOnce(t *testing.T, s repository.Storage) {
+	ctx := context.Background()
+	u := mustUser(t, s, "alice")
+	mustOrder(t, s, u, "77", time.Now())
+
+	for i := 0; i < 2; i++ {
+		if err := s.ApplyOrderProcessedOnce(ctx, 77, 12345); err != nil {
+			t.Fatalf("ApplyOrderProcessedOnce #%d: %v", i, err)
+		}
+	}
+
+	got, _ := s.GetUserByLogin(context.Background(), "alice")
+	if got.Balance.Current != 12345 {
+		t.Fatalf("current=%d want=12345 (credited once)", got.Balance.Current)
+	}
+	if o := orderStatus(t, s, u, "77"); o.Status != "PROCESSED" || o.Accrual != 12345 {
+		t.Fatalf("order=%+v", o)
+	}
+
+	if err := s.ApplyOrderProcessedOnce(ctx, 404, 1); err != nil {
+		t.Fatalf("missing order must be a no-op, got %v", err)
+	}
+}
+
+func testFinalStatusesAreSticky(t *testing.T, s repository.Storage) {
+	ctx := context.Background()
+	u := mustUser(t, s, "alice")
+	mustOrder(t, s, u, "1", time.Now())
+	mustOrder(t, s, u, "2", time.Now())
+
+	_ = s.ApplyOrderProcessedOnce(ctx, 1, 50)
+	_ = s.MarkOrderInvalidOnce(ctx, 2)
+
+	_ = s.MarkOrderInvalidOnce(ctx, 1)
+	_ = s.UpdateOrderStatusNonFinal(ctx, 1, "PROCESSING")
+	_ = s.ApplyOrderProcessedOnce(ctx, 2, 50)
+	_ = s.UpdateOrderStatusNonFinal(ctx, 2, "PROCESSING")
+
+	if o := orderStatus(t, s, u, "1"); o.Status != "PROCESSED" {
+		t.Fatalf("order 1 status=%s want PROCESSED", o.Status)
+	}
+	if o := orderStatus(t, s, u, "2"); o.Status != "INVALID" {
+		t.Fatalf("order 2 status=%s want INVALID", o.Status)
+	}
+	got, _ := s.GetUserByLogin(context.Background(), "alice")
+	if got.Balance.Current != 50 {
+		t.Fatalf("current=%d want=50", got.Balance.Current)
+	}
+}
+
+func testConcurrentApplyCreditsOnce(t *testing.T, s repository.Storage) {
+	ctx := context.Background()
+	u := mustUser(t, s, "alice")
+	mustOrder(t, s, u, "5", time.Now())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = s.ApplyOrderProcessedOnce(ctx, 5, 1000)
+		}()
+	}
+	wg.Wait()
+
+	got, _ := s.GetUserByLogin(context.Background(), "alice")
+	if got.Balance.Current != 1000 {
+		t.Fatalf("current=%d want=1000", got.Balance.Current)
+	}
+}
+
+func testCancelledContext(t *testing.T, s repository.Storage) {
+	mustUser(t, s, "alice")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := s.GetUserByLogin(ctx, "alice"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("GetUserByLogin err=%v want context.Canceled", err)
+	}
+	if err := s.SaveUser(ctx, &model.User{Login: "bob", Password: "hash"}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("SaveUser err=%v want context.Canceled", err)
+	}
+	if u, _ := s.GetUserByLogin(context.Background(), "bob"); u != nil {
+		t.Fatalf("cancelled SaveUser must not persist the user")
+	}
+}
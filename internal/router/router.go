@@ -2,16 +2,97 @@ package router
 
 import (
 	"github.com/g123udini/gofemart/internal/handler"
+	"github.com/g123udini/gofemart/internal/health"
+	"github.com/g123udini/gofemart/internal/metrics"
+	"github.com/g123udini/gofemart/internal/service"
+	"github.com/g123udini/gofemart/internal/tracing"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
 )
 
-func NewRouter(handler *handler.Handler) chi.Router {
+type options struct {
+	logger  *zap.SugaredLogger
+	maxBody int64
+	routes  []func(r chi.Router)
+}
+
+// Option настраивает роутер: логгер и необязательные маршруты.
+type Option func(o *options)
+
+func WithLogger(l *zap.SugaredLogger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// WithMaxBodyBytes задаёт предел распакованного тела gzip-запроса; 0 — без предела.
+func WithMaxBodyBytes(n int64) Option {
+	return func(o *options) {
+		o.maxBody = n
+	}
+}
+
+func WithHealth(h *health.Health) Option {
+	return func(o *options) {
+		o.routes = append(o.routes, func(r chi.Router) {
+			r.Get("/healthz", h.Healthz)
+			r.Get("/readyz", h.Readyz)
+		})
+	}
+}
+
+func WithMetrics() Option {
+	return func(o *options) {
+		o.routes = append(o.routes, func(r chi.Router) {
+			r.Handle("/metrics", metrics.Handler())
+		})
+	}
+}
+
+// WithAdmin подключает служебный API /admin под токеном администратора.
+func WithAdmin(a *handler.Admin) Option {
+	return func(o *options) {
+		o.routes = append(o.routes, func(r chi.Router) {
+			r.Route("/admin", func(ar chi.Router) {
+				ar.Use(a.Auth)
+				ar.Get("/orders/stuck", a.StuckOrders)
+				ar.Post("/orders/{number}/requeue", a.RequeueOrder)
+			})
+		})
+	}
+}
+
+// WithAccrualCallback подключает приём уведомлений системы расчёта.
+// Подпись проверяет сам обработчик, сессия не нужна.
+func WithAccrualCallback(c *handler.AccrualCallback) Option {
+	return func(o *options) {
+		o.routes = append(o.routes, func(r chi.Router) {
+			r.
+				With(middleware.AllowContentType("application/json")).
+				Post("/internal/accrual/callback", c.ServeHTTP)
+		})
+	}
+}
+
+func NewRouter(handler *handler.Handler, opts ...Option) chi.Router {
+	o := options{logger: zap.S(), maxBody: service.DefaultMaxBodyBytes}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	r := chi.NewRouter()
-	r.Use(middleware.Logger)
+	r.Use(tracing.Middleware)
+	r.Use(service.RequestLogger(o.logger))
+	r.Use(metrics.Middleware)
+	r.Use(service.Gzip(o.maxBody))
 
 	routeAPI(r, handler)
 
+	for _, route := range o.routes {
+		route(r)
+	}
+
 	return r
 }
 
@@ -26,7 +107,7 @@ func routeUser(router chi.Router, handler *handler.Handler) {
 		r.Post("/register", handler.Register)
 		r.Post("/login", handler.Login)
 		r.
-			With(middleware.AllowContentType("text/plain")).
+			With(middleware.AllowContentType("text/plain", "application/json")).
 			With(handler.SessionAuth).
 			Post("/orders", handler.AddOrder)
 
@@ -124,7 +124,7 @@ func TestRouter_AddOrder_WrongContentType(t *testing.T) {
 	r := newTestRouter(t)
 
 	req := httptest.NewRequest(http.MethodPost, "/api/user/orders", strings.NewReader("79927398713"))
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	rr := httptest.NewRecorder()
 
 	r.ServeHTTP(rr, req)
@@ -0,0 +1,282 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const gzipMinSize = 1024
+
+var gzipContentTypes = map[string]struct{}{
+	"application/json": {},
+	"text/csv":         {},
+}
+
+var gzipWriterPool = sync.Pool{
+	New: func() any {
+		gz, _ := gzip.NewWriterLevel(io.Discard, gzip.BestSpeed)
+		return gz
+	},
+}
+
+// DefaultMaxBodyBytes — предел распакованного тела запроса для GzipMiddleware.
+const DefaultMaxBodyBytes = 1 << 20
+
+// GzipMiddleware — Gzip с пределом DefaultMaxBodyBytes.
+func GzipMiddleware(next http.Handler) http.Handler {
+	return Gzip(DefaultMaxBodyBytes)(next)
+}
+
+// Gzip распаковывает тела запросов с Content-Encoding: gzip и сжимает
+// JSON/CSV ответы больше gzipMinSize, если клиент прислал Accept-Encoding: gzip.
+// Распакованное тело больше maxBody отклоняется с 413: предел на сжатые байты
+// не защищает от gzip-бомбы. maxBody <= 0 — без предела.
+func Gzip(maxBody int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.EqualFold(strings.TrimSpace(r.Header.Get("Content-Encoding")), "gzip") {
+				body, code := gunzipBody(r.Body, maxBody)
+				if code != 0 {
+					http.Error(w, http.StatusText(code), code)
+					return
+				}
+				r.Body = io.NopCloser(bytes.NewReader(body))
+				r.Header.Del("Content-Encoding")
+				r.Header.Del("Content-Length")
+				r.ContentLength = int64(len(body))
+			}
+			serveGzip(w, r, next)
+		})
+	}
+}
+
+// gunzipBody распаковывает тело целиком, но не больше maxBody байт.
+// Ненулевой code — статус ответа: 400 для битого gzip, 413 для слишком большого тела.
+func gunzipBody(body io.Reader, maxBody int64) ([]byte, int) {
+	gr, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, bodyErrorCode(err)
+	}
+	defer gr.Close()
+
+	var src io.Reader = gr
+	if maxBody > 0 {
+		src = io.LimitReader(gr, maxBody+1)
+	}
+	out, err := io.ReadAll(src)
+	if err != nil {
+		return nil, bodyErrorCode(err)
+	}
+	if maxBody > 0 && int64(len(out)) > maxBody {
+		return nil, http.StatusRequestEntityTooLarge
+	}
+	return out, 0
+}
+
+// bodyErrorCode — 413, если сжатое тело упёрлось в http.MaxBytesReader, иначе 400.
+func bodyErrorCode(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// serveGzip оборачивает ответ и тогда, когда клиент gzip не принимает: Vary нужен
+// любому ответу сжимаемого типа, иначе общий кэш отдаст не тот вариант.
+func serveGzip(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	gw := NewGzipResponseWriter(w, gzipMinSize)
+	gw.identity = !acceptsGzip(r)
+	defer gw.Close()
+
+	next.ServeHTTP(gw, r)
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		enc, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(enc), "gzip") {
+			continue
+		}
+		return qValue(params) > 0
+	}
+	return false
+}
+
+// qValue разбирает вес из параметров кодировки: "q=0.5" → 0.5, без q — 1.
+// Неразборчивый вес считается нулём: такую кодировку не выбираем.
+func qValue(params string) float64 {
+	for _, p := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(p), "=")
+		if !strings.EqualFold(strings.TrimSpace(name), "q") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || q < 0 || q > 1 {
+			return 0
+		}
+		return q
+	}
+	return 1
+}
+
+// GzipResponseWriter копит начало ответа до minSize байт и только потом решает,
+// сжимать его или отдать как есть. Ответу сжимаемого типа он всегда ставит
+// Vary: Accept-Encoding, даже если тело в итоге не сжато.
+type GzipResponseWriter struct {
+	http.ResponseWriter
+	minSize     int
+	status      int
+	buf         []byte
+	gz          *gzip.Writer
+	passthrough bool
+	wroteHeader bool
+	// identity — клиент gzip не принимает: тело идёт как есть, но с Vary
+	identity bool
+}
+
+func NewGzipResponseWriter(w http.ResponseWriter, minSize int) *GzipResponseWriter {
+	return &GzipResponseWriter{ResponseWriter: w, minSize: minSize}
+}
+
+func (gw *GzipResponseWriter) WriteHeader(code int) {
+	if gw.wroteHeader || gw.status != 0 {
+		return
+	}
+	gw.status = code
+
+	// informational и ответы без тела отдаём сразу
+	if code < http.StatusOK || code == http.StatusNoContent || code == http.StatusNotModified {
+		gw.passthrough = true
+		gw.flushHeader()
+	}
+}
+
+func (gw *GzipResponseWriter) Write(b []byte) (int, error) {
+	if gw.gz != nil {
+		return gw.gz.Write(b)
+	}
+	if gw.passthrough {
+		gw.flushHeader()
+		return gw.ResponseWriter.Write(b)
+	}
+
+	if gw.buf == nil {
+		compressible := gw.compressible()
+		if compressible {
+			gw.addVary()
+		}
+		if !compressible || gw.identity {
+			gw.passthrough = true
+			gw.flushHeader()
+			return gw.ResponseWriter.Write(b)
+		}
+	}
+
+	gw.buf = append(gw.buf, b...)
+	if len(gw.buf) >= gw.minSize {
+		if err := gw.startGzip(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Close дописывает накопленный буфер и закрывает gzip-поток.
+func (gw *GzipResponseWriter) Close() error {
+	if gw.gz != nil {
+		err := gw.gz.Close()
+		gw.gz.Reset(io.Discard)
+		gzipWriterPool.Put(gw.gz)
+		gw.gz = nil
+		gw.passthrough = true
+		return err
+	}
+
+	gw.flushHeader()
+	if len(gw.buf) > 0 {
+		_, err := gw.ResponseWriter.Write(gw.buf)
+		gw.buf = nil
+		return err
+	}
+	return nil
+}
+
+func (gw *GzipResponseWriter) Flush() {
+	if gw.gz == nil && !gw.passthrough && !gw.identity && len(gw.buf) > 0 && gw.compressible() {
+		_ = gw.startGzip()
+	}
+	if gw.gz != nil {
+		_ = gw.gz.Flush()
+	} else {
+		gw.passthrough = true
+		gw.flushHeader()
+		if len(gw.buf) > 0 {
+			_, _ = gw.ResponseWriter.Write(gw.buf)
+			gw.buf = nil
+		}
+	}
+	if f, ok := gw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (gw *GzipResponseWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
+
+func (gw *GzipResponseWriter) startGzip() error {
+	h := gw.Header()
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	gw.addVary()
+	gw.flushHeader()
+
+	gz := gzipWriterPool.Get().(*gzip.Writer)
+	gz.Reset(gw.ResponseWriter)
+	gw.gz = gz
+
+	buf := gw.buf
+	gw.buf = nil
+	_, err := gz.Write(buf)
+	return err
+}
+
+func (gw *GzipResponseWriter) addVary() {
+	for _, v := range gw.Header().Values("Vary") {
+		if strings.Contains(strings.ToLower(v), "accept-encoding") {
+			return
+		}
+	}
+	gw.Header().Add("Vary", "Accept-Encoding")
+}
+
+func (gw *GzipResponseWriter) flushHeader() {
+	if gw.wroteHeader {
+		return
+	}
+	gw.wroteHeader = true
+	if gw.status != 0 {
+		gw.ResponseWriter.WriteHeader(gw.status)
+	}
+}
+
+func (gw *GzipResponseWriter) compressible() bool {
+	h := gw.Header()
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	_, ok := gzipContentTypes[mediaType]
+	return ok
+}
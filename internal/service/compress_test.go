@@ -0,0 +1,265 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func gzipBytes(t *testing.T, s string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte(s)); err != nil {
+		t.Fatalf("gzip write: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gzip close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func gunzip(t *testing.T, b []byte) string {
+	t.Helper()
+
+	gr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	out, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("gzip read: %v", err)
+	}
+	return string(out)
+}
+
+func TestGzipMiddleware_CompressesLargeJSON(t *testing.T) {
+	payload := `[` + strings.Repeat(`{"number":"79927398713","status":"NEW"},`, 100) + `{}]`
+
+	h := GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(payload))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/user/orders", nil)
+	req.Header.Set("Accept-Encoding", "br, gzip")
+	rr := httptest.NewRecorder()
+
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status=%d want=%d", rr.Code, http.StatusOK)
+	}
+	if ce := rr.Header().Get("Content-Encoding"); ce != "gzip" {
+		t.Fatalf("Content-Encoding=%q want=gzip", ce)
+	}
+	if got := gunzip(t, rr.Body.Bytes()); got != payload {
+		t.Fatalf("body mismatch after gunzip")
+	}
+}
+
+func TestGzipMiddleware_SkipsSmallBody(t *testing.T) {
+	h := GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"current":1}`))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/user/balance/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+
+	h.ServeHTTP(rr, req)
+
+	if ce := rr.Header().Get("Content-Encoding"); ce != "" {
+		t.Fatalf("Content-Encoding=%q want empty", ce)
+	}
+	if rr.Body.String() != `{"current":1}` {
+		t.Fatalf("body=%q", rr.Body.String())
+	}
+	// короткий ответ того же маршрута тоже зависит от Accept-Encoding для кэшей
+	if v := rr.Header().Get("Vary"); v != "Accept-Encoding" {
+		t.Fatalf("Vary=%q want Accept-Encoding", v)
+	}
+}
+
+func TestGzipMiddleware_SkipsOtherContentTypes(t *testing.T) {
+	payload := strings.Repeat("a", 4096)
+
+	h := GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, payload, http.StatusInternalServerError)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("status=%d want=%d", rr.Code, http.StatusInternalServerError)
+	}
+	if ce := rr.Header().Get("Content-Encoding"); ce != "" {
+		t.Fatalf("Content-Encoding=%q want empty", ce)
+	}
+	if strings.TrimSpace(rr.Body.String()) != payload {
+		t.Fatalf("body was modified")
+	}
+}
+
+func TestGzipMiddleware_NoAcceptEncoding(t *testing.T) {
+	payload := `"` + strings.Repeat("x", 4096) + `"`
+
+	h := GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(payload))
+	}))
+
+	tests := []string{"", "deflate", "gzip;q=0", "gzip; q=0.0", "gzip;q=0.000", "gzip;q=abc"}
+	for _, ae := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if ae != "" {
+			req.Header.Set("Accept-Encoding", ae)
+		}
+		rr := httptest.NewRecorder()
+
+		h.ServeHTTP(rr, req)
+
+		if ce := rr.Header().Get("Content-Encoding"); ce != "" {
+			t.Fatalf("Accept-Encoding=%q: Content-Encoding=%q want empty", ae, ce)
+		}
+		if rr.Body.String() != payload {
+			t.Fatalf("Accept-Encoding=%q: body was modified", ae)
+		}
+		if v := rr.Header().Get("Vary"); v != "Accept-Encoding" {
+			t.Fatalf("Accept-Encoding=%q: Vary=%q want Accept-Encoding", ae, v)
+		}
+	}
+}
+
+func TestAcceptsGzip_QValues(t *testing.T) {
+	tests := map[string]bool{
+		"gzip":                true,
+		"gzip;q=0.5":          true,
+		"deflate, gzip;q=1.0": true,
+		"GZIP; Q=0.001":       true,
+		"gzip;q=0":            false,
+		"gzip;q=0.0":          false,
+		"gzip;q=0.000":        false,
+		"br;q=1, gzip;q=0":    false,
+	}
+	for ae, want := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", ae)
+		if got := acceptsGzip(r); got != want {
+			t.Fatalf("acceptsGzip(%q)=%v want %v", ae, got, want)
+		}
+	}
+}
+
+func TestGzipMiddleware_StatusWithoutBody(t *testing.T) {
+	h := GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("status=%d want=%d", rr.Code, http.StatusNoContent)
+	}
+	if rr.Body.Len() != 0 {
+		t.Fatalf("body=%q want empty", rr.Body.String())
+	}
+}
+
+func TestGzipMiddleware_DecompressesRequest(t *testing.T) {
+	var got string
+	h := GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("read body: %v", err)
+		}
+		got = string(b)
+		if ce := r.Header.Get("Content-Encoding"); ce != "" {
+			t.Fatalf("Content-Encoding=%q must be removed", ce)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/user/orders", bytes.NewReader(gzipBytes(t, "79927398713")))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Content-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("status=%d want=%d", rr.Code, http.StatusAccepted)
+	}
+	if got != "79927398713" {
+		t.Fatalf("body=%q want=%q", got, "79927398713")
+	}
+}
+
+func TestGzipMiddleware_InvalidGzipRequest(t *testing.T) {
+	called := false
+	h := GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/user/orders", strings.NewReader("not gzip"))
+	req.Header.Set("Content-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d want=%d", rr.Code, http.StatusBadRequest)
+	}
+	if called {
+		t.Fatalf("next handler must not be called")
+	}
+}
+
+func TestGzip_LimitsDecompressedBody(t *testing.T) {
+	called := false
+	h := Gzip(1024)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		body, _ := io.ReadAll(r.Body)
+		if len(body) != 1024 {
+			t.Fatalf("body len=%d want 1024", len(body))
+		}
+	}))
+
+	for _, tt := range []struct {
+		size int
+		want int
+	}{
+		{1024, http.StatusOK},
+		// пара килобайт сжатого тела распаковывается в мегабайт
+		{1 << 20, http.StatusRequestEntityTooLarge},
+	} {
+		called = false
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(gzipBytes(t, strings.Repeat("a", tt.size))))
+		req.Header.Set("Content-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != tt.want {
+			t.Fatalf("size %d: status=%d want=%d", tt.size, rr.Code, tt.want)
+		}
+		if called != (tt.want == http.StatusOK) {
+			t.Fatalf("size %d: handler called=%v", tt.size, called)
+		}
+	}
+}
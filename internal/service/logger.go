@@ -1,10 +1,20 @@
 package service
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"net/http"
+	"sync/atomic"
+	"time"
 )
 
+const RequestIDHeader = "X-Request-ID"
+
 func NewLogger() *zap.SugaredLogger {
 	core, err := zap.NewDevelopment()
 	if err != nil {
@@ -14,6 +24,125 @@ func NewLogger() *zap.SugaredLogger {
 	return core.Sugar()
 }
 
+// NewLoggerConfig собирает логгер с уровнем level (debug, info, warn, error)
+// и форматом format (json или console).
+func NewLoggerConfig(level, format string) (*zap.SugaredLogger, error) {
+	lvl, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return nil, fmt.Errorf("log level: %w", err)
+	}
+
+	var cfg zap.Config
+	switch format {
+	case "", "json":
+		cfg = zap.NewProductionConfig()
+		cfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	case "console":
+		cfg = zap.NewDevelopmentConfig()
+	default:
+		return nil, fmt.Errorf("log format: unknown %q", format)
+	}
+	cfg.Level = zap.NewAtomicLevelAt(lvl)
+
+	l, err := cfg.Build()
+	if err != nil {
+		return nil, err
+	}
+	return l.Sugar(), nil
+}
+
+type loggerCtxKey struct{}
+
+type requestInfoCtxKey struct{}
+
+type requestInfo struct {
+	userID atomic.Int64
+}
+
+// WithLogger кладёт логгер в контекст.
+func WithLogger(ctx context.Context, l *zap.SugaredLogger) context.Context {
+	return context.WithValue(ctx, loggerCtxKey{}, l)
+}
+
+// LoggerFrom достаёт логгер запроса (с request_id) или глобальный zap.S().
+func LoggerFrom(ctx context.Context) *zap.SugaredLogger {
+	return LoggerFromOr(ctx, zap.S())
+}
+
+// LoggerFromOr достаёт логгер запроса, а вне запроса возвращает fallback.
+func LoggerFromOr(ctx context.Context, fallback *zap.SugaredLogger) *zap.SugaredLogger {
+	if l, ok := ctx.Value(loggerCtxKey{}).(*zap.SugaredLogger); ok && l != nil {
+		return l
+	}
+	if fallback == nil {
+		return zap.S()
+	}
+	return fallback
+}
+
+// SetRequestUser запоминает пользователя запроса для итоговой строки access-лога.
+func SetRequestUser(ctx context.Context, userID int) {
+	if ri, ok := ctx.Value(requestInfoCtxKey{}).(*requestInfo); ok {
+		ri.userID.Store(int64(userID))
+	}
+}
+
+// RequestLogger пишет access-лог на каждый запрос и кладёт в контекст логгер
+// с request_id: его подхватывают хендлеры, репозиторий и клиент accrual.
+func RequestLogger(logger *zap.SugaredLogger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			reqID := r.Header.Get(RequestIDHeader)
+			if reqID == "" || len(reqID) > 128 {
+				reqID = newRequestID()
+			}
+			w.Header().Set(RequestIDHeader, reqID)
+
+			reqLogger := logger.With("request_id", reqID)
+			ri := &requestInfo{}
+
+			ctx := WithLogger(r.Context(), reqLogger)
+			ctx = context.WithValue(ctx, requestInfoCtxKey{}, ri)
+
+			lrw := NewLoggingResponseWriter(w)
+			next.ServeHTTP(lrw, r.WithContext(ctx))
+
+			status := lrw.ResponseData.Status
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			route := r.URL.Path
+			if rctx := chi.RouteContext(ctx); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+
+			fields := []any{
+				"method", r.Method,
+				"route", route,
+				"status", status,
+				"size", lrw.ResponseData.Size,
+				"latency", time.Since(start),
+			}
+			if uid := ri.userID.Load(); uid != 0 {
+				fields = append(fields, "user_id", uid)
+			}
+
+			reqLogger.Infow("request", fields...)
+		})
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
 type (
 	responseData struct {
 		Status int
@@ -1,10 +1,15 @@
 package service
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestLoggingResponseWriter_Write(t *testing.T) {
@@ -165,3 +170,111 @@ func TestNewLoggingResponseWriter(t *testing.T) {
 		}
 	})
 }
+
+func TestNewLoggerConfig(t *testing.T) {
+	tests := []struct {
+		level   string
+		format  string
+		wantErr bool
+	}{
+		{"info", "json", false},
+		{"debug", "console", false},
+		{"warn", "", false},
+		{"loud", "json", true},
+		{"info", "xml", true},
+	}
+
+	for _, tt := range tests {
+		l, err := NewLoggerConfig(tt.level, tt.format)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("NewLoggerConfig(%q, %q) err=%v wantErr=%v", tt.level, tt.format, err, tt.wantErr)
+		}
+		if err == nil && l == nil {
+			t.Fatalf("NewLoggerConfig(%q, %q) returned nil logger", tt.level, tt.format)
+		}
+	}
+}
+
+func TestRequestLogger_PropagatesRequestID(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	logger := zap.New(core).Sugar()
+
+	r := chi.NewRouter()
+	r.Use(RequestLogger(logger))
+	r.Get("/api/orders/{number}", func(w http.ResponseWriter, r *http.Request) {
+		SetRequestUser(r.Context(), 42)
+		LoggerFrom(r.Context()).Info("inside handler")
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short"))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/orders/123", nil)
+	req.Header.Set(RequestIDHeader, "req-1")
+	rr := httptest.NewRecorder()
+
+	r.ServeHTTP(rr, req)
+
+	if got := rr.Header().Get(RequestIDHeader); got != "req-1" {
+		t.Fatalf("response %s=%q want=%q", RequestIDHeader, got, "req-1")
+	}
+
+	entries := logs.All()
+	if len(entries) != 2 {
+		t.Fatalf("entries=%d want=2", len(entries))
+	}
+	for _, e := range entries {
+		if e.ContextMap()["request_id"] != "req-1" {
+			t.Fatalf("entry %q has no request_id: %v", e.Message, e.ContextMap())
+		}
+	}
+
+	access := entries[1].ContextMap()
+	if access["route"] != "/api/orders/{number}" {
+		t.Fatalf("route=%v", access["route"])
+	}
+	if access["status"] != int64(http.StatusTeapot) {
+		t.Fatalf("status=%v", access["status"])
+	}
+	if access["size"] != int64(len("short")) {
+		t.Fatalf("size=%v", access["size"])
+	}
+	if access["user_id"] != int64(42) {
+		t.Fatalf("user_id=%v", access["user_id"])
+	}
+	if access["method"] != http.MethodGet {
+		t.Fatalf("method=%v", access["method"])
+	}
+}
+
+func TestRequestLogger_GeneratesRequestID(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+
+	h := RequestLogger(zap.New(core).Sugar())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	id := rr.Header().Get(RequestIDHeader)
+	if id == "" {
+		t.Fatalf("request id was not generated")
+	}
+	if logs.Len() != 1 || logs.All()[0].ContextMap()["request_id"] != id {
+		t.Fatalf("access log does not carry generated request id")
+	}
+	if _, ok := logs.All()[0].ContextMap()["user_id"]; ok {
+		t.Fatalf("user_id must be omitted for anonymous requests")
+	}
+}
+
+func TestLoggerFromOr(t *testing.T) {
+	fallback := zap.NewNop().Sugar()
+	if LoggerFromOr(context.Background(), fallback) != fallback {
+		t.Fatalf("expected fallback logger outside of request")
+	}
+
+	reqLogger := zap.NewNop().Sugar()
+	ctx := WithLogger(context.Background(), reqLogger)
+	if LoggerFromOr(ctx, fallback) != reqLogger {
+		t.Fatalf("expected request logger from context")
+	}
+}
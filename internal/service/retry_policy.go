@@ -0,0 +1,233 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Classifier решает, стоит ли повторять попытку после err. wait > 0 — пауза,
+// которую требует сама сторона (Retry-After); быстрее неё повторять нельзя.
+type Classifier func(err error) (retry bool, wait time.Duration)
+
+// RetryHooks вызываются на каждом повторе и при отказе от операции, например для метрик.
+type RetryHooks struct {
+	OnRetry  func(op string, attempt int, delay time.Duration, err error)
+	OnGiveUp func(op string, attempts int, err error)
+}
+
+// RetryPolicy — повторы с экспоненциальной задержкой и full jitter:
+// перед попыткой n ждём случайное время из [0, min(MaxDelay, BaseDelay*Multiplier^(n-1))].
+// Повторяем, только если ошибку признал временной хотя бы один из Classifiers.
+type RetryPolicy struct {
+	// Op — имя операции для хуков и ошибок.
+	Op string
+	// MaxAttempts — сколько всего попыток, включая первую. 0 — ограничивает только MaxElapsed.
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// MaxElapsed — общий бюджет на все попытки и паузы. 0 — без ограничения.
+	MaxElapsed time.Duration
+	// Multiplier — рост задержки между попытками, по умолчанию 2.
+	Multiplier  int
+	Classifiers []Classifier
+	Hooks       RetryHooks
+	// Jitter выбирает задержку не больше d; по умолчанию FullJitter.
+	Jitter func(d time.Duration) time.Duration
+}
+
+// FullJitter — равномерно случайная задержка из [0, d].
+func FullJitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(d) + 1))
+}
+
+// NoJitter — детерминированная задержка, для тестов.
+func NoJitter(d time.Duration) time.Duration {
+	return d
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 && p.MaxElapsed <= 0 {
+		p.MaxAttempts = 1
+	}
+	if p.Multiplier <= 0 {
+		p.Multiplier = 2
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = p.BaseDelay
+	}
+	if p.Jitter == nil {
+		p.Jitter = FullJitter
+	}
+	return p
+}
+
+// backoff — потолок задержки перед попыткой attempt+1 (attempt — номер неудачной попытки).
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && d < p.MaxDelay; i++ {
+		d *= time.Duration(p.Multiplier)
+	}
+	return min(d, p.MaxDelay)
+}
+
+func (p RetryPolicy) classify(err error) (bool, time.Duration) {
+	for _, c := range p.Classifiers {
+		if retry, wait := c(err); retry {
+			return true, wait
+		}
+	}
+	return false, 0
+}
+
+// Do вызывает fn, пока она не выполнится, ошибка не окажется постоянной или не кончится бюджет.
+// Постоянная ошибка и ошибка единственной попытки возвращаются как есть; после
+// нескольких попыток — обёрнутой в "after N attempts". Отмена ctx прерывает паузу.
+func (p RetryPolicy) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	p = p.withDefaults()
+	start := time.Now()
+
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+
+		retry, wait := p.classify(err)
+		if !retry {
+			return err
+		}
+
+		delay := max(p.Jitter(p.backoff(attempt)), wait)
+		if !p.canWait(ctx, start, attempt, delay) {
+			return p.giveUp(attempt, err)
+		}
+
+		if p.Hooks.OnRetry != nil {
+			p.Hooks.OnRetry(p.Op, attempt, delay, err)
+		}
+		if serr := SleepContext(ctx, delay); serr != nil {
+			return errors.Join(err, serr)
+		}
+	}
+}
+
+// canWait проверяет, что после паузы delay останется хотя бы одна попытка
+// и в бюджет MaxElapsed, и в дедлайн ctx.
+func (p RetryPolicy) canWait(ctx context.Context, start time.Time, attempt int, delay time.Duration) bool {
+	if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+		return false
+	}
+	if p.MaxElapsed > 0 && time.Since(start)+delay >= p.MaxElapsed {
+		return false
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+		return false
+	}
+	return true
+}
+
+func (p RetryPolicy) giveUp(attempts int, err error) error {
+	if p.Hooks.OnGiveUp != nil {
+		p.Hooks.OnGiveUp(p.Op, attempts, err)
+	}
+	if attempts == 1 {
+		return err
+	}
+	return fmt.Errorf("after %d attempts, last error: %w", attempts, err)
+}
+
+// RetryValue — Do для функций, возвращающих значение.
+func RetryValue[T any](ctx context.Context, p RetryPolicy, fn func(ctx context.Context) (T, error)) (T, error) {
+	var res T
+	err := p.Do(ctx, func(ctx context.Context) error {
+		var err error
+		res, err = fn(ctx)
+		return err
+	})
+	return res, err
+}
+
+// RetryPgTransient — временные ошибки Postgres: конфликт сериализации (40001), дедлок,
+// нехватка соединений, обрыв соединения. Обрыв повторяем, только если pgx
+// гарантирует, что запрос не ушёл на сервер: иначе INSERT мог выполниться дважды.
+func RetryPgTransient(err error) (bool, time.Duration) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case pgerrcode.SerializationFailure,
+			pgerrcode.DeadlockDetected,
+			pgerrcode.TooManyConnections,
+			pgerrcode.ConnectionFailure,
+			pgerrcode.ConnectionException,
+			pgerrcode.SQLClientUnableToEstablishSQLConnection:
+			return true, 0
+		}
+		return false, 0
+	}
+	return pgconn.SafeToRetry(err), 0
+}
+
+// RetryConnReset — сетевые обрывы. Подходит только для идемпотентных операций.
+func RetryConnReset(err error) (bool, time.Duration) {
+	switch {
+	case errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return true, 0
+	}
+	return false, 0
+}
+
+// HTTPError — неуспешный HTTP-ответ. RetryAfter заполняется из заголовка Retry-After.
+type HTTPError struct {
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("unexpected status code=%d", e.StatusCode)
+}
+
+// RetryHTTP — 5xx и 429; для 429 ждём не меньше Retry-After.
+func RetryHTTP(err error) (bool, time.Duration) {
+	var he *HTTPError
+	if !errors.As(err, &he) {
+		return false, 0
+	}
+	switch {
+	case he.StatusCode == http.StatusTooManyRequests:
+		return true, he.RetryAfter
+	case he.StatusCode >= http.StatusInternalServerError:
+		return true, 0
+	}
+	return false, 0
+}
+
+// SleepContext ждёт d; отмена ctx прерывает ожидание и возвращает ctx.Err().
+func SleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestRetryPolicy_BackoffGrowsUpToMaxDelay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}.withDefaults()
+
+	want := []time.Duration{10, 20, 40, 50, 50}
+	for i, w := range want {
+		if got := p.backoff(i + 1); got != w*time.Millisecond {
+			t.Fatalf("backoff(%d)=%s want=%s", i+1, got, w*time.Millisecond)
+		}
+	}
+}
+
+func TestFullJitter_WithinBounds(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		if d := FullJitter(time.Millisecond); d < 0 || d > time.Millisecond {
+			t.Fatalf("FullJitter=%s out of [0, 1ms]", d)
+		}
+	}
+	if FullJitter(0) != 0 {
+		t.Fatalf("FullJitter(0) must be 0")
+	}
+}
+
+func TestRetryPolicy_RetriesTransientThenSucceeds(t *testing.T) {
+	var retries, giveUps int
+	p := RetryPolicy{
+		Op:          "test",
+		MaxAttempts: 5,
+		Classifiers: []Classifier{RetryPgTransient},
+		Hooks: RetryHooks{
+			OnRetry:  func(op string, _ int, _ time.Duration, _ error) { retries++ },
+			OnGiveUp: func(string, int, error) { giveUps++ },
+		},
+	}
+
+	calls := 0
+	got, err := RetryValue(context.Background(), p, func(context.Context) (int, error) {
+		calls++
+		if calls < 3 {
+			return 0, pgErr(pgerrcode.SerializationFailure)
+		}
+		return 42, nil
+	})
+	if err != nil || got != 42 {
+		t.Fatalf("got=%d err=%v", got, err)
+	}
+	if calls != 3 || retries != 2 || giveUps != 0 {
+		t.Fatalf("calls=%d retries=%d giveUps=%d", calls, retries, giveUps)
+	}
+}
+
+func TestRetryPolicy_PermanentErrorIsNotRetried(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, Classifiers: []Classifier{RetryPgTransient, RetryHTTP}}
+
+	calls := 0
+	want := pgErr(pgerrcode.UniqueViolation)
+	err := p.Do(context.Background(), func(context.Context) error {
+		calls++
+		return want
+	})
+	if err != want || calls != 1 {
+		t.Fatalf("err=%v calls=%d, want the original error after one call", err, calls)
+	}
+}
+
+func TestRetryPolicy_ExhaustedAttemptsWrapLastError(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3, Classifiers: []Classifier{RetryPgTransient}, Jitter: NoJitter}
+
+	calls := 0
+	err := p.Do(context.Background(), func(context.Context) error {
+		calls++
+		return pgErr(pgerrcode.DeadlockDetected)
+	})
+	if calls != 3 || !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Fatalf("err=%v calls=%d, want 3 calls and a wrapped error", err, calls)
+	}
+	var pe *pgconn.PgError
+	if !errors.As(err, &pe) || pe.Code != pgerrcode.DeadlockDetected {
+		t.Fatalf("err=%v, want the last PgError kept", err)
+	}
+}
+
+func TestRetryPolicy_HonoursRetryAfter(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, Classifiers: []Classifier{RetryHTTP}}
+
+	calls := 0
+	start := time.Now()
+	err := p.Do(context.Background(), func(context.Context) error {
+		calls++
+		if calls == 1 {
+			return &HTTPError{StatusCode: http.StatusTooManyRequests, RetryAfter: 30 * time.Millisecond}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err=%v", err)
+	}
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Fatalf("retried after %s, Retry-After was 30ms", d)
+	}
+}
+
+func TestRetryPolicy_RetryAfterBeyondDeadlineGivesUp(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	p := RetryPolicy{MaxAttempts: 5, Classifiers: []Classifier{RetryHTTP}}
+	start := time.Now()
+	err := p.Do(ctx, func(context.Context) error {
+		return &HTTPError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Minute}
+	})
+
+	var he *HTTPError
+	if !errors.As(err, &he) || he.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("err=%v want the 429 error", err)
+	}
+	if time.Since(start) > 40*time.Millisecond {
+		t.Fatalf("policy slept although Retry-After exceeds the deadline")
+	}
+}
+
+func TestRetryPolicy_MaxElapsed(t *testing.T) {
+	giveUps := 0
+	p := RetryPolicy{
+		BaseDelay:   5 * time.Millisecond,
+		MaxDelay:    5 * time.Millisecond,
+		MaxElapsed:  30 * time.Millisecond,
+		Classifiers: []Classifier{RetryHTTP},
+		Jitter:      NoJitter,
+		Hooks:       RetryHooks{OnGiveUp: func(string, int, error) { giveUps++ }},
+	}
+
+	calls := 0
+	start := time.Now()
+	err := p.Do(context.Background(), func(context.Context) error {
+		calls++
+		return &HTTPError{StatusCode: http.StatusBadGateway}
+	})
+	if err == nil || calls < 2 {
+		t.Fatalf("err=%v calls=%d", err, calls)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Fatalf("MaxElapsed=30ms, but retried for %s", d)
+	}
+	if giveUps != 1 {
+		t.Fatalf("giveUps=%d want=1", giveUps)
+	}
+}
+
+func TestRetryPolicy_CancelAbortsSleep(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute, Classifiers: []Classifier{RetryConnReset}, Jitter: NoJitter}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	err := p.Do(ctx, func(context.Context) error { return syscall.ECONNRESET })
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("err=%v want ECONNRESET joined with context.Canceled", err)
+	}
+}
+
+func TestClassifiers(t *testing.T) {
+	tests := []struct {
+		name string
+		c    Classifier
+		err  error
+		want bool
+	}{
+		{"pg serialization", RetryPgTransient, pgErr(pgerrcode.SerializationFailure), true},
+		{"pg deadlock", RetryPgTransient, fmt.Errorf("wrapped: %w", pgErr(pgerrcode.DeadlockDetected)), true},
+		{"pg unique", RetryPgTransient, pgErr(pgerrcode.UniqueViolation), false},
+		{"pg plain error", RetryPgTransient, errors.New("boom"), false},
+		{"conn reset", RetryConnReset, fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{"unexpected eof", RetryConnReset, io.ErrUnexpectedEOF, true},
+		{"other net error", RetryConnReset, errors.New("no such host"), false},
+		{"http 503", RetryHTTP, &HTTPError{StatusCode: http.StatusServiceUnavailable}, true},
+		{"http 429", RetryHTTP, &HTTPError{StatusCode: http.StatusTooManyRequests}, true},
+		{"http 404", RetryHTTP, &HTTPError{StatusCode: http.StatusNotFound}, false},
+	}
+	for _, tt := range tests {
+		if got, _ := tt.c(tt.err); got != tt.want {
+			t.Fatalf("%s: retry=%v want=%v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -2,30 +2,72 @@ package service
 
 import (
 	"sync"
+	"time"
 )
 
+type Session struct {
+	Login     string
+	CreatedAt time.Time
+	ExpiresAt time.Time // нулевое значение — без срока
+}
+
 type MemSessionStorage struct {
-	sessions map[string]string
+	sessions map[string]Session
+	ttl      time.Duration
 	mu       sync.RWMutex
 }
 
 func NewMemStorage() *MemSessionStorage {
+	return NewMemStorageTTL(0)
+}
+
+// NewMemStorageTTL создаёт хранилище, в котором сессии истекают через ttl.
+// ttl <= 0 — сессии живут до DeleteSession.
+func NewMemStorageTTL(ttl time.Duration) *MemSessionStorage {
 	return &MemSessionStorage{
-		sessions: make(map[string]string),
+		sessions: make(map[string]Session),
+		ttl:      ttl,
+	}
+}
+
+// TTL возвращает время жизни сессии; 0 — без ограничения.
+func (ms *MemSessionStorage) TTL() time.Duration {
+	if ms.ttl < 0 {
+		return 0
 	}
+	return ms.ttl
 }
 
 func (ms *MemSessionStorage) GetSession(sessionID string) (string, bool) {
+	s, ok := ms.Lookup(sessionID)
+	return s.Login, ok
+}
+
+// Lookup возвращает сессию целиком; истёкшая сессия удаляется.
+func (ms *MemSessionStorage) Lookup(sessionID string) (Session, bool) {
 	ms.mu.RLock()
-	defer ms.mu.RUnlock()
-	val, ok := ms.sessions[sessionID]
-	return val, ok
+	s, ok := ms.sessions[sessionID]
+	ms.mu.RUnlock()
+	if !ok {
+		return Session{}, false
+	}
+
+	if !s.ExpiresAt.IsZero() && time.Now().After(s.ExpiresAt) {
+		ms.DeleteSession(sessionID)
+		return Session{}, false
+	}
+	return s, true
 }
 
 func (ms *MemSessionStorage) AddSession(sessionID string, login string) {
+	s := Session{Login: login, CreatedAt: time.Now()}
+	if ms.ttl > 0 {
+		s.ExpiresAt = s.CreatedAt.Add(ms.ttl)
+	}
+
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
-	ms.sessions[sessionID] = login
+	ms.sessions[sessionID] = s
 }
 
 func (ms *MemSessionStorage) DeleteSession(sessionID string) {
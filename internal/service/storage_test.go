@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestMemSessionStorage_CRUD(t *testing.T) {
@@ -118,3 +119,17 @@ func TestMemSessionStorage_ConcurrentSameKey(t *testing.T) {
 		t.Fatalf("expected shared session to exist after writes")
 	}
 }
+
+func TestMemSessionStorage_TTLExpires(t *testing.T) {
+	ms := NewMemStorageTTL(20 * time.Millisecond)
+
+	ms.AddSession("s1", "user1")
+	if v, ok := ms.GetSession("s1"); !ok || v != "user1" {
+		t.Fatalf("expected fresh session, got ok=%v v=%q", ok, v)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if v, ok := ms.GetSession("s1"); ok || v != "" {
+		t.Fatalf("expected expired session, got ok=%v v=%q", ok, v)
+	}
+}
@@ -0,0 +1,144 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func useRecorder(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+
+	old := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() { otel.SetTracerProvider(old) })
+
+	if _, err := Setup(context.Background(), Config{Exporter: ExporterNone}); err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+	return rec
+}
+
+func TestMiddleware_NamesSpanByRouteAndContinuesTrace(t *testing.T) {
+	rec := useRecorder(t)
+
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/api/orders/{number}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/orders/123", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := rec.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("spans=%d want=1", len(spans))
+	}
+	s := spans[0]
+	if s.Name() != "GET /api/orders/{number}" {
+		t.Fatalf("name=%q", s.Name())
+	}
+	if got := s.SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("trace id=%s, incoming traceparent ignored", got)
+	}
+	if s.Status().Code != codes.Error {
+		t.Fatalf("status=%v want error for 500", s.Status().Code)
+	}
+}
+
+func TestStartQuery_NoArgumentsRecorded(t *testing.T) {
+	rec := useRecorder(t)
+
+	_, span := StartQuery(context.Background(), "GetUserByLogin")
+	Finish(span, errors.New("boom"))
+
+	spans := rec.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("spans=%d want=1", len(spans))
+	}
+	s := spans[0]
+	if s.Name() != "repo.GetUserByLogin" {
+		t.Fatalf("name=%q", s.Name())
+	}
+	if s.Status().Code != codes.Error {
+		t.Fatalf("status=%v want error", s.Status().Code)
+	}
+
+	found := false
+	for _, kv := range s.Attributes() {
+		if kv.Key == "db.statement.name" && kv.Value.AsString() == "GetUserByLogin" {
+			found = true
+		}
+		if kv.Key == "db.statement" {
+			t.Fatalf("raw statement must not be recorded")
+		}
+	}
+	if !found {
+		t.Fatalf("db.statement.name attribute missing: %v", s.Attributes())
+	}
+}
+
+func TestInject_WritesTraceparent(t *testing.T) {
+	useRecorder(t)
+
+	ctx, span := Tracer().Start(context.Background(), "parent")
+	defer span.End()
+
+	h := http.Header{}
+	Inject(ctx, h)
+
+	if h.Get("traceparent") == "" {
+		t.Fatalf("traceparent header not injected")
+	}
+}
+
+func TestSetup_FileExporter(t *testing.T) {
+	old := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(old) })
+
+	path := filepath.Join(t.TempDir(), "traces.json")
+
+	shutdown, err := Setup(context.Background(), Config{Exporter: ExporterFile, File: path})
+	if err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+
+	_, span := Tracer().Start(context.Background(), "test-span")
+	span.End()
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if len(b) == 0 {
+		t.Fatalf("trace file is empty")
+	}
+}
+
+func TestSetup_UnknownExporter(t *testing.T) {
+	if _, err := Setup(context.Background(), Config{Exporter: "jaeger"}); err == nil {
+		t.Fatalf("expected error for unknown exporter")
+	}
+	if _, err := Setup(context.Background(), Config{Exporter: ExporterFile}); err == nil {
+		t.Fatalf("expected error for file exporter without path")
+	}
+}
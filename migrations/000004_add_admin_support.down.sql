@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS balance_adjustments;
+
+ALTER TABLE users DROP COLUMN IF EXISTS sessions_revoked_at;
+ALTER TABLE users DROP COLUMN IF EXISTS disabled_at;
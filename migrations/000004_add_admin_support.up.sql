@@ -0,0 +1,18 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS sessions_revoked_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS balance_adjustments (
+    id          BIGSERIAL PRIMARY KEY,
+    user_id     BIGINT NOT NULL,
+    amount      BIGINT NOT NULL,
+    reason      TEXT NOT NULL,
+    created_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT fk_balance_adjustments_user
+    FOREIGN KEY (user_id)
+    REFERENCES users (id)
+    ON DELETE RESTRICT
+    );
+
+CREATE INDEX IF NOT EXISTS balance_adjustments_user_created_idx
+    ON balance_adjustments (user_id, created_at);
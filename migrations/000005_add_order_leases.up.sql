@@ -0,0 +1,2 @@
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS locked_by TEXT;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS orders_pending_next_poll_idx;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS attempts;
+ALTER TABLE orders DROP COLUMN IF EXISTS next_poll_at;
@@ -0,0 +1,7 @@
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS next_poll_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
+
+-- частичный индекс держит только незавершённые заказы и не растёт вместе с историей
+CREATE INDEX IF NOT EXISTS orders_pending_next_poll_idx
+    ON orders (next_poll_at, uploaded_at)
+    WHERE status NOT IN ('PROCESSED', 'INVALID');
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS orders_pending_next_poll_idx;
+CREATE INDEX IF NOT EXISTS orders_pending_next_poll_idx
+    ON orders (next_poll_at, uploaded_at)
+    WHERE status NOT IN ('PROCESSED', 'INVALID');
+
+DROP TABLE IF EXISTS accrual_quarantine;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS parked;
@@ -0,0 +1,27 @@
+-- parked: заказ снят с опроса, пока его не разберёт администратор
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS parked VARCHAR(32);
+
+CREATE TABLE IF NOT EXISTS accrual_quarantine (
+    id            BIGSERIAL PRIMARY KEY,
+    order_number  BIGINT NOT NULL,
+    reason        VARCHAR(64) NOT NULL,
+    detail        TEXT NOT NULL,
+    payload       TEXT NOT NULL,
+    created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    resolved_at   TIMESTAMP,
+    resolution    VARCHAR(16),
+
+    CONSTRAINT fk_accrual_quarantine_order
+    FOREIGN KEY (order_number)
+    REFERENCES orders (number)
+    ON DELETE CASCADE
+    );
+
+CREATE UNIQUE INDEX IF NOT EXISTS accrual_quarantine_open_order_idx
+    ON accrual_quarantine (order_number)
+    WHERE resolved_at IS NULL;
+
+DROP INDEX IF EXISTS orders_pending_next_poll_idx;
+CREATE INDEX IF NOT EXISTS orders_pending_next_poll_idx
+    ON orders (next_poll_at, uploaded_at)
+    WHERE status NOT IN ('PROCESSED', 'INVALID') AND parked IS NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS order_goods;
+DROP TABLE IF EXISTS order_receipts;
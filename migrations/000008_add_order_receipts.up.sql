@@ -0,0 +1,34 @@
+-- чек заказа, загруженный пользователем, и регистрация его в системе расчёта
+CREATE TABLE IF NOT EXISTS order_receipts (
+    order_number     BIGINT PRIMARY KEY,
+    status           VARCHAR(16) NOT NULL DEFAULT 'PENDING',
+    attempts         INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_error       TEXT,
+    registered_at    TIMESTAMP,
+    created_at       TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT fk_order_receipts_order
+    FOREIGN KEY (order_number)
+    REFERENCES orders (number)
+    ON DELETE CASCADE
+    );
+
+CREATE INDEX IF NOT EXISTS order_receipts_pending_idx
+    ON order_receipts (next_attempt_at)
+    WHERE status = 'PENDING';
+
+CREATE TABLE IF NOT EXISTS order_goods (
+    id            BIGSERIAL PRIMARY KEY,
+    order_number  BIGINT NOT NULL,
+    description   TEXT NOT NULL,
+    price         BIGINT NOT NULL,
+
+    CONSTRAINT fk_order_goods_receipt
+    FOREIGN KEY (order_number)
+    REFERENCES order_receipts (order_number)
+    ON DELETE CASCADE
+    );
+
+CREATE INDEX IF NOT EXISTS order_goods_order_idx
+    ON order_goods (order_number);
@@ -0,0 +1,5 @@
+ALTER TABLE users
+    ALTER COLUMN disabled_at TYPE TIMESTAMP
+        USING disabled_at AT TIME ZONE current_setting('TimeZone'),
+    ALTER COLUMN sessions_revoked_at TYPE TIMESTAMP
+        USING sessions_revoked_at AT TIME ZONE current_setting('TimeZone');
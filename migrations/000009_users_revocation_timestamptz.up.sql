@@ -0,0 +1,8 @@
+-- время блокировки и отзыва сессий сравнивается с временем создания сессии в Go;
+-- без часового пояса значение читалось бы как UTC при любом TimeZone базы.
+-- Старые значения записаны NOW() в поясе сессии, в нём их и понимаем.
+ALTER TABLE users
+    ALTER COLUMN disabled_at TYPE TIMESTAMPTZ
+        USING disabled_at AT TIME ZONE current_setting('TimeZone'),
+    ALTER COLUMN sessions_revoked_at TYPE TIMESTAMPTZ
+        USING sessions_revoked_at AT TIME ZONE current_setting('TimeZone');
@@ -0,0 +1,8 @@
+// Package migrations встраивает SQL-миграции в бинарник, чтобы сервер
+// и gophermart migrate не зависели от рабочего каталога.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS